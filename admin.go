@@ -0,0 +1,795 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// adminArtifactExts is the extensions of the artifacts of a cached module
+// version.
+var adminArtifactExts = []string{".info", ".mod", ".zip", ".provenance"}
+
+// Admin is the administration API of a `Goproxy`. It implements the
+// `http.Handler` and is meant to be mounted separately from the `Goproxy`.
+//
+// The `Admin` operates on the `Goproxy.Cacher` and serves:
+//
+//	GET    /modules?prefix=<module path prefix>
+//	GET    /modules/<module>/@v/<version>
+//	DELETE /modules/<module>/@v/<version>
+//	POST   /modules/<module>/@v/<version>/verify
+//	POST   /modules/<module>/@v/<version>/fetch[?force=true]
+//	POST   /prefetch?format=<go.mod|go.sum|list>[&transitive=true]
+//
+// where the <module> and the <version> are escaped as in the module proxy
+// protocol. Listing requires the `Goproxy.Cacher` to implement the
+// `CacheLister`, deleting and forced fetching require it to implement the
+// `CacheDeleter`. The "/prefetch" takes a body in the format parsed by the
+// `ParsePrefetchList` and responds with JSON lines, one `PrefetchProgress` per
+// module version followed by the final `PrefetchReport`.
+//
+// Every request must be authenticated by the `Token` or by the `Username` and
+// the `Password`. The HTTP basic authentication is only enabled if both the
+// `Username` and the `Password` are set. If neither authentication is enabled,
+// every request is rejected.
+type Admin struct {
+	// Goproxy is the `Goproxy` to be administered.
+	Goproxy *Goproxy
+
+	// PathPrefix is the prefix of all request paths. It will be used to
+	// trim the request paths.
+	//
+	// Default value: ""
+	PathPrefix string
+
+	// Username is the username of the HTTP basic authentication.
+	//
+	// Default value: ""
+	Username string
+
+	// Password is the password of the HTTP basic authentication.
+	//
+	// Default value: ""
+	Password string
+
+	// Token is the token of the HTTP bearer authentication.
+	//
+	// Default value: ""
+	Token string
+}
+
+// adminModule is a cached module reported by the `Admin`.
+type adminModule struct {
+	Path     string
+	Versions []string
+}
+
+// adminModuleVersion is a cached module version reported by the `Admin`.
+type adminModuleVersion struct {
+	Path      string
+	Version   string
+	Artifacts []*adminArtifact
+}
+
+// adminArtifact is a cached artifact of a module version reported by the
+// `Admin`.
+type adminArtifact struct {
+	Name     string
+	MIMEType string
+	Size     int64
+	ModTime  time.Time
+	Checksum []byte
+}
+
+// adminVerification is the result of a verification done by the `Admin`.
+type adminVerification struct {
+	Path     string
+	Version  string
+	Verified bool
+	Error    string `json:",omitempty"`
+}
+
+// ServeHTTP implements the `http.Handler`.
+func (a *Admin) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	// All operations of the request are done with the same snapshot of
+	// the config of the `Goproxy`.
+	g, release := a.Goproxy.acquireSnapshot()
+	defer release()
+
+	sa := *a
+	sa.Goproxy = g
+	a = &sa
+
+	setResponseCacheControlHeader(rw, -1)
+
+	if !a.authenticated(r) {
+		if a.basicAuthEnabled() {
+			rw.Header().Set(
+				"WWW-Authenticate",
+				`Basic realm="goproxy admin"`,
+			)
+		} else {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+		}
+
+		responseUnauthorized(rw)
+		return
+	}
+
+	rl := g.newRequestLogger(r)
+	rw.Header().Set("X-Request-ID", rl.requestID)
+
+	if g.Cacher == nil {
+		responseNotImplemented(rw, "no cacher")
+		return
+	}
+
+	trimmedPath := path.Clean(r.URL.Path)
+	trimmedPath = strings.TrimPrefix(trimmedPath, a.PathPrefix)
+	trimmedPath = strings.Trim(trimmedPath, "/")
+
+	if trimmedPath == "modules" {
+		if r.Method != http.MethodGet {
+			responseMethodNotAllowed(rw)
+			return
+		}
+
+		rl.operation = "admin.list"
+		a.list(rw, r, rl)
+
+		return
+	}
+
+	if trimmedPath == "prefetch" {
+		if r.Method != http.MethodPost {
+			responseMethodNotAllowed(rw)
+			return
+		}
+
+		rl.operation = "admin.prefetch"
+		a.prefetch(rw, r, rl)
+
+		return
+	}
+
+	if !strings.HasPrefix(trimmedPath, "modules/") {
+		responseNotFound(rw)
+		return
+	}
+
+	nameParts := strings.Split(
+		strings.TrimPrefix(trimmedPath, "modules/"),
+		"/@v/",
+	)
+	if len(nameParts) != 2 {
+		responseNotFound(rw)
+		return
+	}
+
+	versionParts := strings.SplitN(nameParts[1], "/", 2)
+
+	action := ""
+	if len(versionParts) == 2 {
+		action = versionParts[1]
+	}
+
+	modulePath, err := module.UnescapePath(nameParts[0])
+	if err != nil {
+		responseNotFound(rw, err)
+		return
+	}
+
+	moduleVersion, err := module.UnescapeVersion(versionParts[0])
+	if err != nil {
+		responseNotFound(rw, err)
+		return
+	}
+
+	if !semver.IsValid(moduleVersion) {
+		responseNotFound(rw, "invalid version")
+		return
+	}
+
+	rl.modulePath = modulePath
+	rl.moduleVersion = moduleVersion
+
+	namePrefix := fmt.Sprint(nameParts[0], "/@v/", versionParts[0])
+
+	operation, method := "", http.MethodPost
+	switch action {
+	case "":
+		switch method = r.Method; method {
+		case http.MethodGet:
+			operation = "stat"
+		case http.MethodDelete:
+			operation = "delete"
+		default:
+			responseMethodNotAllowed(rw)
+			return
+		}
+	case "verify", "fetch":
+		operation = action
+	default:
+		responseNotFound(rw)
+		return
+	}
+
+	if r.Method != method {
+		responseMethodNotAllowed(rw)
+		return
+	}
+
+	rl.operation = fmt.Sprint("admin.", operation)
+
+	switch operation {
+	case "stat":
+		a.stat(rw, r, rl, modulePath, moduleVersion, namePrefix)
+	case "delete":
+		a.delete(rw, r, rl, namePrefix)
+	case "verify":
+		a.verify(rw, r, rl, modulePath, moduleVersion, namePrefix)
+	case "fetch":
+		a.fetch(rw, r, rl, modulePath, moduleVersion, namePrefix)
+	}
+}
+
+// basicAuthEnabled reports whether the HTTP basic authentication of the a is
+// enabled.
+func (a *Admin) basicAuthEnabled() bool {
+	return a.Username != "" && a.Password != ""
+}
+
+// authenticated reports whether the r is authenticated.
+func (a *Admin) authenticated(r *http.Request) bool {
+	if a.Token != "" {
+		authorization := r.Header.Get("Authorization")
+		if strings.HasPrefix(authorization, "Bearer ") &&
+			secureCompare(
+				strings.TrimPrefix(authorization, "Bearer "),
+				a.Token,
+			) {
+			return true
+		}
+	}
+
+	if a.basicAuthEnabled() {
+		username, password, ok := r.BasicAuth()
+		if ok &&
+			secureCompare(username, a.Username) &&
+			secureCompare(password, a.Password) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// list lists the cached modules and versions.
+func (a *Admin) list(
+	rw http.ResponseWriter,
+	r *http.Request,
+	rl *requestLogger,
+) {
+	cl, ok := a.Goproxy.Cacher.(CacheLister)
+	if !ok {
+		responseNotImplemented(rw, "cacher cannot list")
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	escapedPrefix, err := module.EscapePath(prefix)
+	if err != nil {
+		// A partial module path may not be valid on its own.
+		escapedPrefix = prefix
+	}
+
+	names, err := cl.Caches(r.Context(), escapedPrefix)
+	if err != nil {
+		rl.logError(err)
+		responseInternalServerError(rw)
+		return
+	}
+
+	versions := map[string]map[string]bool{}
+	for _, name := range names {
+		modulePath, moduleVersion, ok := parseCacheName(name)
+		if !ok || !strings.HasPrefix(modulePath, prefix) {
+			continue
+		}
+
+		if versions[modulePath] == nil {
+			versions[modulePath] = map[string]bool{}
+		}
+
+		versions[modulePath][moduleVersion] = true
+	}
+
+	modules := make([]*adminModule, 0, len(versions))
+	for modulePath, vs := range versions {
+		am := &adminModule{Path: modulePath}
+		for v := range vs {
+			am.Versions = append(am.Versions, v)
+		}
+
+		sort.Slice(am.Versions, func(i, j int) bool {
+			return semver.Compare(
+				am.Versions[i],
+				am.Versions[j],
+			) < 0
+		})
+
+		modules = append(modules, am)
+	}
+
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Path < modules[j].Path
+	})
+
+	responseJSON(rw, http.StatusOK, modules)
+}
+
+// stat reports the cached artifacts of the moduleVersion of the modulePath.
+func (a *Admin) stat(
+	rw http.ResponseWriter,
+	r *http.Request,
+	rl *requestLogger,
+	modulePath string,
+	moduleVersion string,
+	namePrefix string,
+) {
+	amv, err := a.moduleVersion(
+		r.Context(),
+		modulePath,
+		moduleVersion,
+		namePrefix,
+	)
+	if err != nil {
+		rl.logError(err)
+		responseInternalServerError(rw)
+		return
+	}
+
+	if len(amv.Artifacts) == 0 {
+		responseNotFound(rw)
+		return
+	}
+
+	responseJSON(rw, http.StatusOK, amv)
+}
+
+// delete deletes the cached artifacts of the namePrefix.
+func (a *Admin) delete(
+	rw http.ResponseWriter,
+	r *http.Request,
+	rl *requestLogger,
+	namePrefix string,
+) {
+	deleted, err := a.deleteCaches(r.Context(), namePrefix)
+	if err != nil {
+		if err == errAdminCannotDelete {
+			responseNotImplemented(rw, err)
+			return
+		}
+
+		rl.logError(err)
+		responseInternalServerError(rw)
+		return
+	}
+
+	if len(deleted) == 0 {
+		responseNotFound(rw)
+		return
+	}
+
+	rl.log(LogLevelInfo, "deleted caches", "names", deleted)
+
+	responseJSON(rw, http.StatusOK, deleted)
+}
+
+// verify verifies the cached ".zip" and ".mod" of the moduleVersion of the
+// modulePath against the checksum database.
+func (a *Admin) verify(
+	rw http.ResponseWriter,
+	r *http.Request,
+	rl *requestLogger,
+	modulePath string,
+	moduleVersion string,
+	namePrefix string,
+) {
+	g := a.Goproxy
+	if g.goBinEnv["GOSUMDB"] == "off" ||
+		globsMatchPath(g.goBinEnv["GONOSUMDB"], modulePath) {
+		responseNotImplemented(rw, "checksum database disabled")
+		return
+	}
+
+	zipHash, err := a.cacheHash(
+		r.Context(),
+		fmt.Sprint(namePrefix, ".zip"),
+		true,
+	)
+	if err != nil {
+		if err == ErrCacheNotFound {
+			responseNotFound(rw)
+			return
+		}
+
+		rl.logError(err)
+		responseInternalServerError(rw)
+		return
+	}
+
+	goModHash, err := a.cacheHash(
+		r.Context(),
+		fmt.Sprint(namePrefix, ".mod"),
+		false,
+	)
+	if err != nil {
+		if err == ErrCacheNotFound {
+			responseNotFound(rw)
+			return
+		}
+
+		rl.logError(err)
+		responseInternalServerError(rw)
+		return
+	}
+
+	av := &adminVerification{
+		Path:     modulePath,
+		Version:  moduleVersion,
+		Verified: true,
+	}
+	for _, check := range []struct {
+		version string
+		line    string
+	}{
+		{
+			moduleVersion,
+			fmt.Sprintf(
+				"%s %s %s",
+				modulePath,
+				moduleVersion,
+				zipHash,
+			),
+		},
+		{
+			fmt.Sprint(moduleVersion, "/go.mod"),
+			fmt.Sprintf(
+				"%s %s/go.mod %s",
+				modulePath,
+				moduleVersion,
+				goModHash,
+			),
+		},
+	} {
+		lines, err := g.sumdbLookup(
+			r.Context(),
+			modulePath,
+			check.version,
+		)
+		if err != nil {
+			rl.logError(err)
+			responseBadGateway(rw)
+			return
+		}
+
+		if !stringSliceContains(lines, check.line) {
+			av.Verified = false
+			av.Error = (&untrustedRevisionError{
+				moduleVersion,
+			}).Error()
+			break
+		}
+	}
+
+	if !av.Verified {
+		rl.log(LogLevelWarn, "untrusted cached module version")
+	}
+
+	responseJSON(rw, http.StatusOK, av)
+}
+
+// fetch fetches the moduleVersion of the modulePath and sets it to the cacher.
+// Existing caches are deleted first if the "force" query is "true".
+func (a *Admin) fetch(
+	rw http.ResponseWriter,
+	r *http.Request,
+	rl *requestLogger,
+	modulePath string,
+	moduleVersion string,
+	namePrefix string,
+) {
+	g := a.Goproxy
+
+	if r.URL.Query().Get("force") == "true" {
+		if _, err := a.deleteCaches(
+			r.Context(),
+			namePrefix,
+		); err != nil {
+			if err == errAdminCannotDelete {
+				responseNotImplemented(rw, err)
+				return
+			}
+
+			rl.logError(err)
+			responseInternalServerError(rw)
+			return
+		}
+	}
+
+	goproxyRoot, err := ioutil.TempDir("", "goproxy")
+	if err != nil {
+		rl.logError(err)
+		responseInternalServerError(rw)
+		return
+	}
+	defer func() {
+		modClean(g.GoBinName, g.goBinEnv, goproxyRoot)
+		os.RemoveAll(goproxyRoot)
+	}()
+
+	mr, err := g.download(
+		r.Context(),
+		goproxyRoot,
+		modulePath,
+		moduleVersion,
+	)
+	if err != nil {
+		if _, ok := err.(*untrustedRevisionError); ok {
+			responseNotFound(rw, err)
+		} else if regModuleVersionNotFound.MatchString(err.Error()) {
+			rl.logNotFound(err)
+			responseNotFound(rw, err)
+		} else {
+			rl.logError(err)
+			responseInternalServerError(rw)
+		}
+
+		return
+	}
+
+	if err := g.writeCaches(
+		r.Context(),
+		g.Cacher,
+		namePrefix,
+		mr,
+	); err != nil {
+		rl.logError(err)
+		responseInternalServerError(rw)
+		return
+	}
+
+	rl.log(LogLevelInfo, "fetched module version", "upstream", mr.Source)
+
+	a.stat(rw, r, rl, modulePath, moduleVersion, namePrefix)
+}
+
+// prefetch prefetches the module versions in the body of the r.
+func (a *Admin) prefetch(
+	rw http.ResponseWriter,
+	r *http.Request,
+	rl *requestLogger,
+) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "list"
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		rl.logError(err)
+		responseInternalServerError(rw)
+		return
+	}
+
+	moduleVersions, err := ParsePrefetchList(format, b)
+	if err != nil {
+		responseString(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.WriteHeader(http.StatusOK)
+
+	var writeMutex sync.Mutex
+	writeLine := func(v interface{}) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return
+		}
+
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+
+		rw.Write(append(b, '\n'))
+		if f, ok := rw.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	p := &Prefetcher{
+		Goproxy:    a.Goproxy,
+		Transitive: r.URL.Query().Get("transitive") == "true",
+		Progress: func(pp *PrefetchProgress) {
+			writeLine(pp)
+		},
+	}
+
+	report, err := p.Prefetch(r.Context(), moduleVersions)
+	if err != nil {
+		rl.logError(err)
+		return
+	}
+
+	rl.log(
+		LogLevelInfo,
+		"prefetched module versions",
+		"total",
+		report.Total,
+		"failures",
+		len(report.Failures),
+	)
+
+	writeLine(report)
+}
+
+// errAdminCannotDelete is the error resulting if the `Goproxy.Cacher` does not
+// implement the `CacheDeleter`.
+var errAdminCannotDelete = errors.New("cacher cannot delete")
+
+// moduleVersion returns the cached artifacts of the moduleVersion of the
+// modulePath.
+func (a *Admin) moduleVersion(
+	ctx context.Context,
+	modulePath string,
+	moduleVersion string,
+	namePrefix string,
+) (*adminModuleVersion, error) {
+	amv := &adminModuleVersion{
+		Path:      modulePath,
+		Version:   moduleVersion,
+		Artifacts: []*adminArtifact{},
+	}
+	for _, ext := range adminArtifactExts {
+		cache, err := a.Goproxy.Cacher.Cache(
+			ctx,
+			fmt.Sprint(namePrefix, ext),
+		)
+		if err == ErrCacheNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		amv.Artifacts = append(amv.Artifacts, &adminArtifact{
+			Name:     cache.Name(),
+			MIMEType: cache.MIMEType(),
+			Size:     cache.Size(),
+			ModTime:  cache.ModTime(),
+			Checksum: cache.Checksum(),
+		})
+
+		cache.Close()
+	}
+
+	return amv, nil
+}
+
+// deleteCaches deletes the cached artifacts of the namePrefix and returns the
+// names of the deleted ones.
+func (a *Admin) deleteCaches(
+	ctx context.Context,
+	namePrefix string,
+) ([]string, error) {
+	cd, ok := a.Goproxy.Cacher.(CacheDeleter)
+	if !ok {
+		return nil, errAdminCannotDelete
+	}
+
+	deleted := []string{}
+	for _, ext := range adminArtifactExts {
+		name := fmt.Sprint(namePrefix, ext)
+		if err := cd.DeleteCache(ctx, name); err == ErrCacheNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		deleted = append(deleted, name)
+	}
+
+	return deleted, nil
+}
+
+// cacheHash returns the hash of the cache of the name as the checksum database
+// records it. The cache is treated as a module zip if the isZip is true,
+// otherwise as a go.mod.
+func (a *Admin) cacheHash(
+	ctx context.Context,
+	name string,
+	isZip bool,
+) (string, error) {
+	cache, err := a.Goproxy.Cacher.Cache(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer cache.Close()
+
+	if !isZip {
+		b, err := ioutil.ReadAll(cache)
+		if err != nil {
+			return "", err
+		}
+
+		return dirhash.Hash1(
+			[]string{"go.mod"},
+			func(string) (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(b)), nil
+			},
+		)
+	}
+
+	file, err := ioutil.TempFile("", "goproxy-admin")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, cache); err != nil {
+		file.Close()
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	return dirhash.HashZip(file.Name(), dirhash.DefaultHash)
+}
+
+// parseCacheName parses the module path and the module version out of the
+// cache name, such as "example.com/foo/@v/v1.0.0.zip".
+func parseCacheName(name string) (string, string, bool) {
+	nameParts := strings.Split(name, "/@v/")
+	if len(nameParts) != 2 {
+		return "", "", false
+	}
+
+	modulePath, err := module.UnescapePath(nameParts[0])
+	if err != nil {
+		return "", "", false
+	}
+
+	nameBase := nameParts[1]
+	moduleVersion, err := module.UnescapeVersion(
+		strings.TrimSuffix(nameBase, path.Ext(nameBase)),
+	)
+	if err != nil || !semver.IsValid(moduleVersion) {
+		return "", "", false
+	}
+
+	return modulePath, moduleVersion, true
+}
+
+// secureCompare reports whether the a and the b are equal in constant time.
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,253 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapCacher struct {
+	mutex  sync.Mutex
+	caches map[string][]byte
+}
+
+func (mc *mapCacher) NewHash() hash.Hash {
+	return md5.New()
+}
+
+func (mc *mapCacher) Cache(ctx context.Context, name string) (Cache, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	b, ok := mc.caches[name]
+	if !ok {
+		return nil, ErrCacheNotFound
+	}
+
+	return &mapCache{Reader: bytes.NewReader(b), name: name}, nil
+}
+
+func (mc *mapCacher) SetCache(ctx context.Context, c Cache) error {
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		return err
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if mc.caches == nil {
+		mc.caches = map[string][]byte{}
+	}
+
+	mc.caches[c.Name()] = b
+
+	return nil
+}
+
+func (mc *mapCacher) Caches(
+	ctx context.Context,
+	prefix string,
+) ([]string, error) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	var names []string
+	for name := range mc.caches {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (mc *mapCacher) DeleteCache(ctx context.Context, name string) error {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if _, ok := mc.caches[name]; !ok {
+		return ErrCacheNotFound
+	}
+
+	delete(mc.caches, name)
+
+	return nil
+}
+
+type mapCache struct {
+	*bytes.Reader
+	name string
+}
+
+func (mc *mapCache) Close() error       { return nil }
+func (mc *mapCache) Name() string       { return mc.name }
+func (mc *mapCache) MIMEType() string   { return "" }
+func (mc *mapCache) ModTime() time.Time { return time.Time{} }
+func (mc *mapCache) Checksum() []byte   { return nil }
+
+func TestAdmin(t *testing.T) {
+	mc := &mapCacher{caches: map[string][]byte{
+		"example.com/!foo/@v/v1.0.0.info": []byte("{}"),
+		"example.com/!foo/@v/v1.0.0.mod":  []byte("module foo"),
+		"example.com/!foo/@v/v1.1.0.info": []byte("{}"),
+		"example.com/bar/@v/v0.1.0.info":  []byte("{}"),
+		healthProbeNamePrefix + "0":       []byte("probe"),
+	}}
+
+	g := New()
+	g.Cacher = mc
+
+	a := &Admin{Goproxy: g, PathPrefix: "/admin", Token: "secret"}
+
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/admin/modules", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = do(http.MethodGet, "/admin/modules", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = do(http.MethodGet, "/admin/modules", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var modules []*adminModule
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &modules))
+	assert.Equal(t, []*adminModule{
+		{
+			Path:     "example.com/Foo",
+			Versions: []string{"v1.0.0", "v1.1.0"},
+		},
+		{
+			Path:     "example.com/bar",
+			Versions: []string{"v0.1.0"},
+		},
+	}, modules)
+
+	rec = do(
+		http.MethodGet,
+		"/admin/modules?prefix=example.com/b",
+		"secret",
+	)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &modules))
+	assert.Len(t, modules, 1)
+
+	rec = do(
+		http.MethodGet,
+		"/admin/modules/example.com/!foo/@v/v1.0.0",
+		"secret",
+	)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	amv := &adminModuleVersion{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), amv))
+	assert.Equal(t, "example.com/Foo", amv.Path)
+	assert.Len(t, amv.Artifacts, 2)
+
+	rec = do(
+		http.MethodPut,
+		"/admin/modules/example.com/!foo/@v/v1.0.0",
+		"secret",
+	)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = do(
+		http.MethodDelete,
+		"/admin/modules/example.com/!foo/@v/v1.0.0",
+		"secret",
+	)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(
+		t,
+		`["example.com/!foo/@v/v1.0.0.info",`+
+			`"example.com/!foo/@v/v1.0.0.mod"]`,
+		rec.Body.String(),
+	)
+
+	rec = do(
+		http.MethodDelete,
+		"/admin/modules/example.com/!foo/@v/v1.0.0",
+		"secret",
+	)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(
+		http.MethodGet,
+		"/admin/modules/example.com/!foo/@v/v1.0.0/verify",
+		"secret",
+	)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestAdminBasicAuth(t *testing.T) {
+	g := New()
+	g.Cacher = &mapCacher{}
+
+	do := func(
+		a *Admin,
+		username string,
+		password string,
+	) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(
+			http.MethodGet,
+			"/admin/modules",
+			nil,
+		)
+		req.SetBasicAuth(username, password)
+
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	a := &Admin{
+		Goproxy:    g,
+		PathPrefix: "/admin",
+		Username:   "admin",
+		Password:   "secret",
+	}
+
+	rec := do(a, "admin", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = do(a, "admin", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(
+		t,
+		`Basic realm="goproxy admin"`,
+		rec.Header().Get("WWW-Authenticate"),
+	)
+
+	// An empty password never enables the basic authentication.
+	a = &Admin{Goproxy: g, PathPrefix: "/admin", Username: "admin"}
+
+	rec = do(a, "admin", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+}
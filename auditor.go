@@ -0,0 +1,72 @@
+package goproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Auditor is the interface that defines a set of methods used to record who
+// downloaded what from the `Goproxy`.
+//
+// If you are looking for some useful implementations of the `Auditor`, simply
+// visit the "github.com/goproxy/goproxy/auditor" package.
+type Auditor interface {
+	// Audit records the ar to the underlying auditor.
+	Audit(ctx context.Context, ar *AuditRecord) error
+}
+
+// AuditRecord is the audit unit of the `Auditor`. Every module request served
+// by the `Goproxy` results in exactly one `AuditRecord`.
+type AuditRecord struct {
+	// Time is the time when the request was received.
+	Time time.Time `json:"time"`
+
+	// Client is the identity of the client. It is the username of the HTTP
+	// basic authentication if present, otherwise the IP address of the
+	// client.
+	Client string `json:"client"`
+
+	// ModulePath is the module path of the request.
+	ModulePath string `json:"module_path"`
+
+	// ModuleVersion is the resolved module version of the request. It is
+	// empty for the "/@v/list".
+	ModuleVersion string `json:"module_version,omitempty"`
+
+	// Artifact is the type of the requested artifact. It is one of the
+	// "list", "latest", "info", "mod", "zip", and "provenance".
+	Artifact string `json:"artifact"`
+
+	// CacheHit reports whether the artifact was served from the `Cacher`.
+	CacheHit bool `json:"cache_hit"`
+
+	// Upstream is the redacted GOPROXY entry or "direct" that was used to
+	// fetch the artifact. It is empty if no upstream was involved.
+	Upstream string `json:"upstream,omitempty"`
+
+	// Redirected reports whether the client was redirected to a presigned
+	// URL of the `Cacher` instead of being served by the `Goproxy`.
+	Redirected bool `json:"redirected,omitempty"`
+
+	// BytesServed is the number of bytes of the response body.
+	BytesServed int64 `json:"bytes_served"`
+
+	// Status is the HTTP status code of the response.
+	Status int `json:"status"`
+}
+
+// clientIdentity returns the identity of the client of the r.
+func clientIdentity(r *http.Request) string {
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		return username
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,93 @@
+package auditor
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/goproxy/goproxy"
+)
+
+// File implements the `goproxy.Auditor` by appending JSON Lines to a file.
+type File struct {
+	// Filename is the name of the file.
+	Filename string `mapstructure:"filename"`
+
+	loadOnce  sync.Once
+	loadError error
+	mutex     sync.Mutex
+	file      *os.File
+}
+
+// load loads the stuff of the f up.
+func (f *File) load() {
+	f.file, f.loadError = openAuditFile(f.Filename)
+}
+
+// Audit implements the `goproxy.Auditor`.
+func (f *File) Audit(ctx context.Context, ar *goproxy.AuditRecord) error {
+	if f.loadOnce.Do(f.load); f.loadError != nil {
+		return f.loadError
+	}
+
+	b, err := marshalAuditRecord(ar)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+
+	_, err = f.file.Write(b)
+
+	return err
+}
+
+// Close closes the underlying file of the f. Audits after the `Close` fail
+// with the `os.ErrClosed`.
+func (f *File) Close() error {
+	f.loadOnce.Do(func() {
+		f.loadError = os.ErrClosed
+	})
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+// openAuditFile opens the filename for appending audit records.
+func openAuditFile(filename string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(
+		filename,
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0644,
+	)
+}
+
+// marshalAuditRecord returns the JSON Lines encoding of the ar.
+func marshalAuditRecord(ar *goproxy.AuditRecord) ([]byte, error) {
+	b, err := json.Marshal(ar)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
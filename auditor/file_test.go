@@ -0,0 +1,119 @@
+package auditor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestAuditRecord returns a new `goproxy.AuditRecord` of the modulePath.
+func newTestAuditRecord(modulePath string) *goproxy.AuditRecord {
+	return &goproxy.AuditRecord{
+		Time:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Client:        "127.0.0.1",
+		ModulePath:    modulePath,
+		ModuleVersion: "v1.0.0",
+		Artifact:      "info",
+	}
+}
+
+// readAuditRecords returns the module paths of the audit records in the file
+// targeted by the filename.
+func readAuditRecords(t *testing.T, filename string) []string {
+	file, err := os.Open(filename)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer file.Close()
+
+	modulePaths := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var ar goproxy.AuditRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &ar))
+		modulePaths = append(modulePaths, ar.ModulePath)
+	}
+
+	assert.NoError(t, scanner.Err())
+
+	return modulePaths
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-auditor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "audit", "audit.log")
+	f := &File{Filename: filename}
+
+	for _, modulePath := range []string{
+		"example.com/foo",
+		"example.com/bar",
+	} {
+		assert.NoError(t, f.Audit(
+			context.Background(),
+			newTestAuditRecord(modulePath),
+		))
+	}
+
+	assert.NoError(t, f.Close())
+	assert.Equal(
+		t,
+		[]string{"example.com/foo", "example.com/bar"},
+		readAuditRecords(t, filename),
+	)
+
+	// The file is appended to, not truncated.
+	f = &File{Filename: filename}
+	assert.NoError(t, f.Audit(
+		context.Background(),
+		newTestAuditRecord("example.com/baz"),
+	))
+	assert.NoError(t, f.Close())
+	assert.Len(t, readAuditRecords(t, filename), 3)
+}
+
+func TestFileClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-auditor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "audit.log")
+	f := &File{Filename: filename}
+	assert.NoError(t, f.Audit(
+		context.Background(),
+		newTestAuditRecord("example.com/foo"),
+	))
+	assert.NoError(t, f.Close())
+	assert.NoError(t, f.Close())
+
+	assert.Equal(t, os.ErrClosed, f.Audit(
+		context.Background(),
+		newTestAuditRecord("example.com/bar"),
+	))
+	assert.Equal(
+		t,
+		[]string{"example.com/foo"},
+		readAuditRecords(t, filename),
+	)
+
+	// Closing before any audit keeps the file from being opened at all.
+	f = &File{Filename: filepath.Join(dir, "unused.log")}
+	assert.NoError(t, f.Close())
+	assert.Equal(t, os.ErrClosed, f.Audit(
+		context.Background(),
+		newTestAuditRecord("example.com/foo"),
+	))
+
+	_, err = os.Stat(filepath.Join(dir, "unused.log"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,173 @@
+package auditor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/goproxy/goproxy"
+)
+
+// RotatingFile implements the `goproxy.Auditor` by appending JSON Lines to a
+// file that is rotated when it grows too large.
+//
+// The rotated files are named by appending ".1", ".2", and so on to the
+// `Filename`, where ".1" is the most recent one.
+type RotatingFile struct {
+	// Filename is the name of the current file.
+	Filename string `mapstructure:"filename"`
+
+	// MaxBytes is the maximum number of bytes of the current file before it
+	// is rotated.
+	//
+	// If the `MaxBytes` is zero, the 100 MiB is used.
+	MaxBytes int64 `mapstructure:"max_bytes"`
+
+	// MaxBackups is the maximum number of the rotated files to retain.
+	//
+	// If the `MaxBackups` is zero, all of the rotated files are retained.
+	MaxBackups int `mapstructure:"max_backups"`
+
+	loadOnce  sync.Once
+	loadError error
+	mutex     sync.Mutex
+	file      *os.File
+	size      int64
+	maxBytes  int64
+	closed    bool
+}
+
+// load loads the stuff of the rf up.
+func (rf *RotatingFile) load() {
+	rf.maxBytes = rf.MaxBytes
+	if rf.maxBytes == 0 {
+		rf.maxBytes = 100 << 20
+	}
+
+	rf.loadError = rf.open()
+}
+
+// open opens the current file of the rf.
+func (rf *RotatingFile) open() error {
+	file, err := openAuditFile(rf.Filename)
+	if err != nil {
+		return err
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = fileInfo.Size()
+
+	return nil
+}
+
+// rotate closes the current file of the rf, shifts the rotated files, and
+// opens a new current file.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+
+	if rf.MaxBackups > 0 {
+		err := os.Remove(fmt.Sprint(rf.Filename, ".", rf.MaxBackups))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	n := 1
+	for {
+		if _, err := os.Stat(
+			fmt.Sprint(rf.Filename, ".", n),
+		); os.IsNotExist(err) {
+			break
+		}
+
+		n++
+	}
+
+	for ; n > 1; n-- {
+		if err := os.Rename(
+			fmt.Sprint(rf.Filename, ".", n-1),
+			fmt.Sprint(rf.Filename, ".", n),
+		); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(
+		rf.Filename,
+		fmt.Sprint(rf.Filename, ".1"),
+	); err != nil {
+		return err
+	}
+
+	return rf.open()
+}
+
+// Audit implements the `goproxy.Auditor`.
+func (rf *RotatingFile) Audit(
+	ctx context.Context,
+	ar *goproxy.AuditRecord,
+) error {
+	if rf.loadOnce.Do(rf.load); rf.loadError != nil {
+		return rf.loadError
+	}
+
+	b, err := marshalAuditRecord(ar)
+	if err != nil {
+		return err
+	}
+
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.closed {
+		return os.ErrClosed
+	}
+
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return err
+		}
+	}
+
+	if rf.size > 0 && rf.size+int64(len(b)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			rf.file = nil
+			return err
+		}
+	}
+
+	n, err := rf.file.Write(b)
+	rf.size += int64(n)
+
+	return err
+}
+
+// Close closes the current file of the rf. Audits after the `Close` fail with
+// the `os.ErrClosed`.
+func (rf *RotatingFile) Close() error {
+	rf.loadOnce.Do(func() {
+		rf.loadError = os.ErrClosed
+	})
+
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	rf.closed = true
+	if rf.file == nil {
+		return nil
+	}
+
+	err := rf.file.Close()
+	rf.file = nil
+
+	return err
+}
@@ -0,0 +1,112 @@
+package auditor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-auditor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := marshalAuditRecord(newTestAuditRecord("example.com/m0"))
+	assert.NoError(t, err)
+
+	// Each file holds two audit records.
+	filename := filepath.Join(dir, "audit.log")
+	rf := &RotatingFile{
+		Filename:   filename,
+		MaxBytes:   int64(2 * len(b)),
+		MaxBackups: 2,
+	}
+
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, rf.Audit(
+			context.Background(),
+			newTestAuditRecord(fmt.Sprint("example.com/m", i)),
+		))
+	}
+
+	assert.NoError(t, rf.Close())
+
+	// The oldest rotated files beyond the `MaxBackups` are pruned.
+	for name, modulePaths := range map[string][]string{
+		"audit.log":   {"example.com/m6"},
+		"audit.log.1": {"example.com/m4", "example.com/m5"},
+		"audit.log.2": {"example.com/m2", "example.com/m3"},
+	} {
+		assert.Equal(
+			t,
+			modulePaths,
+			readAuditRecords(t, filepath.Join(dir, name)),
+			name,
+		)
+	}
+
+	_, err = os.Stat(filename + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	// The size of an existing file counts towards the `MaxBytes`.
+	rf = &RotatingFile{Filename: filename, MaxBytes: int64(2 * len(b))}
+	for i := 7; i < 9; i++ {
+		assert.NoError(t, rf.Audit(
+			context.Background(),
+			newTestAuditRecord(fmt.Sprint("example.com/m", i)),
+		))
+	}
+
+	assert.NoError(t, rf.Close())
+	assert.Equal(
+		t,
+		[]string{"example.com/m8"},
+		readAuditRecords(t, filename),
+	)
+	assert.Equal(
+		t,
+		[]string{"example.com/m6", "example.com/m7"},
+		readAuditRecords(t, filename+".1"),
+	)
+
+	// All rotated files are retained if the `MaxBackups` is zero.
+	_, err = os.Stat(filename + ".3")
+	assert.NoError(t, err)
+}
+
+func TestRotatingFileClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-auditor")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "audit.log")
+	rf := &RotatingFile{Filename: filename}
+	assert.NoError(t, rf.Audit(
+		context.Background(),
+		newTestAuditRecord("example.com/foo"),
+	))
+	assert.NoError(t, rf.Close())
+	assert.NoError(t, rf.Close())
+
+	assert.Equal(t, os.ErrClosed, rf.Audit(
+		context.Background(),
+		newTestAuditRecord("example.com/bar"),
+	))
+	assert.Equal(
+		t,
+		[]string{"example.com/foo"},
+		readAuditRecords(t, filename),
+	)
+
+	rf = &RotatingFile{Filename: filepath.Join(dir, "unused.log")}
+	assert.NoError(t, rf.Close())
+	assert.Equal(t, os.ErrClosed, rf.Audit(
+		context.Background(),
+		newTestAuditRecord("example.com/foo"),
+	))
+}
@@ -0,0 +1,426 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheWriteJob is a job of setting the caches of a downloaded module version.
+type cacheWriteJob struct {
+	// NamePrefix is the name prefix of the caches, such as
+	// "example.com/foo/@v/v1.0.0".
+	NamePrefix string
+
+	// Runs is the number of the runs of the job whose retries have run
+	// out. It is only kept for the spooled jobs.
+	Runs int
+
+	cacher   Cacher
+	mr       *modResult
+	spoolDir string
+}
+
+// cacheWriteJobManifest is the name of the manifest file of a spooled
+// `cacheWriteJob`.
+const cacheWriteJobManifest = "job.json"
+
+// maxCacheWriteRuns is the maximum number of the runs of a spooled
+// `cacheWriteJob`, after which it is dropped.
+const maxCacheWriteRuns = 8
+
+// maxCacheWriteRunDelay is the maximum delay before a spooled `cacheWriteJob`
+// whose retries have run out is run again.
+const maxCacheWriteRunDelay = time.Hour
+
+// enqueueCacheWrite enqueues a job of setting the files of the mr downloaded
+// into the goproxyRoot to the cacher as the caches named with the namePrefix.
+// It reports whether the job is accepted, in which case the job takes over the
+// cleanup of the goproxyRoot.
+func (g *Goproxy) enqueueCacheWrite(
+	ctx context.Context,
+	cacher Cacher,
+	namePrefix string,
+	goproxyRoot string,
+	mr *modResult,
+) bool {
+	if g.cacheWriteQueueChan != nil {
+		select {
+		case g.cacheWriteQueueChan <- struct{}{}:
+		default:
+			g.logger.Log(
+				LogLevelWarn,
+				"cache write queue is full",
+				"operation",
+				"cache_write",
+				"cache",
+				namePrefix,
+			)
+			g.Metrics.observeCacheWriteFailure(cacher)
+			return false
+		}
+	}
+
+	job := &cacheWriteJob{
+		NamePrefix: namePrefix,
+		cacher:     cacher,
+		mr:         mr,
+	}
+
+	// The job keeps the g in use until it finishes, so that the `Cacher`
+	// of the g is not released after a `Reload` in the meantime.
+	g.usage.acquire()
+	accepted := g.goBackground(
+		ctx,
+		fmt.Sprint("set caches of ", namePrefix),
+		func(ctx context.Context) {
+			defer g.usage.release()
+			if g.cacheWriteQueueChan != nil {
+				defer func() { <-g.cacheWriteQueueChan }()
+			}
+
+			purged := false
+			purge := func() {
+				if !purged {
+					purged = true
+					modClean(
+						g.GoBinName,
+						g.goBinEnv,
+						goproxyRoot,
+					)
+					os.RemoveAll(goproxyRoot)
+				}
+			}
+			defer purge()
+
+			if g.CacheWriteSpoolDir != "" && g.Cacher != nil {
+				if err := g.spoolCacheWrite(job); err != nil {
+					g.logCacheWriteError(job, err)
+				} else {
+					purge()
+				}
+			}
+
+			if err := g.runCacheWrite(ctx, job); err != nil {
+				g.logCacheWriteError(job, err)
+			}
+		},
+	)
+	if !accepted {
+		g.usage.release()
+		if g.cacheWriteQueueChan != nil {
+			<-g.cacheWriteQueueChan
+		}
+	}
+
+	return accepted
+}
+
+// writeCaches sets the files of the mr to the cacher as the caches named with
+// the namePrefix like an enqueued job, but at once. It is used when the caches
+// are needed right away, such as by the `Prefetcher`, so the job is not queued,
+// but it still waits for a cache write worker and is retried on failure.
+func (g *Goproxy) writeCaches(
+	ctx context.Context,
+	cacher Cacher,
+	namePrefix string,
+	mr *modResult,
+) error {
+	return g.runCacheWrite(ctx, &cacheWriteJob{
+		NamePrefix: namePrefix,
+		cacher:     cacher,
+		mr:         mr,
+	})
+}
+
+// runCacheWrite runs the job until it succeeds, its retries are exhausted, or
+// the ctx is done, and returns the error of the last attempt. Failed attempts
+// are retried with exponential backoff. The spooled files of the job are
+// removed only when it succeeds, a spooled job whose retries have run out is
+// run again later by the `deferCacheWrite`.
+func (g *Goproxy) runCacheWrite(ctx context.Context, job *cacheWriteJob) error {
+	if job.spoolDir != "" {
+		defer g.background.releaseSpoolDir(job.spoolDir)
+	}
+
+	for attempts := 1; ; attempts++ {
+		err := g.attemptCacheWrite(ctx, job)
+		if err == nil {
+			if job.spoolDir != "" {
+				os.RemoveAll(job.spoolDir)
+			}
+
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if attempts > g.MaxCacheWriteRetries {
+			if job.spoolDir != "" {
+				g.deferCacheWrite(job)
+			}
+
+			return err
+		}
+
+		backoff := g.CacheWriteBackoff << uint(attempts-1)
+		g.logger.Log(
+			LogLevelWarn,
+			"retrying cache write",
+			"operation",
+			"cache_write",
+			"cache",
+			job.NamePrefix,
+			"attempts",
+			attempts,
+			"backoff",
+			backoff,
+			"error",
+			err,
+		)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// deferCacheWrite schedules the spooled job whose retries have run out to be
+// run again by the `restoreCacheWrites` of the current snapshot, after a delay
+// that keeps doubling with each run up to the `maxCacheWriteRunDelay`. The job
+// is dropped once it has been run `maxCacheWriteRuns` times.
+func (g *Goproxy) deferCacheWrite(job *cacheWriteJob) {
+	job.Runs++
+	if job.Runs >= maxCacheWriteRuns {
+		g.logger.Log(
+			LogLevelError,
+			"dropping cache write",
+			"operation",
+			"cache_write",
+			"cache",
+			job.NamePrefix,
+			"runs",
+			job.Runs,
+		)
+		os.RemoveAll(job.spoolDir)
+		return
+	}
+
+	if err := writeCacheWriteJobManifest(job.spoolDir, job); err != nil {
+		g.logCacheWriteError(job, err)
+	}
+
+	delay := g.CacheWriteBackoff << uint(g.MaxCacheWriteRetries+job.Runs)
+	if delay <= 0 || delay > maxCacheWriteRunDelay {
+		delay = maxCacheWriteRunDelay
+	}
+
+	rs := g.reload
+	time.AfterFunc(delay, func() {
+		rs.snapshot.Load().(*Goproxy).restoreCacheWrites()
+	})
+}
+
+// attemptCacheWrite makes an attempt at the job once a cache write worker is
+// available.
+func (g *Goproxy) attemptCacheWrite(
+	ctx context.Context,
+	job *cacheWriteJob,
+) error {
+	if g.cacheWriteWorkerChan != nil {
+		select {
+		case g.cacheWriteWorkerChan <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		defer func() { <-g.cacheWriteWorkerChan }()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	return g.setCaches(ctx, job.cacher, job.NamePrefix, job.mr)
+}
+
+// spoolCacheWrite copies the files of the job into a new directory in the
+// `CacheWriteSpoolDir`, so that the job survives a restart.
+func (g *Goproxy) spoolCacheWrite(job *cacheWriteJob) error {
+	dir, err := g.background.newSpoolDir(g.CacheWriteSpoolDir)
+	if err != nil {
+		return err
+	}
+
+	discard := func() {
+		os.RemoveAll(dir)
+		g.background.releaseSpoolDir(dir)
+	}
+
+	mr := spooledModResult(dir)
+	for _, file := range []struct{ dst, src string }{
+		{mr.Info, job.mr.Info},
+		{mr.GoMod, job.mr.GoMod},
+		{mr.Zip, job.mr.Zip},
+		{mr.Provenance, job.mr.Provenance},
+	} {
+		if err := copyFile(file.dst, file.src); err != nil {
+			discard()
+			return err
+		}
+	}
+
+	// The manifest is written last, so a directory without it is known
+	// to be incomplete.
+	if err := writeCacheWriteJobManifest(dir, job); err != nil {
+		discard()
+		return err
+	}
+
+	job.mr = mr
+	job.spoolDir = dir
+
+	return nil
+}
+
+// writeCacheWriteJobManifest writes the manifest of the job into the spool
+// directory dir. The manifest is replaced atomically, so that it is never seen
+// half written.
+func writeCacheWriteJobManifest(dir string, job *cacheWriteJob) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(dir, cacheWriteJobManifest)
+	if err := ioutil.WriteFile(filename+".tmp", b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(filename+".tmp", filename)
+}
+
+// restoreCacheWrites enqueues the jobs spooled in the `CacheWriteSpoolDir` by a
+// previous run, a previous config, or a run whose retries have run out, except
+// the ones that are still being run. Incomplete ones are removed.
+func (g *Goproxy) restoreCacheWrites() {
+	if g.CacheWriteSpoolDir == "" || g.Cacher == nil {
+		return
+	}
+
+	fileInfos, err := ioutil.ReadDir(g.CacheWriteSpoolDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			g.logger.Log(
+				LogLevelError,
+				err.Error(),
+				"operation",
+				"cache_write",
+			)
+		}
+
+		return
+	}
+
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(g.CacheWriteSpoolDir, fileInfo.Name())
+		if !g.background.claimSpoolDir(dir) {
+			continue
+		}
+
+		discard := func() {
+			os.RemoveAll(dir)
+			g.background.releaseSpoolDir(dir)
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(
+			dir,
+			cacheWriteJobManifest,
+		))
+		if err != nil {
+			discard()
+			continue
+		}
+
+		job := &cacheWriteJob{
+			cacher:   g.Cacher,
+			mr:       spooledModResult(dir),
+			spoolDir: dir,
+		}
+		if err := json.Unmarshal(b, job); err != nil {
+			discard()
+			continue
+		}
+
+		g.usage.acquire()
+		if !g.goBackground(
+			context.Background(),
+			fmt.Sprint("set caches of ", job.NamePrefix),
+			func(ctx context.Context) {
+				defer g.usage.release()
+				err := g.runCacheWrite(ctx, job)
+				if err != nil {
+					g.logCacheWriteError(job, err)
+				}
+			},
+		) {
+			g.usage.release()
+			g.background.releaseSpoolDir(dir)
+		}
+	}
+}
+
+// spooledModResult returns the `modResult` whose files are spooled in the dir.
+func spooledModResult(dir string) *modResult {
+	return &modResult{
+		Info:       filepath.Join(dir, "info"),
+		GoMod:      filepath.Join(dir, "mod"),
+		Zip:        filepath.Join(dir, "zip"),
+		Provenance: filepath.Join(dir, "provenance"),
+	}
+}
+
+// logCacheWriteError logs the err of the job.
+func (g *Goproxy) logCacheWriteError(job *cacheWriteJob, err error) {
+	g.logger.Log(
+		LogLevelError,
+		err.Error(),
+		"operation",
+		"cache_write",
+		"cache",
+		job.NamePrefix,
+	)
+}
+
+// copyFile copies the file targeted by the src to the dst.
+func copyFile(dst, src string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
+		return err
+	}
+
+	return dstFile.Close()
+}
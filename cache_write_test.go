@@ -0,0 +1,332 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type flakyCacher struct {
+	mapCacher
+
+	mutex    sync.Mutex
+	failures int
+}
+
+func (fc *flakyCacher) SetCache(ctx context.Context, c Cache) error {
+	fc.mutex.Lock()
+	if fc.failures != 0 {
+		fc.failures--
+		fc.mutex.Unlock()
+		return errors.New("throttled")
+	}
+	fc.mutex.Unlock()
+
+	return fc.mapCacher.SetCache(ctx, c)
+}
+
+func newCacheWriteModResult(t *testing.T, goproxyRoot string) *modResult {
+	mr := spooledModResult(goproxyRoot)
+	for _, filename := range []string{
+		mr.Info,
+		mr.GoMod,
+		mr.Zip,
+		mr.Provenance,
+	} {
+		assert.NoError(t, ioutil.WriteFile(
+			filename,
+			[]byte(filepath.Base(filename)),
+			0644,
+		))
+	}
+
+	return mr
+}
+
+func TestGoproxyEnqueueCacheWrite(t *testing.T) {
+	goproxyRoot, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(goproxyRoot)
+
+	g := New()
+	g.CacheWriteBackoff = time.Millisecond
+	g.snapshot()
+
+	fc := &flakyCacher{failures: 2}
+	assert.True(t, g.enqueueCacheWrite(
+		context.Background(),
+		fc,
+		"example.com/foo/@v/v1.0.0",
+		goproxyRoot,
+		newCacheWriteModResult(t, goproxyRoot),
+	))
+	assert.NoError(t, g.Shutdown(context.Background()))
+
+	b, err := cacheBytes(
+		context.Background(),
+		fc,
+		"example.com/foo/@v/v1.0.0.zip",
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "zip", string(b))
+
+	_, err = os.Stat(goproxyRoot)
+	assert.True(t, os.IsNotExist(err))
+
+	g = New()
+	g.MaxCacheWriteQueueSize = 1
+	g.snapshot()
+
+	g.cacheWriteQueueChan <- struct{}{}
+	assert.False(t, g.enqueueCacheWrite(
+		context.Background(),
+		fc,
+		"example.com/foo/@v/v1.0.0",
+		goproxyRoot,
+		&modResult{},
+	))
+}
+
+func TestGoproxyRestoreCacheWrites(t *testing.T) {
+	spoolDir, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(spoolDir)
+
+	goproxyRoot, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(goproxyRoot)
+
+	g := New()
+	g.Cacher = &brokenCacher{}
+	g.MaxCacheWriteRetries = 1
+	g.CacheWriteBackoff = time.Millisecond
+	g.CacheWriteSpoolDir = spoolDir
+	g.snapshot()
+
+	assert.True(t, g.enqueueCacheWrite(
+		context.Background(),
+		g.Cacher,
+		"example.com/foo/@v/v1.0.0",
+		goproxyRoot,
+		newCacheWriteModResult(t, goproxyRoot),
+	))
+	assert.NoError(t, g.Shutdown(context.Background()))
+
+	fileInfos, err := ioutil.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 1)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(spoolDir, "incomplete"), 0755))
+
+	mc := &mapCacher{}
+
+	g = New()
+	g.Cacher = mc
+	g.CacheWriteSpoolDir = spoolDir
+	g.snapshot()
+	assert.NoError(t, g.Shutdown(context.Background()))
+
+	for _, nameExt := range []string{
+		".info",
+		".mod",
+		".zip",
+		".provenance",
+	} {
+		_, err := cacheBytes(
+			context.Background(),
+			mc,
+			"example.com/foo/@v/v1.0.0"+nameExt,
+		)
+		assert.NoError(t, err)
+	}
+
+	fileInfos, err = ioutil.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 0)
+}
+
+func TestGoproxyDeferCacheWrite(t *testing.T) {
+	spoolDir, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(spoolDir)
+
+	spooledJobs := func() int {
+		fileInfos, err := ioutil.ReadDir(spoolDir)
+		assert.NoError(t, err)
+		return len(fileInfos)
+	}
+
+	waitFor := func(f func() bool) {
+		for deadline := time.Now().Add(10 * time.Second); !f(); {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out")
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// A spooled job whose retries have run out is run again later.
+	fc := &flakyCacher{failures: 3}
+
+	g := New()
+	g.Cacher = fc
+	g.MaxCacheWriteRetries = 0
+	g.CacheWriteBackoff = time.Millisecond
+	g.CacheWriteSpoolDir = spoolDir
+	g.snapshot()
+
+	goproxyRoot, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(goproxyRoot)
+
+	assert.True(t, g.enqueueCacheWrite(
+		context.Background(),
+		fc,
+		"example.com/foo/@v/v1.0.0",
+		goproxyRoot,
+		newCacheWriteModResult(t, goproxyRoot),
+	))
+	waitFor(func() bool {
+		_, err := cacheBytes(
+			context.Background(),
+			fc,
+			"example.com/foo/@v/v1.0.0.provenance",
+		)
+		return err == nil
+	})
+	waitFor(func() bool { return spooledJobs() == 0 })
+
+	b, err := cacheBytes(
+		context.Background(),
+		fc,
+		"example.com/foo/@v/v1.0.0.zip",
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "zip", string(b))
+
+	// It is dropped once it has been run too many times.
+	g.Cacher = &brokenCacher{}
+	g.reload.snapshot.Store(g)
+
+	goproxyRoot, err = ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(goproxyRoot)
+
+	assert.True(t, g.enqueueCacheWrite(
+		context.Background(),
+		g.Cacher,
+		"example.com/foo/@v/v1.1.0",
+		goproxyRoot,
+		newCacheWriteModResult(t, goproxyRoot),
+	))
+	waitFor(func() bool { return spooledJobs() == 1 })
+	waitFor(func() bool { return spooledJobs() == 0 })
+	assert.NoError(t, g.Shutdown(context.Background()))
+}
+
+func TestGoproxyWriteCaches(t *testing.T) {
+	goproxyRoot, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(goproxyRoot)
+
+	g := New()
+	g.MaxCacheWriteRetries = 2
+	g.CacheWriteBackoff = time.Millisecond
+	g.snapshot()
+
+	// The caches are set at once, with the retries of the enqueued jobs.
+	fc := &flakyCacher{failures: 2}
+	assert.NoError(t, g.writeCaches(
+		context.Background(),
+		fc,
+		"example.com/foo/@v/v1.0.0",
+		newCacheWriteModResult(t, goproxyRoot),
+	))
+
+	b, err := cacheBytes(
+		context.Background(),
+		fc,
+		"example.com/foo/@v/v1.0.0.info",
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "info", string(b))
+
+	fc = &flakyCacher{failures: 3}
+	assert.EqualError(t, g.writeCaches(
+		context.Background(),
+		fc,
+		"example.com/foo/@v/v1.0.0",
+		newCacheWriteModResult(t, goproxyRoot),
+	), "throttled")
+
+	// The files are left to the caller.
+	_, err = os.Stat(filepath.Join(goproxyRoot, "zip"))
+	assert.NoError(t, err)
+}
+
+func TestGoproxyServeHTTPSpooledColdFetch(t *testing.T) {
+	names := map[string]string{
+		"v1.0.0": "example.com/foo/@v/v1.0.0.mod",
+		"v1.1.0": "example.com/foo/@v/v1.1.0.info",
+		"v1.2.0": "example.com/foo/@v/v1.2.0.zip",
+	}
+
+	versions := map[string]time.Time{}
+	for version := range names {
+		versions[version] = time.Now()
+	}
+
+	upstreamURL, closeUpstream := newTestModuleProxy(
+		t,
+		"example.com/foo",
+		versions,
+	)
+	defer closeUpstream()
+
+	spoolDir, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(spoolDir)
+
+	mc := &mapCacher{}
+
+	g := New()
+	g.Cacher = mc
+	g.CacheWriteSpoolDir = spoolDir
+	g.GoBinEnv = append(
+		g.GoBinEnv,
+		"GOPROXY="+upstreamURL,
+		"GOSUMDB=off",
+	)
+
+	for _, name := range names {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(
+			http.MethodGet,
+			"/"+name,
+			nil,
+		))
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+		assert.NotEmpty(t, rec.Body.Bytes(), name)
+	}
+
+	assert.NoError(t, g.Shutdown(context.Background()))
+
+	for _, name := range names {
+		_, err := cacheBytes(context.Background(), mc, name)
+		assert.NoError(t, err, name)
+	}
+
+	fileInfos, err := ioutil.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 0)
+}
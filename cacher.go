@@ -39,6 +39,37 @@ type Cacher interface {
 	SetCache(ctx context.Context, c Cache) error
 }
 
+// CacheLister is the interface that a `Cacher` may implement to list its
+// caches.
+type CacheLister interface {
+	// Caches returns the names of the caches that have the prefix in the
+	// underlying cacher, in lexical order.
+	Caches(ctx context.Context, prefix string) ([]string, error)
+}
+
+// CacheDeleter is the interface that a `Cacher` may implement to delete its
+// caches.
+type CacheDeleter interface {
+	// DeleteCache deletes the cache of the name, along with its metadata,
+	// from the underlying cacher. It returns the `ErrCacheNotFound` if not
+	// found.
+	DeleteCache(ctx context.Context, name string) error
+}
+
+// CachePresigner is the interface that a `Cacher` may implement to issue
+// presigned URLs of its caches, from which the clients can download the caches
+// directly.
+type CachePresigner interface {
+	// PresignCache returns a URL from which the cache of the name can be
+	// downloaded by a GET request until the expiry elapses. It returns an
+	// empty string if the cache cannot be downloaded directly.
+	PresignCache(
+		ctx context.Context,
+		name string,
+		expiry time.Duration,
+	) (string, error)
+}
+
 // Cache is the cache unit of the `Cacher`.
 type Cache interface {
 	io.Reader
@@ -61,6 +92,24 @@ type Cache interface {
 	Checksum() []byte
 }
 
+// EncodedCache is the interface that a `Cache` may implement to provide its
+// content in an encoded representation as well, such as a compressed one. The
+// `Goproxy` serves the encoded representation as is, along with the
+// Content-Encoding header, to the clients that accept its encoding.
+type EncodedCache interface {
+	Cache
+
+	// ContentEncoding returns the content coding of the encoded
+	// representation, such as "gzip".
+	ContentEncoding() string
+
+	// Encoded returns the encoded representation of the underlying cache,
+	// which has its own size and checksum. It shares the underlying cache,
+	// so it must not be used after the underlying cache is closed, and
+	// closing it does nothing.
+	Encoded() Cache
+}
+
 // tempCacher implements the `Cacher` without doing anything.
 type tempCacher struct{}
 
@@ -99,7 +148,7 @@ func newTempCache(filename, name string, fileHash hash.Hash) (Cache, error) {
 
 	var mimeType string
 	switch ext := strings.ToLower(path.Ext(name)); ext {
-	case ".info":
+	case ".info", ".provenance":
 		mimeType = "application/json; charset=utf-8"
 	case ".mod":
 		mimeType = "text/plain; charset=utf-8"
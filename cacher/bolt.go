@@ -0,0 +1,291 @@
+package cacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltMetadataBucket is the bucket of the metadata of the caches of a `Bolt`.
+var boltMetadataBucket = []byte("metadata")
+
+// boltContentBucket is the bucket of the contents of the caches of a `Bolt`.
+var boltContentBucket = []byte("content")
+
+// Bolt implements the `goproxy.Cacher` by using an embedded bbolt database
+// file, which stores the metadata and the content of all caches together.
+// Each cache is written in a single transaction.
+//
+// The content of a cache is held in memory while it is written and read, so
+// the size of the caches is limited by the `MaxCacheBytes`. The `Bolt` is best
+// used as a tier of a `Tiered` in front of another cacher for the large ".zip".
+//
+// The database file is locked exclusively while it is open, so it cannot be
+// shared by several instances of the `Bolt`.
+type Bolt struct {
+	// Filename is the name of the database file. It is created if it does
+	// not exist.
+	Filename string `mapstructure:"filename"`
+
+	// Timeout is the maximum duration to wait for the lock of the database
+	// file.
+	//
+	// If the `Timeout` is zero, 10 seconds is used.
+	Timeout time.Duration `mapstructure:"timeout"`
+
+	// MaxCacheBytes is the maximum size in bytes of a cache. Larger caches
+	// are silently not stored, and the previous caches of their names are
+	// removed.
+	//
+	// If the `MaxCacheBytes` is zero, 32 MiB is used.
+	MaxCacheBytes int64 `mapstructure:"max_cache_bytes"`
+
+	loadOnce  sync.Once
+	loadError error
+	db        *bolt.DB
+}
+
+// boltMetadata is the metadata of a cache of a `Bolt`.
+type boltMetadata struct {
+	MIMEType string
+	ModTime  time.Time
+	Checksum []byte
+}
+
+// load loads the stuff of the b up.
+func (b *Bolt) load() {
+	timeout := b.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	if b.loadError = os.MkdirAll(
+		filepath.Dir(b.Filename),
+		os.ModePerm,
+	); b.loadError != nil {
+		return
+	}
+
+	if b.db, b.loadError = bolt.Open(
+		b.Filename,
+		0600,
+		&bolt.Options{Timeout: timeout},
+	); b.loadError != nil {
+		return
+	}
+
+	b.loadError = b.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{
+			boltMetadataBucket,
+			boltContentBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(
+				bucket,
+			); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// NewHash implements the `goproxy.Cacher`.
+func (b *Bolt) NewHash() hash.Hash {
+	return md5.New()
+}
+
+// Cache implements the `goproxy.Cacher`.
+func (b *Bolt) Cache(ctx context.Context, name string) (goproxy.Cache, error) {
+	if b.loadOnce.Do(b.load); b.loadError != nil {
+		return nil, b.loadError
+	}
+
+	var me *memoryEntry
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		metadata := tx.Bucket(boltMetadataBucket).Get([]byte(name))
+		if metadata == nil {
+			return goproxy.ErrCacheNotFound
+		}
+
+		var bm boltMetadata
+		if err := json.Unmarshal(metadata, &bm); err != nil {
+			return err
+		}
+
+		// The content is only valid during the transaction, so it
+		// must be copied.
+		content := tx.Bucket(boltContentBucket).Get([]byte(name))
+		me = &memoryEntry{
+			name:     name,
+			mimeType: bm.MIMEType,
+			modTime:  bm.ModTime,
+			checksum: bm.Checksum,
+			content:  append([]byte(nil), content...),
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &memoryCache{
+		Reader: bytes.NewReader(me.content),
+		entry:  me,
+	}, nil
+}
+
+// SetCache implements the `goproxy.Cacher`.
+func (b *Bolt) SetCache(ctx context.Context, c goproxy.Cache) error {
+	if b.loadOnce.Do(b.load); b.loadError != nil {
+		return b.loadError
+	}
+
+	maxCacheBytes := b.MaxCacheBytes
+	if maxCacheBytes == 0 {
+		maxCacheBytes = 32 << 20
+	}
+
+	if c.Size() > maxCacheBytes {
+		return b.drop(c.Name())
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(c, maxCacheBytes+1))
+	if err != nil {
+		return err
+	} else if int64(len(content)) > maxCacheBytes {
+		return b.drop(c.Name())
+	}
+
+	modTime := c.ModTime()
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	metadata, err := json.Marshal(&boltMetadata{
+		MIMEType: c.MIMEType(),
+		ModTime:  modTime.UTC(),
+		Checksum: c.Checksum(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltContentBucket).Put(
+			[]byte(c.Name()),
+			content,
+		); err != nil {
+			return err
+		}
+
+		return tx.Bucket(boltMetadataBucket).Put(
+			[]byte(c.Name()),
+			metadata,
+		)
+	})
+}
+
+// drop removes the cache of the name from the b if any, so that a cache that is
+// not stored does not leave its stale content behind.
+func (b *Bolt) drop(name string) error {
+	if err := b.DeleteCache(
+		context.Background(),
+		name,
+	); err != nil && err != goproxy.ErrCacheNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// Caches implements the `goproxy.CacheLister`.
+func (b *Bolt) Caches(ctx context.Context, prefix string) ([]string, error) {
+	if b.loadOnce.Do(b.load); b.loadError != nil {
+		return nil, b.loadError
+	}
+
+	names := []string{}
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltMetadataBucket).Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil; k, _ = c.Next() {
+			name := string(k)
+			if !strings.HasPrefix(name, prefix) {
+				break
+			}
+
+			names = append(names, name)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (b *Bolt) DeleteCache(ctx context.Context, name string) error {
+	if b.loadOnce.Do(b.load); b.loadError != nil {
+		return b.loadError
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		metadataBucket := tx.Bucket(boltMetadataBucket)
+		if metadataBucket.Get([]byte(name)) == nil {
+			return goproxy.ErrCacheNotFound
+		}
+
+		if err := metadataBucket.Delete([]byte(name)); err != nil {
+			return err
+		}
+
+		return tx.Bucket(boltContentBucket).Delete([]byte(name))
+	})
+}
+
+// Backup writes a consistent snapshot of the database file to the w without
+// blocking the other operations of the b. It returns the number of bytes
+// written.
+func (b *Bolt) Backup(w io.Writer) (int64, error) {
+	if b.loadOnce.Do(b.load); b.loadError != nil {
+		return 0, b.loadError
+	}
+
+	var n int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+
+	return n, err
+}
+
+// Close closes the database file of the b. The b must not be used after
+// being closed.
+func (b *Bolt) Close() error {
+	b.loadOnce.Do(func() {
+		b.loadError = errors.New("bolt: closed")
+	})
+
+	if b.db == nil {
+		return nil
+	}
+
+	return b.db.Close()
+}
@@ -0,0 +1,203 @@
+package cacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db", "goproxy.db")
+	b := &Bolt{Filename: filename}
+
+	content := []byte(`{"Version":"v1.0.0"}`)
+	assert.NoError(t, b.SetCache(
+		context.Background(),
+		newTestCache("example.com/foo/@v/v1.0.0.info", content),
+	))
+
+	c, err := b.Cache(
+		context.Background(),
+		"example.com/foo/@v/v1.0.0.info",
+	)
+	assert.NoError(t, err)
+	checksum := md5.Sum(content)
+	assert.Equal(t, "example.com/foo/@v/v1.0.0.info", c.Name())
+	assert.Equal(t, "application/octet-stream", c.MIMEType())
+	assert.Equal(t, int64(len(content)), c.Size())
+	assert.Equal(t, checksum[:], c.Checksum())
+	assert.Equal(t, time.UTC, c.ModTime().Location())
+
+	got, err := ioutil.ReadAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, content, got)
+	assert.NoError(t, c.Close())
+
+	// The database file is locked while it is open.
+	locked := &Bolt{Filename: filename, Timeout: 10 * time.Millisecond}
+	_, err = locked.Cache(context.Background(), "foo")
+	assert.Error(t, err)
+
+	assert.NoError(t, b.Close())
+	_, err = b.Cache(context.Background(), "example.com/foo/@v/v1.0.0.info")
+	assert.Error(t, err)
+
+	// The caches survive reopening, and the missing ones are still not
+	// found.
+	b = &Bolt{Filename: filename}
+	defer b.Close()
+
+	got, err = cacheContent(b, "example.com/foo/@v/v1.0.0.info")
+	assert.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	_, err = b.Cache(context.Background(), "example.com/foo/@v/v1.0.0.mod")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	assert.NoError(t, (&Bolt{}).Close())
+}
+
+func TestBoltMaxCacheBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b := &Bolt{Filename: filepath.Join(dir, "goproxy.db"), MaxCacheBytes: 5}
+	defer b.Close()
+
+	assert.NoError(t, b.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+
+	// The caches larger than the `MaxCacheBytes` are not stored, and the
+	// previous ones of their names are removed.
+	assert.NoError(t, b.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foobar")),
+	))
+
+	_, err = b.Cache(context.Background(), "foo")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	assert.NoError(t, b.SetCache(
+		context.Background(),
+		newTestCache("bar", []byte("foobar")),
+	))
+
+	_, err = b.Cache(context.Background(), "bar")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	// The limit also holds for the caches that report a wrong size.
+	c := newTestCache("baz", []byte("foobar")).(*memoryCache)
+	c.entry.content = c.entry.content[:3]
+	assert.NoError(t, b.SetCache(context.Background(), c))
+
+	_, err = b.Cache(context.Background(), "baz")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+}
+
+func TestBoltCaches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b := &Bolt{Filename: filepath.Join(dir, "goproxy.db")}
+	defer b.Close()
+
+	names := []string{
+		"example.com/bar/@v/list",
+		"example.com/foo/@v/v1.0.0.info",
+		"example.com/foo/@v/v1.0.0.mod",
+		"example.com/foobar/@v/v1.0.0.info",
+	}
+	for _, name := range names {
+		assert.NoError(t, b.SetCache(
+			context.Background(),
+			newTestCache(name, []byte(name)),
+		))
+	}
+
+	got, err := b.Caches(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, names, got)
+
+	got, err = b.Caches(context.Background(), "example.com/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, names[1:], got)
+
+	got, err = b.Caches(context.Background(), "example.com/foo/@v/")
+	assert.NoError(t, err)
+	assert.Equal(t, names[1:3], got)
+
+	got, err = b.Caches(context.Background(), "example.com/baz/")
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+
+	assert.NoError(t, b.DeleteCache(context.Background(), names[1]))
+	assert.Equal(
+		t,
+		goproxy.ErrCacheNotFound,
+		b.DeleteCache(context.Background(), names[1]),
+	)
+
+	_, err = b.Cache(context.Background(), names[1])
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	got, err = b.Caches(context.Background(), "example.com/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, names[2:], got)
+}
+
+func TestBoltBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b := &Bolt{Filename: filepath.Join(dir, "goproxy.db")}
+	defer b.Close()
+
+	assert.NoError(t, b.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+
+	buf := &bytes.Buffer{}
+	n, err := b.Backup(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	// The changes after the backup are not in it.
+	assert.NoError(t, b.SetCache(
+		context.Background(),
+		newTestCache("bar", []byte("bar")),
+	))
+
+	backupFilename := filepath.Join(dir, "backup.db")
+	assert.NoError(t, ioutil.WriteFile(backupFilename, buf.Bytes(), 0600))
+
+	backup := &Bolt{Filename: backupFilename}
+	defer backup.Close()
+
+	got, err := cacheContent(backup, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), got)
+
+	_, err = backup.Cache(context.Background(), "bar")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	_, err = (&Bolt{Filename: dir}).Backup(buf)
+	assert.Error(t, err)
+}
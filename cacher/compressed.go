@@ -0,0 +1,514 @@
+package cacher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedMagic is the magic number at the beginning of the compressed
+// contents stored by a `Compressed`. It starts with a NUL so that it never
+// collides with the beginning of an uncompressed ".info", ".mod", or ".zip".
+var compressedMagic = []byte("\x00GPCMP1")
+
+// compressedCodec is a compression algorithm of a `Compressed`.
+type compressedCodec struct {
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// compressedCodecs is the compression algorithms of a `Compressed` keyed by
+// their content codings.
+var compressedCodecs = map[string]compressedCodec{
+	"gzip": {newWriter: newGzipWriter, newReader: newGzipReader},
+	"zstd": {newWriter: newZstdWriter, newReader: newZstdReader},
+}
+
+// newGzipWriter returns a new gzip writer of the level for the w.
+func newGzipWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	return gzip.NewWriterLevel(w, level)
+}
+
+// newGzipReader returns a new gzip reader for the r.
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// newZstdWriter returns a new zstd writer of the level for the w.
+func newZstdWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(
+			zstd.EncoderLevelFromZstd(level),
+		))
+	}
+
+	return zstd.NewWriter(w, opts...)
+}
+
+// newZstdReader returns a new zstd reader for the r.
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+
+	return d.IOReadCloser(), nil
+}
+
+// Compressed implements the `goproxy.Cacher` by compressing the contents of
+// the caches before storing them in another `goproxy.Cacher`.
+//
+// The caches it returns implement the `goproxy.EncodedCache`, so the
+// `goproxy.Goproxy` serves their compressed contents as is to the clients that
+// accept the encoding, and decompresses them on the fly for the others. The
+// caches stored before are still readable as is.
+type Compressed struct {
+	// Cacher is the underlying cacher.
+	Cacher goproxy.Cacher `mapstructure:"cacher"`
+
+	// Encoding is the content coding used to compress, either "gzip" or
+	// "zstd". Note that clients such as the Go command only accept the
+	// "gzip".
+	//
+	// If the `Encoding` is empty, the "gzip" is used.
+	Encoding string `mapstructure:"encoding"`
+
+	// Level is the compression level of the `Encoding`.
+	//
+	// If the `Level` is zero, the default level of the `Encoding` is used.
+	Level int `mapstructure:"level"`
+
+	// NameExts is the extensions of the names of the caches to compress,
+	// the other caches are stored as is. The contents of the caches are
+	// compressed into temporary files.
+	//
+	// If the `NameExts` is empty, the ".info" and the ".mod" are used,
+	// since the ".zip" is already compressed.
+	NameExts []string `mapstructure:"name_exts"`
+}
+
+// NewHash implements the `goproxy.Cacher`.
+func (c *Compressed) NewHash() hash.Hash {
+	return c.Cacher.NewHash()
+}
+
+// Cache implements the `goproxy.Cacher`.
+func (c *Compressed) Cache(
+	ctx context.Context,
+	name string,
+) (goproxy.Cache, error) {
+	uc, err := c.Cacher.Cache(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, len(compressedMagic))
+	if _, err := io.ReadFull(uc, magic); err != nil ||
+		!bytes.Equal(magic, compressedMagic) {
+		if _, err := uc.Seek(0, io.SeekStart); err != nil {
+			uc.Close()
+			return nil, err
+		}
+
+		return uc, nil
+	}
+
+	cc, err := readCompressedCache(uc)
+	if err != nil {
+		uc.Close()
+		return nil, fmt.Errorf(
+			"invalid compressed cache %q: %v",
+			name,
+			err,
+		)
+	}
+
+	return cc, nil
+}
+
+// SetCache implements the `goproxy.Cacher`.
+func (c *Compressed) SetCache(ctx context.Context, cache goproxy.Cache) error {
+	if !c.compresses(cache.Name()) {
+		return c.Cacher.SetCache(ctx, cache)
+	}
+
+	encoding := c.Encoding
+	if encoding == "" {
+		encoding = "gzip"
+	}
+
+	codec, ok := compressedCodecs[encoding]
+	if !ok {
+		return fmt.Errorf("unsupported encoding: %q", encoding)
+	}
+
+	file, err := ioutil.TempFile("", "goproxy-compressed")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	// The header is written with placeholders first, and rewritten once
+	// the size and the checksum of the compressed content are known,
+	// which do not change its length.
+	h := &compressedHeader{
+		encoding:        encoding,
+		checksum:        cache.Checksum(),
+		encodedChecksum: make([]byte, c.Cacher.NewHash().Size()),
+	}
+	if _, err := file.Write(h.marshal()); err != nil {
+		return err
+	}
+
+	encodedHash := c.Cacher.NewHash()
+	w, err := codec.newWriter(io.MultiWriter(file, encodedHash), c.Level)
+	if err != nil {
+		return err
+	}
+
+	if h.size, err = io.Copy(w, cache); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	h.encodedChecksum = encodedHash.Sum(nil)
+	if _, err := file.WriteAt(h.marshal(), 0); err != nil {
+		return err
+	}
+
+	// The underlying cacher gets the checksum of the stored content, such
+	// as for the integrity checks of the `WebDAV`.
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	storedHash := c.Cacher.NewHash()
+	storedSize, err := io.Copy(storedHash, file)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	modTime := cache.ModTime()
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	return c.Cacher.SetCache(ctx, &diskCache{
+		file:     file,
+		name:     cache.Name(),
+		mimeType: cache.MIMEType(),
+		size:     storedSize,
+		modTime:  modTime,
+		checksum: storedHash.Sum(nil),
+	})
+}
+
+// Caches implements the `goproxy.CacheLister`. The underlying cacher must
+// implement the `goproxy.CacheLister`.
+func (c *Compressed) Caches(
+	ctx context.Context,
+	prefix string,
+) ([]string, error) {
+	cl, ok := c.Cacher.(goproxy.CacheLister)
+	if !ok {
+		return nil, errors.New("cacher cannot list caches")
+	}
+
+	return cl.Caches(ctx, prefix)
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`. The underlying cacher
+// must implement the `goproxy.CacheDeleter`.
+func (c *Compressed) DeleteCache(ctx context.Context, name string) error {
+	cd, ok := c.Cacher.(goproxy.CacheDeleter)
+	if !ok {
+		return errors.New("cacher cannot delete caches")
+	}
+
+	return cd.DeleteCache(ctx, name)
+}
+
+// compresses reports whether the c compresses the cache of the name.
+func (c *Compressed) compresses(name string) bool {
+	nameExts := c.NameExts
+	if len(nameExts) == 0 {
+		nameExts = []string{".info", ".mod"}
+	}
+
+	nameExt := path.Ext(name)
+	for _, ne := range nameExts {
+		if ne == nameExt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compressedHeader is the header of a compressed content stored by a
+// `Compressed`.
+type compressedHeader struct {
+	encoding        string
+	size            int64
+	checksum        []byte
+	encodedChecksum []byte
+}
+
+// marshal returns the binary form of the h.
+func (h *compressedHeader) marshal() []byte {
+	b := append([]byte(nil), compressedMagic...)
+	b = append(b, byte(len(h.encoding)))
+	b = append(b, h.encoding...)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(b[len(b)-8:], uint64(h.size))
+	b = append(b, byte(len(h.checksum)))
+	b = append(b, h.checksum...)
+	b = append(b, byte(len(h.encodedChecksum)))
+	return append(b, h.encodedChecksum...)
+}
+
+// readCompressedCache reads the header of the uc, whose magic number has
+// already been read, and returns a new instance of the `compressedCache` for
+// it.
+func readCompressedCache(uc goproxy.Cache) (*compressedCache, error) {
+	offset := int64(len(compressedMagic))
+	readField := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		_, err := io.ReadFull(uc, b)
+		offset += int64(n)
+		return b, err
+	}
+
+	b, err := readField(1)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding, err := readField(int(b[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	codec, ok := compressedCodecs[string(encoding)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported encoding: %q", encoding)
+	}
+
+	size, err := readField(8)
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = readField(1); err != nil {
+		return nil, err
+	}
+
+	checksum, err := readField(int(b[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = readField(1); err != nil {
+		return nil, err
+	}
+
+	encodedChecksum, err := readField(int(b[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	return &compressedCache{
+		Cache: uc,
+		header: &compressedHeader{
+			encoding:        string(encoding),
+			size:            int64(binary.BigEndian.Uint64(size)),
+			checksum:        checksum,
+			encodedChecksum: encodedChecksum,
+		},
+		codec:         codec,
+		encodedOffset: offset,
+	}, nil
+}
+
+// compressedCache implements the `goproxy.EncodedCache`. It is the cache unit
+// of the `Compressed`, which decompresses the content of an underlying cache
+// on the fly.
+type compressedCache struct {
+	goproxy.Cache
+
+	header        *compressedHeader
+	codec         compressedCodec
+	encodedOffset int64
+	offset        int64
+	decoded       int64
+	decoder       io.ReadCloser
+}
+
+// Read implements the `goproxy.EncodedCache`.
+func (cc *compressedCache) Read(b []byte) (int, error) {
+	if cc.offset >= cc.header.size {
+		return 0, io.EOF
+	}
+
+	// Seeking backward restarts the decompression, and seeking forward
+	// skips the decompressed content in between.
+	if cc.decoder == nil || cc.offset < cc.decoded {
+		if cc.decoder != nil {
+			cc.decoder.Close()
+		}
+
+		decoder, err := cc.codec.newReader(cc.newEncodedCache())
+		if err != nil {
+			return 0, err
+		}
+
+		cc.decoder = decoder
+		cc.decoded = 0
+	}
+
+	if cc.offset > cc.decoded {
+		n, err := io.CopyN(
+			ioutil.Discard,
+			cc.decoder,
+			cc.offset-cc.decoded,
+		)
+		cc.decoded += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := cc.decoder.Read(b)
+	cc.decoded += int64(n)
+	cc.offset = cc.decoded
+
+	return n, err
+}
+
+// Seek implements the `goproxy.EncodedCache`.
+func (cc *compressedCache) Seek(offset int64, whence int) (int64, error) {
+	return seekOffset(&cc.offset, cc.Size(), offset, whence)
+}
+
+// Close implements the `goproxy.EncodedCache`.
+func (cc *compressedCache) Close() error {
+	if cc.decoder != nil {
+		cc.decoder.Close()
+	}
+
+	return cc.Cache.Close()
+}
+
+// Size implements the `goproxy.EncodedCache`.
+func (cc *compressedCache) Size() int64 {
+	return cc.header.size
+}
+
+// Checksum implements the `goproxy.EncodedCache`.
+func (cc *compressedCache) Checksum() []byte {
+	return cc.header.checksum
+}
+
+// ContentEncoding implements the `goproxy.EncodedCache`.
+func (cc *compressedCache) ContentEncoding() string {
+	return cc.header.encoding
+}
+
+// Encoded implements the `goproxy.EncodedCache`.
+func (cc *compressedCache) Encoded() goproxy.Cache {
+	return cc.newEncodedCache()
+}
+
+// newEncodedCache returns a new instance of the `encodedCache` for the
+// compressed content of the cc.
+func (cc *compressedCache) newEncodedCache() *encodedCache {
+	return &encodedCache{
+		Cache:    cc.Cache,
+		start:    cc.encodedOffset,
+		size:     cc.Cache.Size() - cc.encodedOffset,
+		checksum: cc.header.encodedChecksum,
+	}
+}
+
+// encodedCache implements the `goproxy.Cache`. It is the compressed content of
+// a `compressedCache`, which reads a section of the underlying cache at its
+// own offset, so that several of them can share the underlying cache.
+type encodedCache struct {
+	goproxy.Cache
+
+	start    int64
+	size     int64
+	offset   int64
+	checksum []byte
+}
+
+// Read implements the `goproxy.Cache`.
+func (ec *encodedCache) Read(b []byte) (int, error) {
+	if ec.offset >= ec.size {
+		return 0, io.EOF
+	}
+
+	if _, err := ec.Cache.Seek(
+		ec.start+ec.offset,
+		io.SeekStart,
+	); err != nil {
+		return 0, err
+	}
+
+	if int64(len(b)) > ec.size-ec.offset {
+		b = b[:ec.size-ec.offset]
+	}
+
+	n, err := ec.Cache.Read(b)
+	ec.offset += int64(n)
+
+	return n, err
+}
+
+// Seek implements the `goproxy.Cache`.
+func (ec *encodedCache) Seek(offset int64, whence int) (int64, error) {
+	return seekOffset(&ec.offset, ec.size, offset, whence)
+}
+
+// Close implements the `goproxy.Cache`.
+func (ec *encodedCache) Close() error {
+	return nil
+}
+
+// Size implements the `goproxy.Cache`.
+func (ec *encodedCache) Size() int64 {
+	return ec.size
+}
+
+// Checksum implements the `goproxy.Cache`.
+func (ec *encodedCache) Checksum() []byte {
+	return ec.checksum
+}
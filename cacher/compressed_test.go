@@ -0,0 +1,275 @@
+package cacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/goproxy/goproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressed(t *testing.T) {
+	content := bytes.Repeat([]byte(`{"Version":"v1.0.0"}`), 10)
+	checksum := md5.Sum(content)
+
+	for _, encoding := range []string{"", "gzip", "zstd"} {
+		m := &Memory{}
+		c := &Compressed{Cacher: m, Encoding: encoding}
+
+		assert.NoError(t, c.SetCache(
+			context.Background(),
+			newTestCache("foo.info", content),
+		), encoding)
+
+		stored, err := cacheContent(m, "foo.info")
+		assert.NoError(t, err, encoding)
+		assert.True(
+			t,
+			bytes.HasPrefix(stored, compressedMagic),
+			encoding,
+		)
+		assert.Less(t, len(stored), len(content), encoding)
+
+		// The underlying cache has the checksum of the stored content,
+		// not the one of the original content.
+		uc, err := m.Cache(context.Background(), "foo.info")
+		assert.NoError(t, err, encoding)
+		storedChecksum := md5.Sum(stored)
+		assert.Equal(t, storedChecksum[:], uc.Checksum(), encoding)
+		assert.Equal(t, int64(len(stored)), uc.Size(), encoding)
+		assert.NoError(t, uc.Close())
+
+		cache, err := c.Cache(context.Background(), "foo.info")
+		assert.NoError(t, err, encoding)
+
+		ec, ok := cache.(goproxy.EncodedCache)
+		assert.True(t, ok, encoding)
+
+		wantEncoding := encoding
+		if wantEncoding == "" {
+			wantEncoding = "gzip"
+		}
+
+		assert.Equal(t, wantEncoding, ec.ContentEncoding())
+		assert.Equal(t, "foo.info", ec.Name())
+		assert.Equal(t, int64(len(content)), ec.Size())
+		assert.Equal(t, checksum[:], ec.Checksum())
+
+		b, err := ioutil.ReadAll(ec)
+		assert.NoError(t, err, encoding)
+		assert.Equal(t, content, b, encoding)
+
+		// The encoded representation is the stored content after the
+		// header, and its checksum is the one of that content.
+		encoded := ec.Encoded()
+		header := (&compressedHeader{
+			encoding:        wantEncoding,
+			size:            int64(len(content)),
+			checksum:        checksum[:],
+			encodedChecksum: encoded.Checksum(),
+		}).marshal()
+		assert.Equal(t, header, stored[:len(header)], encoding)
+
+		assert.Equal(t, int64(len(stored)-len(header)), encoded.Size())
+		encodedChecksum := md5.Sum(stored[len(header):])
+		assert.Equal(t, encodedChecksum[:], encoded.Checksum())
+
+		b, err = ioutil.ReadAll(encoded)
+		assert.NoError(t, err, encoding)
+		assert.Equal(t, stored[len(header):], b, encoding)
+		assert.NoError(t, encoded.Close())
+
+		assert.NoError(t, ec.Close())
+	}
+
+	// The caches whose names do not match the `NameExts` are stored as
+	// is.
+	m := &Memory{}
+	c := &Compressed{Cacher: m}
+	assert.NoError(t, c.SetCache(
+		context.Background(),
+		newTestCache("foo.zip", content),
+	))
+
+	stored, err := cacheContent(m, "foo.zip")
+	assert.NoError(t, err)
+	assert.Equal(t, content, stored)
+
+	c = &Compressed{Cacher: m, NameExts: []string{".zip"}}
+	assert.NoError(t, c.SetCache(
+		context.Background(),
+		newTestCache("foo.zip", content),
+	))
+
+	stored, err = cacheContent(m, "foo.zip")
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(stored, compressedMagic))
+
+	_, err = c.Cache(context.Background(), "missing")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	c = &Compressed{Cacher: m, Encoding: "br"}
+	assert.EqualError(t, c.SetCache(
+		context.Background(),
+		newTestCache("foo.info", content),
+	), `unsupported encoding: "br"`)
+}
+
+func TestCompressedSeek(t *testing.T) {
+	c := &Compressed{Cacher: &Memory{}}
+
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	assert.NoError(t, c.SetCache(
+		context.Background(),
+		newTestCache("foo.mod", content),
+	))
+
+	cache, err := c.Cache(context.Background(), "foo.mod")
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	cc := cache.(*compressedCache)
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		n, err := io.ReadFull(cc, b)
+		assert.NoError(t, err)
+		return b[:n]
+	}
+
+	assert.Equal(t, content[:100], read(100))
+	decoder := cc.decoder
+
+	// Seeking forward skips the decompressed content in between without
+	// restarting the decompression.
+	pos, err := cc.Seek(200, io.SeekCurrent)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(300), pos)
+	assert.Equal(t, content[300:350], read(50))
+	assert.True(t, decoder == cc.decoder)
+	assert.Equal(t, int64(350), cc.decoded)
+
+	// Seeking backward restarts the decompression.
+	pos, err = cc.Seek(10, io.SeekStart)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), pos)
+	assert.Equal(t, content[10:20], read(10))
+	assert.False(t, decoder == cc.decoder)
+	assert.Equal(t, int64(20), cc.decoded)
+
+	pos, err = cc.Seek(-10, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(990), pos)
+	assert.Equal(t, content[990:], read(10))
+
+	_, err = cc.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	n, err := cc.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = cc.Seek(-1, io.SeekStart)
+	assert.Error(t, err)
+
+	// The encoded representations seek on their own.
+	encoded := cc.Encoded()
+	encodedContent, err := ioutil.ReadAll(encoded)
+	assert.NoError(t, err)
+
+	_, err = encoded.Seek(3, io.SeekStart)
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, encodedContent[3:], b)
+
+	_, err = cc.Seek(500, io.SeekStart)
+	assert.NoError(t, err)
+	assert.Equal(t, content[500:510], read(10))
+}
+
+func TestCompressedInvalidHeader(t *testing.T) {
+	m := &Memory{}
+	c := &Compressed{Cacher: m}
+
+	content := []byte(`{"Version":"v1.0.0"}`)
+	assert.NoError(t, c.SetCache(
+		context.Background(),
+		newTestCache("foo.info", content),
+	))
+
+	stored, err := cacheContent(m, "foo.info")
+	assert.NoError(t, err)
+
+	headerSize := len(compressedMagic) + 1 + len("gzip") + 8 +
+		2*(1+md5.Size)
+
+	// Every truncation within the header is rejected.
+	for i := len(compressedMagic); i < headerSize; i++ {
+		assert.NoError(t, m.SetCache(
+			context.Background(),
+			newTestCache("truncated.info", stored[:i]),
+		))
+
+		_, err := c.Cache(context.Background(), "truncated.info")
+		assert.Error(t, err, i)
+		assert.Contains(
+			t,
+			err.Error(),
+			`invalid compressed cache "truncated.info"`,
+			i,
+		)
+	}
+
+	unsupported := append([]byte(nil), compressedMagic...)
+	unsupported = append(unsupported, 2, 'b', 'r')
+	assert.NoError(t, m.SetCache(
+		context.Background(),
+		newTestCache("unsupported.info", unsupported),
+	))
+
+	_, err = c.Cache(context.Background(), "unsupported.info")
+	assert.EqualError(
+		t,
+		err,
+		`invalid compressed cache "unsupported.info": `+
+			`unsupported encoding: "br"`,
+	)
+}
+
+func TestCompressedLegacy(t *testing.T) {
+	m := &Memory{}
+	c := &Compressed{Cacher: m}
+
+	// The caches stored before, including the ones shorter than the
+	// magic number, are read as is.
+	for name, content := range map[string][]byte{
+		"empty.info": {},
+		"short.mod":  []byte("mod"),
+		"foo.info":   []byte(`{"Version":"v1.0.0"}`),
+		"magic.mod":  compressedMagic[:len(compressedMagic)-1],
+	} {
+		assert.NoError(t, m.SetCache(
+			context.Background(),
+			newTestCache(name, content),
+		), name)
+
+		cache, err := c.Cache(context.Background(), name)
+		assert.NoError(t, err, name)
+
+		_, ok := cache.(goproxy.EncodedCache)
+		assert.False(t, ok, name)
+		assert.Equal(t, int64(len(content)), cache.Size(), name)
+
+		b, err := ioutil.ReadAll(cache)
+		assert.NoError(t, err, name)
+		assert.Equal(t, content, b, name)
+		assert.NoError(t, cache.Close())
+	}
+}
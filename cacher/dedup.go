@@ -0,0 +1,324 @@
+package cacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/goproxy/goproxy"
+)
+
+// Dedup implements the `goproxy.Cacher` by storing the contents of the caches
+// as content-addressed blobs in another `goproxy.Cacher`, so that identical
+// caches, such as the same ".mod" of many module versions, are only stored
+// once.
+//
+// Each content is stored as a blob named "blobs/<SHA-256>", and each cache is
+// stored as a small reference record named "refs/<name>" that points to its
+// blob. Blobs are never deleted by the `DeleteCache`, the unreferenced ones are
+// removed by the `GC`.
+type Dedup struct {
+	// Cacher is the underlying cacher, such as a `Disk` or a `MinIO`.
+	Cacher goproxy.Cacher `mapstructure:"cacher"`
+
+	// GCGracePeriod is the minimum age of the blobs removed by the `GC`, so
+	// that the blobs of the caches being set are not affected. An existing
+	// blob reused by the `SetCache` is rewritten if it is older than half
+	// of the `GCGracePeriod`, so that it is not removed before the new
+	// reference record to it is written.
+	//
+	// If the `GCGracePeriod` is zero, 1 hour is used.
+	GCGracePeriod time.Duration `mapstructure:"gc_grace_period"`
+}
+
+// dedupRef is the reference record of a cache of a `Dedup`.
+type dedupRef struct {
+	Blob     string
+	MIMEType string
+	ModTime  time.Time
+	Checksum []byte
+}
+
+// gcGracePeriod returns the minimum age of the blobs removed by the `GC`.
+func (d *Dedup) gcGracePeriod() time.Duration {
+	if d.GCGracePeriod == 0 {
+		return time.Hour
+	}
+
+	return d.GCGracePeriod
+}
+
+// NewHash implements the `goproxy.Cacher`.
+func (d *Dedup) NewHash() hash.Hash {
+	return d.Cacher.NewHash()
+}
+
+// Cache implements the `goproxy.Cacher`.
+func (d *Dedup) Cache(ctx context.Context, name string) (goproxy.Cache, error) {
+	ref, err := d.ref(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := d.Cacher.Cache(ctx, dedupBlobName(ref.Blob))
+	if err != nil {
+		return nil, err
+	}
+
+	return &dedupCache{
+		Cache: blob,
+		name:  name,
+		ref:   ref,
+	}, nil
+}
+
+// ref returns the reference record of the cache of the name.
+func (d *Dedup) ref(ctx context.Context, name string) (*dedupRef, error) {
+	c, err := d.Cacher.Cache(ctx, dedupRefName(name))
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := &dedupRef{}
+	if err := json.Unmarshal(b, ref); err != nil {
+		return nil, err
+	}
+
+	return ref, nil
+}
+
+// SetCache implements the `goproxy.Cacher`. The content of the c is only
+// written if there is no recent enough blob of it yet.
+func (d *Dedup) SetCache(ctx context.Context, c goproxy.Cache) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, c); err != nil {
+		return err
+	}
+
+	blob := hex.EncodeToString(h.Sum(nil))
+	blobName := dedupBlobName(blob)
+
+	writesBlob := true
+	if bc, err := d.Cacher.Cache(ctx, blobName); err == nil {
+		// The modification time of a blob is when it was last
+		// written, which the `GC` checks right before removing it.
+		writesBlob = time.Since(bc.ModTime()) >= d.gcGracePeriod()/2
+		bc.Close()
+	} else if err != goproxy.ErrCacheNotFound {
+		return err
+	}
+
+	if writesBlob {
+		if _, err := c.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := d.Cacher.SetCache(ctx, &dedupCache{
+			Cache: c,
+			name:  blobName,
+			ref: &dedupRef{
+				Blob:     blob,
+				MIMEType: c.MIMEType(),
+				ModTime:  time.Now().UTC(),
+				Checksum: c.Checksum(),
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	modTime := c.ModTime()
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	b, err := json.Marshal(&dedupRef{
+		Blob:     blob,
+		MIMEType: c.MIMEType(),
+		ModTime:  modTime.UTC(),
+		Checksum: c.Checksum(),
+	})
+	if err != nil {
+		return err
+	}
+
+	refHash := d.Cacher.NewHash()
+	refHash.Write(b)
+
+	me := &memoryEntry{
+		name:     dedupRefName(c.Name()),
+		mimeType: "application/json; charset=utf-8",
+		modTime:  time.Now(),
+		checksum: refHash.Sum(nil),
+		content:  b,
+	}
+
+	return d.Cacher.SetCache(ctx, &memoryCache{
+		Reader: bytes.NewReader(me.content),
+		entry:  me,
+	})
+}
+
+// Caches implements the `goproxy.CacheLister`. The underlying cacher must
+// implement the `goproxy.CacheLister`.
+func (d *Dedup) Caches(ctx context.Context, prefix string) ([]string, error) {
+	cl, ok := d.Cacher.(goproxy.CacheLister)
+	if !ok {
+		return nil, errors.New("cacher cannot list caches")
+	}
+
+	refNames, err := cl.Caches(ctx, dedupRefName(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(refNames))
+	for _, refName := range refNames {
+		names = append(names, strings.TrimPrefix(refName, "refs/"))
+	}
+
+	return names, nil
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`. Only the reference record
+// of the cache is deleted, its blob is left to the `GC`. The underlying cacher
+// must implement the `goproxy.CacheDeleter`.
+func (d *Dedup) DeleteCache(ctx context.Context, name string) error {
+	cd, ok := d.Cacher.(goproxy.CacheDeleter)
+	if !ok {
+		return errors.New("cacher cannot delete caches")
+	}
+
+	return cd.DeleteCache(ctx, dedupRefName(name))
+}
+
+// GC removes the blobs that are no longer referenced by any cache and are
+// older than the `GCGracePeriod`. It returns the number of the removed blobs.
+// The underlying cacher must implement both the `goproxy.CacheLister` and the
+// `goproxy.CacheDeleter`.
+func (d *Dedup) GC(ctx context.Context) (int, error) {
+	cl, ok := d.Cacher.(goproxy.CacheLister)
+	if !ok {
+		return 0, errors.New("cacher cannot list caches")
+	}
+
+	cd, ok := d.Cacher.(goproxy.CacheDeleter)
+	if !ok {
+		return 0, errors.New("cacher cannot delete caches")
+	}
+
+	// The blobs are listed before the references, so that a blob set
+	// in the meantime is either not listed or referenced.
+	blobNames, err := cl.Caches(ctx, "blobs/")
+	if err != nil {
+		return 0, err
+	}
+
+	refNames, err := cl.Caches(ctx, "refs/")
+	if err != nil {
+		return 0, err
+	}
+
+	referenced := make(map[string]bool, len(refNames))
+	for _, refName := range refNames {
+		ref, err := d.ref(ctx, strings.TrimPrefix(refName, "refs/"))
+		if err != nil {
+			if err == goproxy.ErrCacheNotFound {
+				continue
+			}
+
+			return 0, err
+		}
+
+		referenced[dedupBlobName(ref.Blob)] = true
+	}
+
+	removed := 0
+	for _, blobName := range blobNames {
+		if referenced[blobName] {
+			continue
+		}
+
+		bc, err := d.Cacher.Cache(ctx, blobName)
+		if err != nil {
+			if err == goproxy.ErrCacheNotFound {
+				continue
+			}
+
+			return removed, err
+		}
+
+		modTime := bc.ModTime()
+		bc.Close()
+		if time.Since(modTime) < d.gcGracePeriod() {
+			continue
+		}
+
+		if err := cd.DeleteCache(ctx, blobName); err != nil {
+			if err == goproxy.ErrCacheNotFound {
+				continue
+			}
+
+			return removed, err
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
+
+// dedupBlobName returns the name of the blob of the SHA-256 hex digest.
+func dedupBlobName(blob string) string {
+	return "blobs/" + blob
+}
+
+// dedupRefName returns the name of the reference record of the cache of the
+// name.
+func dedupRefName(name string) string {
+	return "refs/" + name
+}
+
+// dedupCache implements the `goproxy.Cache`. It is the cache unit of the
+// `Dedup`, which reads from a blob but carries the metadata of the ref. It is
+// also used to write the blobs.
+type dedupCache struct {
+	goproxy.Cache
+
+	name string
+	ref  *dedupRef
+}
+
+// Name implements the `goproxy.Cache`.
+func (dc *dedupCache) Name() string {
+	return dc.name
+}
+
+// MIMEType implements the `goproxy.Cache`.
+func (dc *dedupCache) MIMEType() string {
+	return dc.ref.MIMEType
+}
+
+// ModTime implements the `goproxy.Cache`.
+func (dc *dedupCache) ModTime() time.Time {
+	return dc.ref.ModTime
+}
+
+// Checksum implements the `goproxy.Cache`.
+func (dc *dedupCache) Checksum() []byte {
+	return dc.ref.Checksum
+}
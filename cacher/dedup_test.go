@@ -0,0 +1,195 @@
+package cacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestBlob stores the content as a blob last written at the modTime in the
+// m, and returns the name of the blob.
+func newTestBlob(m *Memory, content []byte, modTime time.Time) string {
+	digest := sha256.Sum256(content)
+	c := newTestCache(
+		dedupBlobName(hex.EncodeToString(digest[:])),
+		content,
+	).(*memoryCache)
+	c.entry.modTime = modTime
+	m.SetCache(context.Background(), c)
+
+	return c.Name()
+}
+
+func TestDedup(t *testing.T) {
+	m := &Memory{}
+	d := &Dedup{Cacher: m}
+
+	content := []byte("module example.com/foo\n")
+	for _, name := range []string{
+		"example.com/foo/@v/v1.0.0.mod",
+		"example.com/foo/@v/v1.1.0.mod",
+	} {
+		assert.NoError(t, d.SetCache(
+			context.Background(),
+			newTestCache(name, content),
+		))
+	}
+
+	// The identical contents are stored once.
+	blobNames, err := m.Caches(context.Background(), "blobs/")
+	assert.NoError(t, err)
+	assert.Len(t, blobNames, 1)
+
+	c, err := d.Cache(context.Background(), "example.com/foo/@v/v1.1.0.mod")
+	assert.NoError(t, err)
+	checksum := md5.Sum(content)
+	assert.Equal(t, "example.com/foo/@v/v1.1.0.mod", c.Name())
+	assert.Equal(t, "application/octet-stream", c.MIMEType())
+	assert.Equal(t, int64(len(content)), c.Size())
+	assert.Equal(t, checksum[:], c.Checksum())
+	assert.Equal(t, time.UTC, c.ModTime().Location())
+	assert.NoError(t, c.Close())
+
+	names, err := d.Caches(context.Background(), "example.com/foo/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"example.com/foo/@v/v1.0.0.mod",
+		"example.com/foo/@v/v1.1.0.mod",
+	}, names)
+
+	// Deleting a cache leaves its blob to the `GC`.
+	assert.NoError(t, d.DeleteCache(
+		context.Background(),
+		"example.com/foo/@v/v1.0.0.mod",
+	))
+	assert.Equal(t, goproxy.ErrCacheNotFound, d.DeleteCache(
+		context.Background(),
+		"example.com/foo/@v/v1.0.0.mod",
+	))
+
+	_, err = d.Cache(context.Background(), "example.com/foo/@v/v1.0.0.mod")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	b, err := cacheContent(d, "example.com/foo/@v/v1.1.0.mod")
+	assert.NoError(t, err)
+	assert.Equal(t, content, b)
+
+	d = &Dedup{Cacher: &plainCacher{m}}
+
+	_, err = d.Caches(context.Background(), "")
+	assert.EqualError(t, err, "cacher cannot list caches")
+
+	assert.EqualError(
+		t,
+		d.DeleteCache(context.Background(), "foo"),
+		"cacher cannot delete caches",
+	)
+
+	_, err = d.GC(context.Background())
+	assert.EqualError(t, err, "cacher cannot list caches")
+
+	_, err = (&Dedup{Cacher: &brokenLister{m}}).GC(context.Background())
+	assert.EqualError(t, err, "cacher cannot list caches")
+}
+
+func TestDedupGC(t *testing.T) {
+	m := &Memory{}
+	d := &Dedup{Cacher: m, GCGracePeriod: time.Hour}
+
+	assert.NoError(t, d.SetCache(
+		context.Background(),
+		newTestCache("referenced", []byte("referenced")),
+	))
+
+	old := time.Now().Add(-2 * time.Hour)
+	oldBlobName := newTestBlob(m, []byte("old"), old)
+	recentBlobName := newTestBlob(m, []byte("recent"), time.Now())
+
+	// Only the unreferenced blobs older than the grace period are
+	// removed, no matter how old the caches of the blobs are.
+	removed, err := d.GC(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	_, err = m.Cache(context.Background(), oldBlobName)
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	_, err = m.Cache(context.Background(), recentBlobName)
+	assert.NoError(t, err)
+
+	c := newTestCache("ancient", []byte("ancient")).(*memoryCache)
+	c.entry.modTime = old
+	assert.NoError(t, d.SetCache(context.Background(), c))
+	assert.NoError(t, d.DeleteCache(context.Background(), "ancient"))
+
+	removed, err = d.GC(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	b, err := cacheContent(d, "referenced")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("referenced"), b)
+
+	// The blobs are removed once the references to them are gone and the
+	// grace period is over.
+	d.GCGracePeriod = time.Nanosecond
+	assert.NoError(t, d.DeleteCache(context.Background(), "referenced"))
+
+	removed, err = d.GC(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, removed)
+
+	blobNames, err := m.Caches(context.Background(), "blobs/")
+	assert.NoError(t, err)
+	assert.Empty(t, blobNames)
+}
+
+func TestDedupGCReusedBlob(t *testing.T) {
+	m := &Memory{}
+	d := &Dedup{Cacher: m, GCGracePeriod: time.Hour}
+
+	// An old blob that is about to be removed as unreferenced is
+	// refreshed when it is reused, so that a `GC` that listed the
+	// references before the new one was written does not remove it.
+	old := time.Now().Add(-2 * time.Hour)
+	blobName := newTestBlob(m, []byte("foo"), old)
+
+	assert.NoError(t, d.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+
+	blob, err := m.Cache(context.Background(), blobName)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), blob.ModTime(), time.Minute)
+
+	assert.NoError(t, m.DeleteCache(context.Background(), "refs/foo"))
+
+	removed, err := d.GC(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	// A recent blob is reused as is.
+	recent := time.Now().Add(-time.Minute)
+	blobName = newTestBlob(m, []byte("bar"), recent)
+
+	assert.NoError(t, d.SetCache(
+		context.Background(),
+		newTestCache("bar", []byte("bar")),
+	))
+
+	blob, err = m.Cache(context.Background(), blobName)
+	assert.NoError(t, err)
+	assert.Equal(t, recent, blob.ModTime())
+
+	b, err := cacheContent(d, "bar")
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal([]byte("bar"), b))
+}
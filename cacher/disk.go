@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/goproxy/goproxy"
@@ -103,6 +105,80 @@ func (d *Disk) SetCache(ctx context.Context, c goproxy.Cache) error {
 	return ioutil.WriteFile(filename, b, os.ModePerm)
 }
 
+// Caches implements the `goproxy.CacheLister`.
+func (d *Disk) Caches(ctx context.Context, prefix string) ([]string, error) {
+	root := filepath.Clean(d.Root)
+
+	dir := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = filepath.Join(root, filepath.FromSlash(prefix[:i]))
+		if rel, err := filepath.Rel(root, dir); err != nil ||
+			strings.HasPrefix(rel, "..") {
+			return nil, nil
+		}
+	}
+
+	var names []string
+	if err := filepath.Walk(dir, func(
+		filename string,
+		fileInfo os.FileInfo,
+		err error,
+	) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if fileInfo.IsDir() ||
+			strings.HasSuffix(filename, ".mime-type") ||
+			strings.HasSuffix(filename, ".checksum") {
+			return nil
+		}
+
+		name, err := filepath.Rel(root, filename)
+		if err != nil {
+			return err
+		}
+
+		name = filepath.ToSlash(name)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (d *Disk) DeleteCache(ctx context.Context, name string) error {
+	filename := filepath.Join(d.Root, filepath.FromSlash(name))
+	if err := os.Remove(filename); err != nil {
+		if os.IsNotExist(err) {
+			return goproxy.ErrCacheNotFound
+		}
+
+		return err
+	}
+
+	for _, ext := range []string{".mime-type", ".checksum"} {
+		err := os.Remove(fmt.Sprint(filename, ext))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // diskCache implements the `goproxy.Cache`. It is the cache unit of the `Disk`.
 type diskCache struct {
 	file     *os.File
@@ -4,6 +4,7 @@ import (
 	"context"
 	"hash"
 	"sync"
+	"time"
 
 	"github.com/goproxy/goproxy"
 )
@@ -28,6 +29,9 @@ type DOS struct {
 	// Root is the root of the caches.
 	Root string `mapstructure:"root"`
 
+	// MinIOOptions is the advanced options.
+	MinIOOptions `mapstructure:",squash"`
+
 	loadOnce sync.Once
 	minio    *MinIO
 }
@@ -45,6 +49,7 @@ func (d *DOS) load() {
 		SecretAccessKey: d.SecretKey,
 		BucketName:      d.SpaceName,
 		Root:            d.Root,
+		MinIOOptions:    d.MinIOOptions,
 		virtualHosted:   true,
 	}
 }
@@ -65,3 +70,25 @@ func (d *DOS) SetCache(ctx context.Context, c goproxy.Cache) error {
 	d.loadOnce.Do(d.load)
 	return d.minio.SetCache(ctx, c)
 }
+
+// Caches implements the `goproxy.CacheLister`.
+func (d *DOS) Caches(ctx context.Context, prefix string) ([]string, error) {
+	d.loadOnce.Do(d.load)
+	return d.minio.Caches(ctx, prefix)
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (d *DOS) DeleteCache(ctx context.Context, name string) error {
+	d.loadOnce.Do(d.load)
+	return d.minio.DeleteCache(ctx, name)
+}
+
+// PresignCache implements the `goproxy.CachePresigner`.
+func (d *DOS) PresignCache(
+	ctx context.Context,
+	name string,
+	expiry time.Duration,
+) (string, error) {
+	d.loadOnce.Do(d.load)
+	return d.minio.PresignCache(ctx, name, expiry)
+}
@@ -0,0 +1,610 @@
+package cacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/goproxy/goproxy"
+)
+
+// encryptedMagic is the magic number at the beginning of the contents stored
+// by an `Encrypted`.
+var encryptedMagic = []byte("GPENC1")
+
+// KeyProvider is the interface that provides the keys of an `Encrypted`.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key used to encrypt new caches.
+	CurrentKey(ctx context.Context) (string, []byte, error)
+
+	// Key returns the key of the id. It is used to decrypt caches, so
+	// the keys that were once current must be kept as long as there are
+	// caches encrypted with them.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// Encrypted implements the `goproxy.Cacher` by encrypting the contents of the
+// caches before storing them in another `goproxy.Cacher`, such as an `S3` or
+// an `OSS`.
+//
+// The contents are encrypted with AES-GCM in chunks, each of which is
+// authenticated on its own, so seeking in a cache only decrypts the chunks that
+// are read. The ID of the key of each cache is stored along with its content,
+// so keys can be rotated by changing the current key of the `KeyProvider`, and
+// the existing caches can be encrypted with the current key by the `Rekey`.
+//
+// The MIME types of the caches are stored as is by the underlying cacher. The
+// checksums stored by it are the ones of the encrypted contents, so they reveal
+// nothing about the plaintexts. They are also the checksums of the caches
+// returned by the `Encrypted`.
+type Encrypted struct {
+	// Cacher is the underlying cacher.
+	Cacher goproxy.Cacher `mapstructure:"cacher"`
+
+	// KeyProvider is the provider of the keys.
+	//
+	// If the `KeyProvider` is nil, the `Keys` and the `KeyID` are used.
+	KeyProvider KeyProvider `mapstructure:"-"`
+
+	// Keys is the base64-encoded AES keys (16, 24, or 32 bytes) keyed by
+	// their IDs. It is only used when the `KeyProvider` is nil.
+	Keys map[string]string `mapstructure:"keys"`
+
+	// KeyID is the ID of the key in the `Keys` used to encrypt new
+	// caches. It is only used when the `KeyProvider` is nil.
+	KeyID string `mapstructure:"key_id"`
+
+	// ChunkSize is the size in bytes of the plaintext of each chunk.
+	//
+	// If the `ChunkSize` is zero, 64 KiB is used.
+	ChunkSize int `mapstructure:"chunk_size"`
+
+	loadOnce    sync.Once
+	loadError   error
+	keyProvider KeyProvider
+}
+
+// load loads the stuff of the e up.
+func (e *Encrypted) load() {
+	if e.KeyProvider != nil {
+		e.keyProvider = e.KeyProvider
+		return
+	}
+
+	skp := &staticKeyProvider{
+		id:   e.KeyID,
+		keys: make(map[string][]byte, len(e.Keys)),
+	}
+	for id, key := range e.Keys {
+		if skp.keys[id], e.loadError = base64.StdEncoding.DecodeString(
+			key,
+		); e.loadError != nil {
+			e.loadError = fmt.Errorf("key %q: %v", id, e.loadError)
+			return
+		}
+	}
+
+	if _, ok := skp.keys[skp.id]; !ok {
+		e.loadError = fmt.Errorf("unknown key ID: %q", skp.id)
+		return
+	}
+
+	e.keyProvider = skp
+}
+
+// NewHash implements the `goproxy.Cacher`.
+func (e *Encrypted) NewHash() hash.Hash {
+	return e.Cacher.NewHash()
+}
+
+// Cache implements the `goproxy.Cacher`.
+func (e *Encrypted) Cache(
+	ctx context.Context,
+	name string,
+) (goproxy.Cache, error) {
+	if e.loadOnce.Do(e.load); e.loadError != nil {
+		return nil, e.loadError
+	}
+
+	c, err := e.Cacher.Cache(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := e.newDecryptingCache(ctx, c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return dc, nil
+}
+
+// SetCache implements the `goproxy.Cacher`.
+func (e *Encrypted) SetCache(ctx context.Context, c goproxy.Cache) error {
+	if e.loadOnce.Do(e.load); e.loadError != nil {
+		return e.loadError
+	}
+
+	keyID, key, err := e.keyProvider.CurrentKey(ctx)
+	if err != nil {
+		return err
+	} else if len(keyID) > 255 {
+		return fmt.Errorf("key ID is too long: %q", keyID)
+	}
+
+	chunkSize := e.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = 64 << 10
+	}
+
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	h := &encryptedHeader{
+		keyID:     keyID,
+		chunkSize: chunkSize,
+		size:      c.Size(),
+		nonce:     nonce,
+	}
+
+	aead, err := newEncryptedAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	ec := &encryptingCache{
+		Cache:  c,
+		header: h,
+		raw:    h.marshal(),
+		aead:   aead,
+	}
+
+	// The checksum of the plaintext must not be handed to the underlying
+	// cacher, which may store it in cleartext metadata.
+	checksumHash := e.Cacher.NewHash()
+	if _, err := io.Copy(checksumHash, ec); err != nil {
+		return err
+	}
+
+	if _, err := ec.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	ec.checksum = checksumHash.Sum(nil)
+
+	return e.Cacher.SetCache(ctx, ec)
+}
+
+// Caches implements the `goproxy.CacheLister`. The underlying cacher must
+// implement the `goproxy.CacheLister`.
+func (e *Encrypted) Caches(
+	ctx context.Context,
+	prefix string,
+) ([]string, error) {
+	cl, ok := e.Cacher.(goproxy.CacheLister)
+	if !ok {
+		return nil, errors.New("cacher cannot list caches")
+	}
+
+	return cl.Caches(ctx, prefix)
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`. The underlying cacher
+// must implement the `goproxy.CacheDeleter`.
+func (e *Encrypted) DeleteCache(ctx context.Context, name string) error {
+	cd, ok := e.Cacher.(goproxy.CacheDeleter)
+	if !ok {
+		return errors.New("cacher cannot delete caches")
+	}
+
+	return cd.DeleteCache(ctx, name)
+}
+
+// Rekey encrypts the caches that have the prefix and are not encrypted with
+// the current key again with it. It returns the number of the caches
+// encrypted again. The underlying cacher must implement the
+// `goproxy.CacheLister`.
+func (e *Encrypted) Rekey(ctx context.Context, prefix string) (int, error) {
+	if e.loadOnce.Do(e.load); e.loadError != nil {
+		return 0, e.loadError
+	}
+
+	cl, ok := e.Cacher.(goproxy.CacheLister)
+	if !ok {
+		return 0, errors.New("cacher cannot list caches")
+	}
+
+	keyID, _, err := e.keyProvider.CurrentKey(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	names, err := cl.Caches(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	rekeyed := 0
+	for _, name := range names {
+		c, err := e.Cache(ctx, name)
+		if err != nil {
+			if err == goproxy.ErrCacheNotFound {
+				continue
+			}
+
+			return rekeyed, err
+		}
+
+		if c.(*decryptingCache).header.keyID != keyID {
+			err = e.SetCache(ctx, c)
+			if err == nil {
+				rekeyed++
+			}
+		}
+
+		c.Close()
+		if err != nil {
+			return rekeyed, err
+		}
+	}
+
+	return rekeyed, nil
+}
+
+// newDecryptingCache returns a new instance of the `decryptingCache` for the
+// c.
+func (e *Encrypted) newDecryptingCache(
+	ctx context.Context,
+	c goproxy.Cache,
+) (*decryptingCache, error) {
+	h, raw, err := readEncryptedHeader(c)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := e.keyProvider.Key(ctx, h.keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newEncryptedAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if want := h.ciphertextSize(aead.Overhead()); c.Size() != want {
+		return nil, fmt.Errorf(
+			"encrypted cache %q has size %d, want %d",
+			c.Name(),
+			c.Size(),
+			want,
+		)
+	}
+
+	return &decryptingCache{
+		Cache:      c,
+		header:     h,
+		raw:        raw,
+		aead:       aead,
+		chunkIndex: -1,
+	}, nil
+}
+
+// newEncryptedAEAD returns a new AES-GCM `cipher.AEAD` of the key.
+func newEncryptedAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// staticKeyProvider implements the `KeyProvider` with fixed keys.
+type staticKeyProvider struct {
+	id   string
+	keys map[string][]byte
+}
+
+// CurrentKey implements the `KeyProvider`.
+func (skp *staticKeyProvider) CurrentKey(
+	ctx context.Context,
+) (string, []byte, error) {
+	return skp.id, skp.keys[skp.id], nil
+}
+
+// Key implements the `KeyProvider`.
+func (skp *staticKeyProvider) Key(
+	ctx context.Context,
+	id string,
+) ([]byte, error) {
+	key, ok := skp.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID: %q", id)
+	}
+
+	return key, nil
+}
+
+// encryptedHeader is the header of a content stored by an `Encrypted`. It is
+// authenticated as part of each chunk.
+type encryptedHeader struct {
+	keyID     string
+	chunkSize int
+	size      int64
+	nonce     []byte
+}
+
+// marshal returns the binary form of the h.
+func (h *encryptedHeader) marshal() []byte {
+	b := make([]byte, 0, len(encryptedMagic)+1+len(h.keyID)+4+8+12)
+	b = append(b, encryptedMagic...)
+	b = append(b, byte(len(h.keyID)))
+	b = append(b, h.keyID...)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-12:], uint32(h.chunkSize))
+	binary.BigEndian.PutUint64(b[len(b)-8:], uint64(h.size))
+	return append(b, h.nonce...)
+}
+
+// chunks returns the number of the chunks described by the h. There is always
+// at least one chunk, even for an empty plaintext.
+func (h *encryptedHeader) chunks() int64 {
+	chunkSize := int64(h.chunkSize)
+	if h.size == 0 {
+		return 1
+	}
+
+	return (h.size + chunkSize - 1) / chunkSize
+}
+
+// ciphertextSize returns the size of the whole content described by the h,
+// including the header, for the AEAD overhead.
+func (h *encryptedHeader) ciphertextSize(overhead int) int64 {
+	headerSize := int64(len(encryptedMagic) + 1 + len(h.keyID) + 4 + 8 + 12)
+	return headerSize + h.size + h.chunks()*int64(overhead)
+}
+
+// additionalData returns the additional data of the chunk of the index.
+func (h *encryptedHeader) additionalData(raw []byte, index int64) []byte {
+	ad := make([]byte, len(raw)+9)
+	copy(ad, raw)
+	binary.BigEndian.PutUint64(ad[len(raw):], uint64(index))
+	if index == h.chunks()-1 {
+		ad[len(ad)-1] = 1
+	}
+
+	return ad
+}
+
+// chunkNonce returns the nonce of the chunk of the index.
+func (h *encryptedHeader) chunkNonce(index int64) []byte {
+	nonce := append([]byte(nil), h.nonce...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(index >> (8 * uint(i)))
+	}
+
+	return nonce
+}
+
+// readEncryptedHeader reads the header from the beginning of the r.
+func readEncryptedHeader(r io.Reader) (*encryptedHeader, []byte, error) {
+	errInvalid := errors.New("invalid encrypted cache header")
+
+	prefix := make([]byte, len(encryptedMagic)+1)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, nil, errInvalid
+	}
+
+	if !bytes.Equal(prefix[:len(encryptedMagic)], encryptedMagic) {
+		return nil, nil, errInvalid
+	}
+
+	rest := make([]byte, int(prefix[len(prefix)-1])+4+8+12)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, errInvalid
+	}
+
+	keyIDLen := len(rest) - 4 - 8 - 12
+	h := &encryptedHeader{
+		keyID: string(rest[:keyIDLen]),
+		chunkSize: int(binary.BigEndian.Uint32(
+			rest[keyIDLen : keyIDLen+4],
+		)),
+		size: int64(binary.BigEndian.Uint64(
+			rest[keyIDLen+4 : keyIDLen+12],
+		)),
+		nonce: rest[keyIDLen+12:],
+	}
+	if h.chunkSize <= 0 || h.size < 0 {
+		return nil, nil, errInvalid
+	}
+
+	return h, append(prefix, rest...), nil
+}
+
+// encryptingCache implements the `goproxy.Cache`. It encrypts a plaintext
+// cache on the fly for an `Encrypted` to store it in the underlying cacher.
+type encryptingCache struct {
+	goproxy.Cache
+
+	header     *encryptedHeader
+	raw        []byte
+	aead       cipher.AEAD
+	checksum   []byte
+	offset     int64
+	chunkIndex int64
+	chunk      []byte
+}
+
+// Read implements the `goproxy.Cache`.
+func (ec *encryptingCache) Read(b []byte) (int, error) {
+	if ec.offset < int64(len(ec.raw)) {
+		n := copy(b, ec.raw[ec.offset:])
+		ec.offset += int64(n)
+		return n, nil
+	}
+
+	if ec.offset >= ec.Size() {
+		return 0, io.EOF
+	}
+
+	sealedChunkSize := int64(ec.header.chunkSize + ec.aead.Overhead())
+	pos := ec.offset - int64(len(ec.raw))
+	index := pos / sealedChunkSize
+	if ec.chunk == nil || ec.chunkIndex != index {
+		plaintext := make([]byte, ec.header.chunkSize)
+		if _, err := ec.Cache.Seek(
+			index*int64(ec.header.chunkSize),
+			io.SeekStart,
+		); err != nil {
+			return 0, err
+		}
+
+		n, err := io.ReadFull(ec.Cache, plaintext)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, err
+		}
+
+		ec.chunk = ec.aead.Seal(
+			nil,
+			ec.header.chunkNonce(index),
+			plaintext[:n],
+			ec.header.additionalData(ec.raw, index),
+		)
+		ec.chunkIndex = index
+	}
+
+	n := copy(b, ec.chunk[pos-index*sealedChunkSize:])
+	ec.offset += int64(n)
+
+	return n, nil
+}
+
+// Seek implements the `goproxy.Cache`.
+func (ec *encryptingCache) Seek(offset int64, whence int) (int64, error) {
+	return seekOffset(&ec.offset, ec.Size(), offset, whence)
+}
+
+// Size implements the `goproxy.Cache`.
+func (ec *encryptingCache) Size() int64 {
+	return ec.header.ciphertextSize(ec.aead.Overhead())
+}
+
+// Checksum implements the `goproxy.Cache`.
+func (ec *encryptingCache) Checksum() []byte {
+	return ec.checksum
+}
+
+// decryptingCache implements the `goproxy.Cache`. It is the cache unit of the
+// `Encrypted`, which decrypts the chunks of an underlying cache on demand.
+type decryptingCache struct {
+	goproxy.Cache
+
+	header     *encryptedHeader
+	raw        []byte
+	aead       cipher.AEAD
+	offset     int64
+	chunkIndex int64
+	chunk      []byte
+}
+
+// Read implements the `goproxy.Cache`.
+func (dc *decryptingCache) Read(b []byte) (int, error) {
+	if dc.offset >= dc.header.size {
+		return 0, io.EOF
+	}
+
+	chunkSize := int64(dc.header.chunkSize)
+	index := dc.offset / chunkSize
+	if dc.chunkIndex != index {
+		sealedChunkSize := chunkSize + int64(dc.aead.Overhead())
+		if _, err := dc.Cache.Seek(
+			int64(len(dc.raw))+index*sealedChunkSize,
+			io.SeekStart,
+		); err != nil {
+			return 0, err
+		}
+
+		sealed := make([]byte, sealedChunkSize)
+		n, err := io.ReadFull(dc.Cache, sealed)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+
+		dc.chunk, err = dc.aead.Open(
+			dc.chunk[:0],
+			dc.header.chunkNonce(index),
+			sealed[:n],
+			dc.header.additionalData(dc.raw, index),
+		)
+		if err != nil {
+			dc.chunkIndex = -1
+			return 0, fmt.Errorf(
+				"failed to decrypt cache %q: %v",
+				dc.Name(),
+				err,
+			)
+		}
+
+		dc.chunkIndex = index
+	}
+
+	n := copy(b, dc.chunk[dc.offset-index*chunkSize:])
+	dc.offset += int64(n)
+
+	return n, nil
+}
+
+// Seek implements the `goproxy.Cache`.
+func (dc *decryptingCache) Seek(offset int64, whence int) (int64, error) {
+	return seekOffset(&dc.offset, dc.Size(), offset, whence)
+}
+
+// Size implements the `goproxy.Cache`.
+func (dc *decryptingCache) Size() int64 {
+	return dc.header.size
+}
+
+// seekOffset sets the current to the offset relative to the whence within a
+// content of the size, and returns the new current.
+func seekOffset(
+	current *int64,
+	size int64,
+	offset int64,
+	whence int,
+) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += *current
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	*current = offset
+
+	return offset, nil
+}
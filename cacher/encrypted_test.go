@@ -0,0 +1,382 @@
+package cacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/goproxy/goproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCache returns a new `goproxy.Cache` of the name and the content.
+func newTestCache(name string, content []byte) goproxy.Cache {
+	checksum := md5.Sum(content)
+	return &memoryCache{
+		Reader: bytes.NewReader(content),
+		entry: &memoryEntry{
+			name:     name,
+			mimeType: "application/octet-stream",
+			modTime:  time.Now(),
+			checksum: checksum[:],
+			content:  content,
+		},
+	}
+}
+
+// cacheContent returns the content of the cache of the name in the c.
+func cacheContent(c goproxy.Cacher, name string) ([]byte, error) {
+	cache, err := c.Cache(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+	defer cache.Close()
+
+	return ioutil.ReadAll(cache)
+}
+
+func newTestKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestEncrypted(t *testing.T) {
+	m := &Memory{}
+	e := &Encrypted{
+		Cacher:    m,
+		Keys:      map[string]string{"k1": newTestKey(1)},
+		KeyID:     "k1",
+		ChunkSize: 16,
+	}
+
+	for name, content := range map[string][]byte{
+		"empty":    {},
+		"short":    []byte("foo"),
+		"exact":    bytes.Repeat([]byte("0123456789abcdef"), 4),
+		"unevenly": bytes.Repeat([]byte("0123456789"), 10),
+	} {
+		assert.NoError(t, e.SetCache(
+			context.Background(),
+			newTestCache(name, content),
+		), name)
+
+		b, err := cacheContent(e, name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, content, b, name)
+
+		stored, err := cacheContent(m, name)
+		assert.NoError(t, err, name)
+		if len(content) > 0 {
+			assert.False(t, bytes.Contains(stored, content), name)
+		}
+
+		// The stored checksum is the one of the ciphertext.
+		plaintextChecksum := md5.Sum(content)
+		storedChecksum := md5.Sum(stored)
+
+		c, err := e.Cache(context.Background(), name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, int64(len(content)), c.Size(), name)
+		assert.Equal(t, storedChecksum[:], c.Checksum(), name)
+		assert.NotEqual(t, plaintextChecksum[:], c.Checksum(), name)
+		assert.NoError(t, c.Close())
+	}
+
+	_, err := e.Cache(context.Background(), "missing")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	assert.NoError(t, m.SetCache(
+		context.Background(),
+		newTestCache("plaintext", []byte("not encrypted")),
+	))
+	_, err = e.Cache(context.Background(), "plaintext")
+	assert.EqualError(t, err, "invalid encrypted cache header")
+
+	e = &Encrypted{Cacher: m, Keys: map[string]string{"k1": "!"}}
+	assert.Error(t, e.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+
+	e = &Encrypted{
+		Cacher: m,
+		Keys:   map[string]string{"k1": newTestKey(1)},
+		KeyID:  "k2",
+	}
+	assert.EqualError(t, e.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	), `unknown key ID: "k2"`)
+
+}
+
+func TestEncryptedCachesAndDeleteCache(t *testing.T) {
+	m := &Memory{}
+	e := &Encrypted{
+		Cacher: m,
+		Keys:   map[string]string{"k1": newTestKey(1)},
+		KeyID:  "k1",
+	}
+
+	for _, name := range []string{"a/foo", "a/bar", "b/baz"} {
+		assert.NoError(t, e.SetCache(
+			context.Background(),
+			newTestCache(name, []byte(name)),
+		))
+	}
+
+	names, err := e.Caches(context.Background(), "a/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/bar", "a/foo"}, names)
+
+	assert.NoError(t, e.DeleteCache(context.Background(), "a/foo"))
+	assert.Equal(
+		t,
+		goproxy.ErrCacheNotFound,
+		e.DeleteCache(context.Background(), "a/foo"),
+	)
+
+	_, err = e.Cache(context.Background(), "a/foo")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	e.Cacher = &plainCacher{m}
+
+	_, err = e.Caches(context.Background(), "")
+	assert.EqualError(t, err, "cacher cannot list caches")
+
+	assert.EqualError(
+		t,
+		e.DeleteCache(context.Background(), "a/bar"),
+		"cacher cannot delete caches",
+	)
+}
+
+func TestEncryptedSeek(t *testing.T) {
+	e := &Encrypted{
+		Cacher:    &Memory{},
+		Keys:      map[string]string{"k1": newTestKey(1)},
+		KeyID:     "k1",
+		ChunkSize: 16,
+	}
+
+	content := make([]byte, 100)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	assert.NoError(t, e.SetCache(
+		context.Background(),
+		newTestCache("foo", content),
+	))
+
+	c, err := e.Cache(context.Background(), "foo")
+	assert.NoError(t, err)
+	defer c.Close()
+
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		n, err := io.ReadFull(c, b)
+		assert.NoError(t, err)
+		return b[:n]
+	}
+
+	// Within a chunk, across chunks, backward, and relative to the end.
+	for _, tc := range []struct {
+		offset int64
+		whence int
+		pos    int64
+		n      int
+	}{
+		{37, io.SeekStart, 37, 10},
+		{10, io.SeekCurrent, 57, 30},
+		{3, io.SeekStart, 3, 5},
+		{-5, io.SeekEnd, 95, 5},
+		{-50, io.SeekCurrent, 50, 16},
+	} {
+		pos, err := c.Seek(tc.offset, tc.whence)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.pos, pos)
+		assert.Equal(
+			t,
+			content[tc.pos:tc.pos+int64(tc.n)],
+			read(tc.n),
+		)
+	}
+
+	_, err = c.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	n, err := c.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = c.Seek(-1, io.SeekStart)
+	assert.Error(t, err)
+}
+
+func TestEncryptedTampering(t *testing.T) {
+	m := &Memory{}
+	e := &Encrypted{
+		Cacher:    m,
+		Keys:      map[string]string{"k1": newTestKey(1)},
+		KeyID:     "k1",
+		ChunkSize: 16,
+	}
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	assert.NoError(t, e.SetCache(
+		context.Background(),
+		newTestCache("foo", content),
+	))
+
+	stored, err := cacheContent(m, "foo")
+	assert.NoError(t, err)
+
+	headerSize := len(stored) - len(content) - 4*16
+	sealedChunkSize := 16 + 16
+
+	store := func(b []byte) {
+		assert.NoError(t, m.SetCache(
+			context.Background(),
+			newTestCache("foo", b),
+		))
+	}
+
+	// A flipped bit in the third chunk only fails reading it.
+	tampered := append([]byte(nil), stored...)
+	tampered[headerSize+2*sealedChunkSize+1] ^= 1
+	store(tampered)
+
+	c, err := e.Cache(context.Background(), "foo")
+	assert.NoError(t, err)
+	b := make([]byte, 32)
+	_, err = io.ReadFull(c, b)
+	assert.NoError(t, err)
+	assert.Equal(t, content[:32], b)
+	_, err = io.ReadFull(c, b)
+	assert.Error(t, err)
+	c.Close()
+
+	// Swapped chunks are rejected.
+	swapped := append([]byte(nil), stored[:headerSize]...)
+	chunk := func(i int) []byte {
+		offset := headerSize + i*sealedChunkSize
+		return stored[offset : offset+sealedChunkSize]
+	}
+	swapped = append(swapped, chunk(1)...)
+	swapped = append(swapped, chunk(0)...)
+	swapped = append(swapped, chunk(2)...)
+	swapped = append(swapped, chunk(3)...)
+	store(swapped)
+
+	_, err = cacheContent(e, "foo")
+	assert.Error(t, err)
+
+	// A tampered header is rejected by every chunk.
+	tampered = append([]byte(nil), stored...)
+	tampered[headerSize-1] ^= 1
+	store(tampered)
+
+	_, err = cacheContent(e, "foo")
+	assert.Error(t, err)
+
+	// A truncated content is rejected up front.
+	store(stored[:len(stored)-sealedChunkSize])
+	_, err = e.Cache(context.Background(), "foo")
+	assert.Error(t, err)
+
+	// So is one truncated along with the size in its header, since the
+	// header is authenticated by every chunk.
+	truncated := append(
+		[]byte(nil),
+		stored[:headerSize+3*sealedChunkSize]...,
+	)
+	sizeOffset := headerSize - 12 - 8
+	truncated[sizeOffset+7] = 48
+	store(truncated)
+
+	_, err = cacheContent(e, "foo")
+	assert.Error(t, err)
+
+	store(stored[:headerSize-1])
+	_, err = e.Cache(context.Background(), "foo")
+	assert.EqualError(t, err, "invalid encrypted cache header")
+
+	store(stored)
+	b, err = cacheContent(e, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, content, b)
+}
+
+func TestEncryptedRekey(t *testing.T) {
+	m := &Memory{}
+	e1 := &Encrypted{
+		Cacher: m,
+		Keys:   map[string]string{"k1": newTestKey(1)},
+		KeyID:  "k1",
+	}
+
+	for _, name := range []string{"a/foo", "a/bar", "b/baz"} {
+		assert.NoError(t, e1.SetCache(
+			context.Background(),
+			newTestCache(name, []byte(name)),
+		))
+	}
+
+	e2 := &Encrypted{
+		Cacher: m,
+		Keys: map[string]string{
+			"k1": newTestKey(1),
+			"k2": newTestKey(2),
+		},
+		KeyID: "k2",
+	}
+
+	// The caches encrypted with the previous key are still readable.
+	b, err := cacheContent(e2, "a/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "a/foo", string(b))
+
+	rekeyed, err := e2.Rekey(context.Background(), "a/")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rekeyed)
+
+	rekeyed, err = e2.Rekey(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rekeyed)
+
+	rekeyed, err = e2.Rekey(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rekeyed)
+
+	// The previous key is no longer needed.
+	e3 := &Encrypted{
+		Cacher: m,
+		Keys:   map[string]string{"k2": newTestKey(2)},
+		KeyID:  "k2",
+	}
+	for _, name := range []string{"a/foo", "a/bar", "b/baz"} {
+		b, err := cacheContent(e3, name)
+		assert.NoError(t, err)
+		assert.Equal(t, name, string(b))
+	}
+
+	_, err = cacheContent(e1, "a/foo")
+	assert.EqualError(t, err, `unknown key ID: "k2"`)
+
+	e4 := &Encrypted{
+		Cacher: &brokenLister{m},
+		Keys:   map[string]string{"k2": newTestKey(2)},
+		KeyID:  "k2",
+	}
+	_, err = e4.Rekey(context.Background(), "")
+	assert.EqualError(t, err, "cacher cannot list caches")
+}
+
+// brokenLister hides the `goproxy.CacheLister` of a `goproxy.Cacher`.
+type brokenLister struct {
+	goproxy.Cacher
+}
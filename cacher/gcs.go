@@ -4,6 +4,7 @@ import (
 	"context"
 	"hash"
 	"sync"
+	"time"
 
 	"github.com/goproxy/goproxy"
 )
@@ -28,6 +29,9 @@ type GCS struct {
 	// Root is the root of the caches.
 	Root string `mapstructure:"root"`
 
+	// MinIOOptions is the advanced options.
+	MinIOOptions `mapstructure:",squash"`
+
 	loadOnce sync.Once
 	minio    *MinIO
 }
@@ -45,6 +49,7 @@ func (g *GCS) load() {
 		SecretAccessKey: g.SecretKey,
 		BucketName:      g.BucketName,
 		Root:            g.Root,
+		MinIOOptions:    g.MinIOOptions,
 		virtualHosted:   true,
 	}
 }
@@ -65,3 +70,25 @@ func (g *GCS) SetCache(ctx context.Context, c goproxy.Cache) error {
 	g.loadOnce.Do(g.load)
 	return g.minio.SetCache(ctx, c)
 }
+
+// Caches implements the `goproxy.CacheLister`.
+func (g *GCS) Caches(ctx context.Context, prefix string) ([]string, error) {
+	g.loadOnce.Do(g.load)
+	return g.minio.Caches(ctx, prefix)
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (g *GCS) DeleteCache(ctx context.Context, name string) error {
+	g.loadOnce.Do(g.load)
+	return g.minio.DeleteCache(ctx, name)
+}
+
+// PresignCache implements the `goproxy.CachePresigner`.
+func (g *GCS) PresignCache(
+	ctx context.Context,
+	name string,
+	expiry time.Duration,
+) (string, error) {
+	g.loadOnce.Do(g.load)
+	return g.minio.PresignCache(ctx, name, expiry)
+}
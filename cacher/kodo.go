@@ -4,6 +4,7 @@ import (
 	"context"
 	"hash"
 	"sync"
+	"time"
 
 	"github.com/goproxy/goproxy"
 )
@@ -28,6 +29,9 @@ type Kodo struct {
 	// Root is the root of the caches.
 	Root string `mapstructure:"root"`
 
+	// MinIOOptions is the advanced options.
+	MinIOOptions `mapstructure:",squash"`
+
 	loadOnce sync.Once
 	minio    *MinIO
 }
@@ -45,6 +49,7 @@ func (k *Kodo) load() {
 		SecretAccessKey: k.SecretKey,
 		BucketName:      k.BucketName,
 		Root:            k.Root,
+		MinIOOptions:    k.MinIOOptions,
 		virtualHosted:   true,
 	}
 }
@@ -65,3 +70,25 @@ func (k *Kodo) SetCache(ctx context.Context, c goproxy.Cache) error {
 	k.loadOnce.Do(k.load)
 	return k.minio.SetCache(ctx, c)
 }
+
+// Caches implements the `goproxy.CacheLister`.
+func (k *Kodo) Caches(ctx context.Context, prefix string) ([]string, error) {
+	k.loadOnce.Do(k.load)
+	return k.minio.Caches(ctx, prefix)
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (k *Kodo) DeleteCache(ctx context.Context, name string) error {
+	k.loadOnce.Do(k.load)
+	return k.minio.DeleteCache(ctx, name)
+}
+
+// PresignCache implements the `goproxy.CachePresigner`.
+func (k *Kodo) PresignCache(
+	ctx context.Context,
+	name string,
+	expiry time.Duration,
+) (string, error) {
+	k.loadOnce.Do(k.load)
+	return k.minio.PresignCache(ctx, name, expiry)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"hash"
 	"sync"
+	"time"
 
 	"github.com/goproxy/goproxy"
 )
@@ -29,6 +30,9 @@ type MABS struct {
 	// Root is the root of the caches.
 	Root string `mapstructure:"root"`
 
+	// MinIOOptions is the advanced options.
+	MinIOOptions `mapstructure:",squash"`
+
 	loadOnce sync.Once
 	minio    *MinIO
 }
@@ -46,6 +50,7 @@ func (m *MABS) load() {
 		SecretAccessKey: m.AccountKey,
 		BucketName:      m.ContainerName,
 		Root:            m.Root,
+		MinIOOptions:    m.MinIOOptions,
 	}
 }
 
@@ -65,3 +70,25 @@ func (m *MABS) SetCache(ctx context.Context, c goproxy.Cache) error {
 	m.loadOnce.Do(m.load)
 	return m.minio.SetCache(ctx, c)
 }
+
+// Caches implements the `goproxy.CacheLister`.
+func (m *MABS) Caches(ctx context.Context, prefix string) ([]string, error) {
+	m.loadOnce.Do(m.load)
+	return m.minio.Caches(ctx, prefix)
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (m *MABS) DeleteCache(ctx context.Context, name string) error {
+	m.loadOnce.Do(m.load)
+	return m.minio.DeleteCache(ctx, name)
+}
+
+// PresignCache implements the `goproxy.CachePresigner`.
+func (m *MABS) PresignCache(
+	ctx context.Context,
+	name string,
+	expiry time.Duration,
+) (string, error) {
+	m.loadOnce.Do(m.load)
+	return m.minio.PresignCache(ctx, name, expiry)
+}
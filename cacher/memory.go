@@ -0,0 +1,276 @@
+package cacher
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/md5"
+	"hash"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goproxy/goproxy"
+)
+
+// Memory implements the `goproxy.Cacher` by using the memory. It is a
+// thread-safe LRU, the least recently used caches are evicted to stay within
+// the `MaxBytes`.
+//
+// It is most useful as the first tier of a `Tiered` that only accepts small
+// caches, such as the ".info" and the ".mod".
+type Memory struct {
+	// MaxBytes is the maximum total size in bytes of the caches.
+	//
+	// If the `MaxBytes` is zero, 64 MiB is used.
+	MaxBytes int64 `mapstructure:"max_bytes"`
+
+	// MaxCacheBytes is the maximum size in bytes of a cache. Larger caches
+	// are silently not stored, and the previous caches of their names are
+	// removed.
+	//
+	// If the `MaxCacheBytes` is zero, then there will be no limitations
+	// other than the `MaxBytes`.
+	MaxCacheBytes int64 `mapstructure:"max_cache_bytes"`
+
+	mutex     sync.Mutex
+	lru       *list.List
+	elements  map[string]*list.Element
+	bytes     int64
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// MemoryStats is the stats of a `Memory`.
+type MemoryStats struct {
+	// Caches is the number of the caches.
+	Caches int
+
+	// Bytes is the total size in bytes of the caches.
+	Bytes int64
+
+	// Hits is the number of the `Memory.Cache` calls that found a cache.
+	Hits uint64
+
+	// Misses is the number of the `Memory.Cache` calls that did not find
+	// a cache.
+	Misses uint64
+
+	// Evictions is the number of the caches evicted to stay within the
+	// `Memory.MaxBytes`.
+	Evictions uint64
+}
+
+// memoryEntry is an entry of a `Memory`. It is immutable once stored.
+type memoryEntry struct {
+	name     string
+	mimeType string
+	modTime  time.Time
+	checksum []byte
+	content  []byte
+}
+
+// maxBytes returns the maximum total size in bytes of the caches of the m.
+func (m *Memory) maxBytes() int64 {
+	if m.MaxBytes == 0 {
+		return 64 << 20
+	}
+
+	return m.MaxBytes
+}
+
+// fits reports whether a cache of the size fits in the m.
+func (m *Memory) fits(size int64) bool {
+	if m.MaxCacheBytes > 0 && size > m.MaxCacheBytes {
+		return false
+	}
+
+	return size <= m.maxBytes()
+}
+
+// NewHash implements the `goproxy.Cacher`.
+func (m *Memory) NewHash() hash.Hash {
+	return md5.New()
+}
+
+// Cache implements the `goproxy.Cacher`.
+func (m *Memory) Cache(
+	ctx context.Context,
+	name string,
+) (goproxy.Cache, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.elements[name]
+	if !ok {
+		m.misses++
+		return nil, goproxy.ErrCacheNotFound
+	}
+
+	m.hits++
+	m.lru.MoveToFront(e)
+
+	me := e.Value.(*memoryEntry)
+
+	return &memoryCache{
+		Reader: bytes.NewReader(me.content),
+		entry:  me,
+	}, nil
+}
+
+// SetCache implements the `goproxy.Cacher`.
+func (m *Memory) SetCache(ctx context.Context, c goproxy.Cache) error {
+	if !m.fits(c.Size()) {
+		m.drop(c.Name())
+		return nil
+	}
+
+	content, err := ioutil.ReadAll(c)
+	if err != nil {
+		return err
+	} else if !m.fits(int64(len(content))) {
+		m.drop(c.Name())
+		return nil
+	}
+
+	modTime := c.ModTime()
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	me := &memoryEntry{
+		name:     c.Name(),
+		mimeType: c.MIMEType(),
+		modTime:  modTime,
+		checksum: append([]byte(nil), c.Checksum()...),
+		content:  content,
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.elements == nil {
+		m.lru = list.New()
+		m.elements = map[string]*list.Element{}
+	}
+
+	if e, ok := m.elements[me.name]; ok {
+		m.remove(e)
+	}
+
+	for m.bytes+int64(len(me.content)) > m.maxBytes() {
+		m.remove(m.lru.Back())
+		m.evictions++
+	}
+
+	m.elements[me.name] = m.lru.PushFront(me)
+	m.bytes += int64(len(me.content))
+
+	return nil
+}
+
+// drop removes the cache of the name from the m if any, so that a cache that
+// is not stored does not leave its stale content behind.
+func (m *Memory) drop(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if e, ok := m.elements[name]; ok {
+		m.remove(e)
+	}
+}
+
+// remove removes the e from the m.
+func (m *Memory) remove(e *list.Element) {
+	me := m.lru.Remove(e).(*memoryEntry)
+	delete(m.elements, me.name)
+	m.bytes -= int64(len(me.content))
+}
+
+// Caches implements the `goproxy.CacheLister`.
+func (m *Memory) Caches(ctx context.Context, prefix string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	names := []string{}
+	for name := range m.elements {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (m *Memory) DeleteCache(ctx context.Context, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.elements[name]
+	if !ok {
+		return goproxy.ErrCacheNotFound
+	}
+
+	m.remove(e)
+
+	return nil
+}
+
+// Stats returns the stats of the m.
+func (m *Memory) Stats() MemoryStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return MemoryStats{
+		Caches:    len(m.elements),
+		Bytes:     m.bytes,
+		Hits:      m.hits,
+		Misses:    m.misses,
+		Evictions: m.evictions,
+	}
+}
+
+// memoryCache implements the `goproxy.Cache`. It is the cache unit of the
+// `Memory` and the `Bolt`. Each of them has its own reader over the shared
+// content of its entry.
+type memoryCache struct {
+	*bytes.Reader
+
+	entry *memoryEntry
+}
+
+// Close implements the `goproxy.Cache`.
+func (mc *memoryCache) Close() error {
+	return nil
+}
+
+// Name implements the `goproxy.Cache`.
+func (mc *memoryCache) Name() string {
+	return mc.entry.name
+}
+
+// MIMEType implements the `goproxy.Cache`.
+func (mc *memoryCache) MIMEType() string {
+	return mc.entry.mimeType
+}
+
+// Size implements the `goproxy.Cache`.
+func (mc *memoryCache) Size() int64 {
+	return int64(len(mc.entry.content))
+}
+
+// ModTime implements the `goproxy.Cache`.
+func (mc *memoryCache) ModTime() time.Time {
+	return mc.entry.modTime
+}
+
+// Checksum implements the `goproxy.Cache`.
+func (mc *memoryCache) Checksum() []byte {
+	return mc.entry.checksum
+}
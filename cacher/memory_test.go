@@ -0,0 +1,257 @@
+package cacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/goproxy/goproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	m := &Memory{}
+
+	content := []byte("foobar")
+	assert.NoError(t, m.SetCache(
+		context.Background(),
+		newTestCache("foo", content),
+	))
+
+	c, err := m.Cache(context.Background(), "foo")
+	assert.NoError(t, err)
+	checksum := md5.Sum(content)
+	assert.Equal(t, "foo", c.Name())
+	assert.Equal(t, "application/octet-stream", c.MIMEType())
+	assert.Equal(t, int64(len(content)), c.Size())
+	assert.Equal(t, checksum[:], c.Checksum())
+	assert.False(t, c.ModTime().IsZero())
+
+	// Each cache has its own reader over the shared content.
+	c2, err := m.Cache(context.Background(), "foo")
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, content, b)
+	assert.NoError(t, c.Close())
+
+	b, err = ioutil.ReadAll(c2)
+	assert.NoError(t, err)
+	assert.Equal(t, content, b)
+	assert.NoError(t, c2.Close())
+
+	// An overwrite replaces the content and the size.
+	assert.NoError(t, m.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+
+	b, err = cacheContent(m, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), b)
+
+	_, err = m.Cache(context.Background(), "bar")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	assert.Equal(t, MemoryStats{
+		Caches: 1,
+		Bytes:  3,
+		Hits:   3,
+		Misses: 1,
+	}, m.Stats())
+}
+
+func TestMemoryEviction(t *testing.T) {
+	m := &Memory{MaxBytes: 10}
+
+	for _, name := range []string{"a", "b", "c"} {
+		assert.NoError(t, m.SetCache(
+			context.Background(),
+			newTestCache(name, bytes.Repeat([]byte(name), 3)),
+		))
+	}
+
+	// The "a" becomes the most recently used, so the "b" is evicted.
+	_, err := cacheContent(m, "a")
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.SetCache(
+		context.Background(),
+		newTestCache("d", []byte("ddd")),
+	))
+
+	_, err = m.Cache(context.Background(), "b")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	for _, name := range []string{"a", "c", "d"} {
+		b, err := cacheContent(m, name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, bytes.Repeat([]byte(name), 3), b, name)
+	}
+
+	// Several caches are evicted to make room for a larger one.
+	assert.NoError(t, m.SetCache(
+		context.Background(),
+		newTestCache("e", []byte("eeeeeeee")),
+	))
+
+	names, err := m.Caches(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e"}, names)
+
+	stats := m.Stats()
+	assert.Equal(t, 1, stats.Caches)
+	assert.Equal(t, int64(8), stats.Bytes)
+	assert.Equal(t, uint64(4), stats.Evictions)
+}
+
+func TestMemoryOversized(t *testing.T) {
+	m := &Memory{MaxBytes: 10, MaxCacheBytes: 5}
+
+	assert.NoError(t, m.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+	assert.NoError(t, m.SetCache(
+		context.Background(),
+		newTestCache("bar", []byte("bar")),
+	))
+
+	// The caches larger than the `MaxCacheBytes` or the `MaxBytes` are
+	// not stored, and evict nothing.
+	for _, content := range [][]byte{
+		[]byte("bazbaz"),
+		bytes.Repeat([]byte("baz"), 4),
+	} {
+		assert.NoError(t, m.SetCache(
+			context.Background(),
+			newTestCache("baz", content),
+		))
+
+		_, err := m.Cache(context.Background(), "baz")
+		assert.Equal(t, goproxy.ErrCacheNotFound, err)
+	}
+
+	// An oversized overwrite removes the previous content instead of
+	// leaving it stale.
+	assert.NoError(t, m.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foofoo")),
+	))
+
+	_, err := m.Cache(context.Background(), "foo")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	stats := m.Stats()
+	assert.Equal(t, 1, stats.Caches)
+	assert.Equal(t, int64(3), stats.Bytes)
+	assert.Equal(t, uint64(0), stats.Evictions)
+
+	// The default `MaxBytes` is 64 MiB.
+	m = &Memory{}
+	assert.Equal(t, int64(64<<20), m.maxBytes())
+	assert.True(t, m.fits(64<<20))
+	assert.False(t, m.fits(64<<20+1))
+}
+
+func TestMemoryCaches(t *testing.T) {
+	m := &Memory{}
+
+	names := []string{
+		"example.com/bar/@v/list",
+		"example.com/foo/@v/v1.0.0.info",
+		"example.com/foo/@v/v1.0.0.mod",
+		"example.com/foobar/@v/v1.0.0.info",
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		assert.NoError(t, m.SetCache(
+			context.Background(),
+			newTestCache(names[i], []byte(names[i])),
+		))
+	}
+
+	got, err := m.Caches(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, names, got)
+
+	got, err = m.Caches(context.Background(), "example.com/foo/")
+	assert.NoError(t, err)
+	assert.Equal(t, names[1:3], got)
+
+	got, err = m.Caches(context.Background(), "example.com/baz/")
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+
+	assert.NoError(t, m.DeleteCache(context.Background(), names[1]))
+	assert.Equal(
+		t,
+		goproxy.ErrCacheNotFound,
+		m.DeleteCache(context.Background(), names[1]),
+	)
+
+	_, err = m.Cache(context.Background(), names[1])
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	got, err = m.Caches(context.Background(), "example.com/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{names[2], names[3]}, got)
+
+	stats := m.Stats()
+	assert.Equal(t, 3, stats.Caches)
+	assert.Equal(
+		t,
+		int64(len(names[0])+len(names[2])+len(names[3])),
+		stats.Bytes,
+	)
+
+	// The deleted caches are not counted as evictions.
+	assert.Equal(t, uint64(0), stats.Evictions)
+
+	assert.Equal(
+		t,
+		goproxy.ErrCacheNotFound,
+		(&Memory{}).DeleteCache(context.Background(), "foo"),
+	)
+}
+
+func TestMemoryConcurrency(t *testing.T) {
+	m := &Memory{MaxBytes: 64}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprint("cache", (i+j)%16)
+				assert.NoError(t, m.SetCache(
+					context.Background(),
+					newTestCache(name, []byte(name)),
+				))
+
+				b, err := cacheContent(m, name)
+				if err == nil {
+					assert.Equal(t, []byte(name), b)
+				} else {
+					assert.Equal(
+						t,
+						goproxy.ErrCacheNotFound,
+						err,
+					)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	stats := m.Stats()
+	assert.True(t, stats.Bytes <= 64)
+	assert.Equal(t, uint64(800), stats.Hits+stats.Misses)
+}
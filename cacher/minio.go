@@ -3,11 +3,19 @@ package cacher
 import (
 	"context"
 	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
+	"fmt"
 	"hash"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +23,7 @@ import (
 	"github.com/goproxy/goproxy"
 	"github.com/minio/minio-go/v6"
 	"github.com/minio/minio-go/v6/pkg/credentials"
+	"github.com/minio/minio-go/v6/pkg/encrypt"
 	"github.com/minio/minio-go/v6/pkg/s3utils"
 )
 
@@ -35,12 +44,94 @@ type MinIO struct {
 	// Root is the root of the caches.
 	Root string `mapstructure:"root"`
 
+	// MinIOOptions is the advanced options.
+	MinIOOptions `mapstructure:",squash"`
+
 	loadOnce      sync.Once
 	loadError     error
 	client        *minio.Client
+	sse           encrypt.ServerSide
 	virtualHosted bool
 }
 
+// MinIOOptions is the advanced options of the `MinIO` and the cachers based on
+// it, such as the `S3`.
+type MinIOOptions struct {
+	// Region is the region of the bucket.
+	//
+	// If the `Region` is empty, it is looked up from the bucket.
+	Region string `mapstructure:"region"`
+
+	// BucketLookup is the style of the bucket lookup, either "path",
+	// "dns" (virtual-hosted), or "auto".
+	//
+	// If the `BucketLookup` is empty, the default style of the cacher is
+	// used.
+	BucketLookup string `mapstructure:"bucket_lookup"`
+
+	// StorageClass is the storage class of the caches, such as the
+	// "STANDARD_IA".
+	//
+	// If the `StorageClass` is empty, the default storage class of the
+	// bucket is used.
+	StorageClass string `mapstructure:"storage_class"`
+
+	// ServerSideEncryption is the server-side encryption of the caches,
+	// either "SSE-S3", "SSE-KMS", or "SSE-C".
+	//
+	// If the `ServerSideEncryption` is empty, the default encryption of
+	// the bucket is used.
+	ServerSideEncryption string `mapstructure:"server_side_encryption"`
+
+	// SSEKMSKeyID is the ID of the KMS key of the "SSE-KMS".
+	//
+	// If the `SSEKMSKeyID` is empty, the default KMS key is used.
+	SSEKMSKeyID string `mapstructure:"sse_kms_key_id"`
+
+	// SSECustomerKey is the base64-encoded 256-bit key of the "SSE-C".
+	// Note that the caches encrypted with it cannot be downloaded through
+	// presigned URLs.
+	SSECustomerKey string `mapstructure:"sse_customer_key"`
+
+	// Tags is the tags of the caches.
+	Tags map[string]string `mapstructure:"tags"`
+
+	// CAFile is the name of a PEM file of the certificate authorities
+	// trusted in addition to the ones of the system.
+	CAFile string `mapstructure:"ca_file"`
+
+	// PartSize is the size in bytes of the parts of multipart uploads.
+	//
+	// If the `PartSize` is zero, it is chosen based on the size of each
+	// cache.
+	PartSize uint64 `mapstructure:"part_size"`
+
+	// Concurrency is the maximum number of the parts of a multipart
+	// upload that are uploaded at the same time.
+	//
+	// If the `Concurrency` is zero, 4 is used.
+	Concurrency uint `mapstructure:"concurrency"`
+
+	// CredentialProviders is the names of the providers tried in order to
+	// retrieve the credentials, until one of them succeeds. The "static"
+	// uses the access key ID and the secret access key of the cacher. The
+	// "env" uses the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and
+	// AWS_SESSION_TOKEN (or MINIO_ACCESS_KEY and MINIO_SECRET_KEY)
+	// environment variables. The "file" uses the shared credentials and
+	// config files of the AWS CLI with the profile of the AWS_PROFILE
+	// environment variable. The "iam" uses the web identity token file of
+	// the AWS_WEB_IDENTITY_TOKEN_FILE environment variable (IAM roles for
+	// service accounts) if it is set, or else the ECS container
+	// credentials or the EC2 instance metadata. The temporary credentials
+	// are refreshed automatically before they expire. If none of them
+	// succeeds, the requests are sent anonymously, and the providers are
+	// tried again on the next request.
+	//
+	// If the `CredentialProviders` is empty, only the "static" is used,
+	// which sends the requests anonymously if the keys are empty.
+	CredentialProviders []string `mapstructure:"credential_providers"`
+}
+
 // load loads the stuff of the m up.
 func (m *MinIO) load() {
 	var u *url.URL
@@ -53,27 +144,199 @@ func (m *MinIO) load() {
 		signerType = credentials.SignatureV2
 	}
 
+	var providers []credentials.Provider
+	if providers, m.loadError = m.credentialProviders(
+		signerType,
+	); m.loadError != nil {
+		return
+	}
+
 	options := &minio.Options{
-		Creds: credentials.NewStatic(
-			m.AccessKeyID,
-			m.SecretAccessKey,
-			"",
-			signerType,
-		),
+		Creds:        credentials.NewChainCredentials(providers),
 		Secure:       strings.ToLower(u.Scheme) == "https",
+		Region:       m.Region,
 		BucketLookup: minio.BucketLookupPath,
 	}
-	if m.virtualHosted {
+	switch m.BucketLookup {
+	case "":
+		if m.virtualHosted {
+			options.BucketLookup = minio.BucketLookupDNS
+		}
+	case "path":
+	case "dns":
 		options.BucketLookup = minio.BucketLookupDNS
+	case "auto":
+		options.BucketLookup = minio.BucketLookupAuto
+	default:
+		m.loadError = fmt.Errorf(
+			"unsupported bucket lookup: %q",
+			m.BucketLookup,
+		)
+		return
+	}
+
+	if m.sse, m.loadError = m.serverSideEncryption(); m.loadError != nil {
+		return
 	}
 
 	u.Scheme = ""
-	m.client, m.loadError = minio.NewWithOptions(
+	if m.client, m.loadError = minio.NewWithOptions(
 		strings.TrimPrefix(u.String(), "//"),
 		options,
+	); m.loadError != nil {
+		return
+	}
+
+	if m.CAFile != "" {
+		var transport http.RoundTripper
+		if transport, m.loadError = newCATransport(
+			m.CAFile,
+		); m.loadError != nil {
+			return
+		}
+
+		m.client.SetCustomTransport(transport)
+	}
+}
+
+// credentialProviders returns the `credentials.Provider`s of the
+// `CredentialProviders` of the m. The signerType is used for the static keys.
+func (m *MinIO) credentialProviders(
+	signerType credentials.SignatureType,
+) ([]credentials.Provider, error) {
+	names := m.CredentialProviders
+	if len(names) == 0 {
+		names = []string{"static"}
+	}
+
+	providers := make([]credentials.Provider, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "static":
+			providers = append(providers, &credentials.Static{
+				Value: credentials.Value{
+					AccessKeyID:     m.AccessKeyID,
+					SecretAccessKey: m.SecretAccessKey,
+					SignerType:      signerType,
+				},
+			})
+		case "env":
+			providers = append(
+				providers,
+				&credentials.EnvAWS{},
+				&credentials.EnvMinio{},
+			)
+		case "file":
+			providers = append(
+				providers,
+				&credentials.FileAWSCredentials{},
+				sharedConfigCredentials(),
+			)
+		case "iam":
+			client := &http.Client{Timeout: 10 * time.Second}
+
+			// The `credentials.IAM` handles the web identity
+			// token file too, but without tracking the expiry
+			// of the credentials.
+			if wic := newWebIdentityCredentials(
+				client,
+			); wic != nil {
+				providers = append(providers, wic)
+			} else {
+				providers = append(providers, &credentials.IAM{
+					Client: client,
+				})
+			}
+		default:
+			return nil, fmt.Errorf(
+				"unsupported credential provider: %q",
+				name,
+			)
+		}
+	}
+
+	return providers, nil
+}
+
+// sharedConfigCredentials returns a `credentials.Provider` that retrieves the
+// credentials from the shared config file of the AWS CLI, which is located by
+// the AWS_CONFIG_FILE environment variable or defaults to "~/.aws/config".
+func sharedConfigCredentials() credentials.Provider {
+	filename := os.Getenv("AWS_CONFIG_FILE")
+	if filename == "" {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			filename = filepath.Join(homeDir, ".aws", "config")
+		}
+	}
+
+	// Unlike the shared credentials file, the shared config file prefixes
+	// the sections of the non-default profiles with "profile ".
+	profile := "default"
+	if p := os.Getenv("AWS_PROFILE"); p != "" && p != "default" {
+		profile = "profile " + p
+	}
+
+	return &credentials.FileAWSCredentials{
+		Filename: filename,
+		Profile:  profile,
+	}
+}
+
+// serverSideEncryption returns the `encrypt.ServerSide` of the
+// `ServerSideEncryption` of the m.
+func (m *MinIO) serverSideEncryption() (encrypt.ServerSide, error) {
+	switch strings.ToUpper(m.ServerSideEncryption) {
+	case "":
+		return nil, nil
+	case "SSE-S3":
+		return encrypt.NewSSE(), nil
+	case "SSE-KMS":
+		return encrypt.NewSSEKMS(m.SSEKMSKeyID, nil)
+	case "SSE-C":
+		key, err := base64.StdEncoding.DecodeString(m.SSECustomerKey)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid SSE-C customer key: %v",
+				err,
+			)
+		}
+
+		return encrypt.NewSSEC(key)
+	}
+
+	return nil, fmt.Errorf(
+		"unsupported server-side encryption: %q",
+		m.ServerSideEncryption,
 	)
 }
 
+// newCATransport returns a new `http.RoundTripper` that trusts the certificate
+// authorities of the PEM file of the caFilename in addition to the ones of the
+// system.
+func newCATransport(caFilename string) (http.RoundTripper, error) {
+	b, err := ioutil.ReadFile(caFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+
+	if !rootCAs.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf(
+			"no certificates found in %q",
+			caFilename,
+		)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+
+	return transport, nil
+}
+
 // NewHash implements the `goproxy.Cacher`.
 func (m *MinIO) NewHash() hash.Hash {
 	return md5.New()
@@ -89,7 +352,7 @@ func (m *MinIO) Cache(ctx context.Context, name string) (goproxy.Cache, error) {
 		ctx,
 		m.BucketName,
 		path.Join(m.Root, name),
-		minio.GetObjectOptions{},
+		minio.GetObjectOptions{ServerSideEncryption: m.sse},
 	)
 	if err != nil {
 		if isMinIOObjectNotExist(err) {
@@ -143,13 +406,105 @@ func (m *MinIO) SetCache(ctx context.Context, c goproxy.Cache) error {
 			UserMetadata: map[string]string{
 				"Checksum": hex.EncodeToString(c.Checksum()),
 			},
-			ContentType: c.MIMEType(),
+			UserTags:             m.Tags,
+			ContentType:          c.MIMEType(),
+			ServerSideEncryption: m.sse,
+			NumThreads:           m.Concurrency,
+			StorageClass:         m.StorageClass,
+			PartSize:             m.PartSize,
 		},
 	)
 
 	return err
 }
 
+// Caches implements the `goproxy.CacheLister`.
+func (m *MinIO) Caches(ctx context.Context, prefix string) ([]string, error) {
+	if m.loadOnce.Do(m.load); m.loadError != nil {
+		return nil, m.loadError
+	}
+
+	root := m.Root
+	if root != "" {
+		root = fmt.Sprint(path.Clean(root), "/")
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	var names []string
+	for objectInfo := range m.client.ListObjectsV2(
+		m.BucketName,
+		fmt.Sprint(root, prefix),
+		true,
+		doneCh,
+	) {
+		if objectInfo.Err != nil {
+			return nil, objectInfo.Err
+		}
+
+		names = append(names, strings.TrimPrefix(objectInfo.Key, root))
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (m *MinIO) DeleteCache(ctx context.Context, name string) error {
+	if m.loadOnce.Do(m.load); m.loadError != nil {
+		return m.loadError
+	}
+
+	objectName := path.Join(m.Root, name)
+	if _, err := m.client.StatObject(
+		m.BucketName,
+		objectName,
+		minio.StatObjectOptions{
+			GetObjectOptions: minio.GetObjectOptions{
+				ServerSideEncryption: m.sse,
+			},
+		},
+	); err != nil {
+		if isMinIOObjectNotExist(err) {
+			return goproxy.ErrCacheNotFound
+		}
+
+		return err
+	}
+
+	return m.client.RemoveObject(m.BucketName, objectName)
+}
+
+// PresignCache implements the `goproxy.CachePresigner`.
+func (m *MinIO) PresignCache(
+	ctx context.Context,
+	name string,
+	expiry time.Duration,
+) (string, error) {
+	if m.loadOnce.Do(m.load); m.loadError != nil {
+		return "", m.loadError
+	}
+
+	// The SSE-C requires the key to be sent along with the request.
+	if m.sse != nil && m.sse.Type() == encrypt.SSEC {
+		return "", nil
+	}
+
+	u, err := m.client.PresignedGetObject(
+		m.BucketName,
+		path.Join(m.Root, name),
+		expiry,
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
 // isMinIOObjectNotExist reports whether the err means that the MinIO object
 // does not exist.
 func isMinIOObjectNotExist(err error) bool {
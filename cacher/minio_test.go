@@ -0,0 +1,288 @@
+package cacher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v6/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+// setenvs sets the environment variables of the envs, in which an empty value
+// unsets the variable, and returns a function that restores them.
+func setenvs(envs map[string]string) func() {
+	olds := map[string]*string{}
+	for key, value := range envs {
+		if old, ok := os.LookupEnv(key); ok {
+			olds[key] = &old
+		} else {
+			olds[key] = nil
+		}
+
+		if value == "" {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, value)
+		}
+	}
+
+	return func() {
+		for key, old := range olds {
+			if old == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *old)
+			}
+		}
+	}
+}
+
+// credentialEnvs is the environment variables that affect the credential
+// providers, all unset.
+var credentialEnvs = map[string]string{
+	"AWS_ACCESS_KEY_ID":           "",
+	"AWS_ACCESS_KEY":              "",
+	"AWS_SECRET_ACCESS_KEY":       "",
+	"AWS_SECRET_KEY":              "",
+	"AWS_SESSION_TOKEN":           "",
+	"MINIO_ACCESS_KEY":            "",
+	"MINIO_SECRET_KEY":            "",
+	"AWS_SHARED_CREDENTIALS_FILE": "",
+	"AWS_CONFIG_FILE":             "",
+	"AWS_PROFILE":                 "",
+	"AWS_WEB_IDENTITY_TOKEN_FILE": "",
+	"AWS_ROLE_ARN":                "",
+	"AWS_ROLE_SESSION_NAME":       "",
+	"AWS_REGION":                  "",
+}
+
+func minIOCredentials(m *MinIO) (credentials.Value, error) {
+	providers, err := m.credentialProviders(credentials.SignatureV2)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	return credentials.NewChainCredentials(providers).Get()
+}
+
+func TestMinIOCredentialProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-cacher")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer setenvs(credentialEnvs)()
+	defer setenvs(map[string]string{"HOME": dir})()
+
+	m := &MinIO{AccessKeyID: "static-id", SecretAccessKey: "static-secret"}
+	providers, err := m.credentialProviders(credentials.SignatureV2)
+	assert.NoError(t, err)
+	assert.Len(t, providers, 1)
+
+	v, err := minIOCredentials(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "static-id", v.AccessKeyID)
+	assert.Equal(t, credentials.SignatureV2, v.SignerType)
+
+	// The default sends the requests anonymously without probing the
+	// environment.
+	restore := setenvs(map[string]string{
+		"AWS_ACCESS_KEY_ID":     "env-id",
+		"AWS_SECRET_ACCESS_KEY": "env-secret",
+	})
+	v, err = minIOCredentials(&MinIO{})
+	assert.NoError(t, err)
+	assert.True(t, v.SignerType.IsAnonymous())
+
+	all := MinIOOptions{
+		CredentialProviders: []string{"static", "env", "file", "iam"},
+	}
+
+	v, err = minIOCredentials(&MinIO{
+		AccessKeyID:     "static-id",
+		SecretAccessKey: "static-secret",
+		MinIOOptions:    all,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "static-id", v.AccessKeyID)
+
+	v, err = minIOCredentials(&MinIO{MinIOOptions: all})
+	assert.NoError(t, err)
+	assert.Equal(t, "env-id", v.AccessKeyID)
+	assert.Equal(t, credentials.SignatureV4, v.SignerType)
+	restore()
+
+	awsDir := filepath.Join(dir, ".aws")
+	assert.NoError(t, os.Mkdir(awsDir, 0700))
+	assert.NoError(t, ioutil.WriteFile(
+		filepath.Join(awsDir, "config"),
+		[]byte("[profile foo]\n"+
+			"aws_access_key_id = config-id\n"+
+			"aws_secret_access_key = config-secret\n"),
+		0600,
+	))
+
+	restore = setenvs(map[string]string{"AWS_PROFILE": "foo"})
+	v, err = minIOCredentials(&MinIO{
+		MinIOOptions: MinIOOptions{
+			CredentialProviders: []string{"file"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "config-id", v.AccessKeyID)
+
+	assert.NoError(t, ioutil.WriteFile(
+		filepath.Join(awsDir, "credentials"),
+		[]byte("[foo]\n"+
+			"aws_access_key_id = credentials-id\n"+
+			"aws_secret_access_key = credentials-secret\n"),
+		0600,
+	))
+
+	v, err = minIOCredentials(&MinIO{
+		MinIOOptions: MinIOOptions{
+			CredentialProviders: []string{"file"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "credentials-id", v.AccessKeyID)
+	restore()
+
+	restore = setenvs(map[string]string{
+		"AWS_WEB_IDENTITY_TOKEN_FILE": filepath.Join(dir, "token"),
+	})
+	providers, err = (&MinIO{
+		MinIOOptions: MinIOOptions{
+			CredentialProviders: []string{"iam"},
+		},
+	}).credentialProviders(credentials.SignatureV2)
+	assert.NoError(t, err)
+	if assert.Len(t, providers, 1) {
+		assert.IsType(t, &webIdentityCredentials{}, providers[0])
+	}
+	restore()
+
+	providers, err = (&MinIO{
+		MinIOOptions: MinIOOptions{
+			CredentialProviders: []string{"iam"},
+		},
+	}).credentialProviders(credentials.SignatureV2)
+	assert.NoError(t, err)
+	if assert.Len(t, providers, 1) {
+		assert.IsType(t, &credentials.IAM{}, providers[0])
+	}
+
+	_, err = minIOCredentials(&MinIO{
+		MinIOOptions: MinIOOptions{
+			CredentialProviders: []string{"foobar"},
+		},
+	})
+	assert.EqualError(t, err, `unsupported credential provider: "foobar"`)
+}
+
+func TestWebIdentityCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-cacher")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tokenFilename := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(
+		tokenFilename,
+		[]byte("token\n"),
+		0600,
+	))
+
+	var (
+		requests   int32
+		expiration atomic.Value
+	)
+	expiration.Store(time.Now().Add(time.Hour))
+	sts := httptest.NewServer(http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(
+				t,
+				"AssumeRoleWithWebIdentity",
+				r.PostFormValue("Action"),
+			)
+			assert.Equal(
+				t,
+				"arn:aws:iam::123456789012:role/goproxy",
+				r.PostFormValue("RoleArn"),
+			)
+			assert.Equal(
+				t,
+				"token",
+				r.PostFormValue("WebIdentityToken"),
+			)
+
+			fmt.Fprintf(rw, `<AssumeRoleWithWebIdentityResponse
+xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+<AssumeRoleWithWebIdentityResult>
+<Credentials>
+<AccessKeyId>sts-id</AccessKeyId>
+<SecretAccessKey>sts-secret</SecretAccessKey>
+<SessionToken>sts-token</SessionToken>
+<Expiration>%s</Expiration>
+</Credentials>
+</AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`,
+				expiration.Load().(time.Time).UTC().Format(
+					time.RFC3339,
+				),
+			)
+		},
+	))
+	defer sts.Close()
+
+	defer setenvs(credentialEnvs)()
+	assert.Nil(t, newWebIdentityCredentials(http.DefaultClient))
+
+	roleARN := "arn:aws:iam::123456789012:role/goproxy"
+	defer setenvs(map[string]string{
+		"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFilename,
+		"AWS_ROLE_ARN":                roleARN,
+		"AWS_REGION":                  "eu-central-1",
+	})()
+
+	wic := newWebIdentityCredentials(http.DefaultClient)
+	if !assert.NotNil(t, wic) {
+		return
+	}
+
+	assert.Equal(t, "https://sts.eu-central-1.amazonaws.com", wic.endpoint)
+	wic.endpoint = sts.URL
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{wic})
+	for i := 0; i < 3; i++ {
+		v, err := creds.Get()
+		assert.NoError(t, err)
+		assert.Equal(t, "sts-id", v.AccessKeyID)
+		assert.Equal(t, "sts-token", v.SessionToken)
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// The credentials are refreshed once they are about to expire.
+	expiration.Store(time.Now().Add(time.Second))
+	creds.Expire()
+	_, err = creds.Get()
+	assert.NoError(t, err)
+	_, err = creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	assert.NoError(t, os.Remove(tokenFilename))
+	creds.Expire()
+	v, err := creds.Get()
+	assert.NoError(t, err)
+	assert.True(t, v.SignerType.IsAnonymous())
+}
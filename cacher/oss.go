@@ -4,6 +4,7 @@ import (
 	"context"
 	"hash"
 	"sync"
+	"time"
 
 	"github.com/goproxy/goproxy"
 )
@@ -29,6 +30,9 @@ type OSS struct {
 	// Root is the root of the caches.
 	Root string `mapstructure:"root"`
 
+	// MinIOOptions is the advanced options.
+	MinIOOptions `mapstructure:",squash"`
+
 	loadOnce sync.Once
 	minio    *MinIO
 }
@@ -46,6 +50,7 @@ func (o *OSS) load() {
 		SecretAccessKey: o.AccessKeySecret,
 		BucketName:      o.BucketName,
 		Root:            o.Root,
+		MinIOOptions:    o.MinIOOptions,
 		virtualHosted:   true,
 	}
 }
@@ -66,3 +71,25 @@ func (o *OSS) SetCache(ctx context.Context, c goproxy.Cache) error {
 	o.loadOnce.Do(o.load)
 	return o.minio.SetCache(ctx, c)
 }
+
+// Caches implements the `goproxy.CacheLister`.
+func (o *OSS) Caches(ctx context.Context, prefix string) ([]string, error) {
+	o.loadOnce.Do(o.load)
+	return o.minio.Caches(ctx, prefix)
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (o *OSS) DeleteCache(ctx context.Context, name string) error {
+	o.loadOnce.Do(o.load)
+	return o.minio.DeleteCache(ctx, name)
+}
+
+// PresignCache implements the `goproxy.CachePresigner`.
+func (o *OSS) PresignCache(
+	ctx context.Context,
+	name string,
+	expiry time.Duration,
+) (string, error) {
+	o.loadOnce.Do(o.load)
+	return o.minio.PresignCache(ctx, name, expiry)
+}
@@ -0,0 +1,359 @@
+package cacher
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goproxy/goproxy"
+)
+
+// Replicated implements the `goproxy.Cacher` by replicating the caches to
+// several independent cachers, such as a MinIO on-premises and an S3.
+//
+// Reads use the first replica that has the cache, and the preceding replicas
+// that do not have it are repaired in the background. Writes succeed once the
+// `Quorum` of replicas has the cache, and the remaining replicas are written in
+// the background.
+type Replicated struct {
+	// Replicas is the replicas, preferred first. All of them should use
+	// the same kind of checksum, since the `NewHash` of the first one is
+	// used for all of them.
+	Replicas []goproxy.Cacher `mapstructure:"replicas"`
+
+	// Quorum is the number of the replicas that must have a cache before
+	// the `SetCache` reports success.
+	//
+	// If the `Quorum` is zero, all replicas are required. It must not be
+	// negative or greater than the number of the `Replicas`.
+	Quorum int `mapstructure:"quorum"`
+
+	mutex     sync.Mutex
+	repairing map[string]bool
+	closed    bool
+	waitGroup sync.WaitGroup
+}
+
+// validate validates the r.
+func (r *Replicated) validate() error {
+	if len(r.Replicas) == 0 {
+		return errors.New("no replicas")
+	}
+
+	for i, replica := range r.Replicas {
+		if replica == nil {
+			return fmt.Errorf("replica %d is nil", i)
+		}
+	}
+
+	if r.Quorum < 0 || r.Quorum > len(r.Replicas) {
+		return fmt.Errorf("invalid quorum %d", r.Quorum)
+	}
+
+	return nil
+}
+
+// NewHash implements the `goproxy.Cacher`. It falls back to MD5 if the r is
+// invalid, whose caches cannot be read or written anyway.
+func (r *Replicated) NewHash() hash.Hash {
+	if r.validate() != nil {
+		return md5.New()
+	}
+
+	return r.Replicas[0].NewHash()
+}
+
+// Cache implements the `goproxy.Cacher`. A replica failing to read is skipped,
+// its error is only returned if no other replica has the cache.
+func (r *Replicated) Cache(
+	ctx context.Context,
+	name string,
+) (goproxy.Cache, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		missing []goproxy.Cacher
+		lastErr error
+	)
+	for _, replica := range r.Replicas {
+		c, err := replica.Cache(ctx, name)
+		if err == goproxy.ErrCacheNotFound {
+			missing = append(missing, replica)
+			continue
+		} else if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if len(missing) > 0 {
+			r.goCopy(name, replica, missing)
+		}
+
+		return c, nil
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	return nil, goproxy.ErrCacheNotFound
+}
+
+// SetCache implements the `goproxy.Cacher`. It fails if fewer replicas than
+// the `Quorum` succeed.
+func (r *Replicated) SetCache(ctx context.Context, c goproxy.Cache) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	quorum := r.Quorum
+	if quorum == 0 {
+		quorum = len(r.Replicas)
+	}
+
+	var (
+		succeeded []goproxy.Cacher
+		failed    []goproxy.Cacher
+		lastErr   error
+	)
+	for i, replica := range r.Replicas {
+		if len(succeeded) == quorum {
+			// The rest is copied from a replica that has the
+			// cache, since the c is closed once this returns.
+			rest := append(failed, r.Replicas[i:]...)
+			r.goCopy(c.Name(), succeeded[0], rest)
+			return nil
+		}
+
+		_, err := c.Seek(0, io.SeekStart)
+		if err == nil {
+			err = replica.SetCache(ctx, c)
+		}
+
+		if err != nil {
+			failed = append(failed, replica)
+			lastErr = err
+		} else {
+			succeeded = append(succeeded, replica)
+		}
+	}
+
+	if len(succeeded) < quorum {
+		return lastErr
+	}
+
+	if len(failed) > 0 {
+		r.goCopy(c.Name(), succeeded[0], failed)
+	}
+
+	return nil
+}
+
+// Caches implements the `goproxy.CacheLister`. It merges the caches of the
+// replicas that implement the `goproxy.CacheLister`.
+func (r *Replicated) Caches(
+	ctx context.Context,
+	prefix string,
+) ([]string, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+	for _, replica := range r.Replicas {
+		cl, ok := replica.(goproxy.CacheLister)
+		if !ok {
+			continue
+		}
+
+		replicaNames, err := cl.Caches(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range replicaNames {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`. It deletes the cache from
+// all replicas that implement the `goproxy.CacheDeleter`.
+func (r *Replicated) DeleteCache(ctx context.Context, name string) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	found := false
+	for _, replica := range r.Replicas {
+		cd, ok := replica.(goproxy.CacheDeleter)
+		if !ok {
+			continue
+		}
+
+		if err := cd.DeleteCache(ctx, name); err == nil {
+			found = true
+		} else if err != goproxy.ErrCacheNotFound {
+			return err
+		}
+	}
+
+	if !found {
+		return goproxy.ErrCacheNotFound
+	}
+
+	return nil
+}
+
+// Reconcile copies the caches that have the prefix and are missing from some
+// of the replicas from the ones that have them. It returns the number of the
+// copies made. All replicas must implement the `goproxy.CacheLister`.
+func (r *Replicated) Reconcile(
+	ctx context.Context,
+	prefix string,
+) (int, error) {
+	if err := r.validate(); err != nil {
+		return 0, err
+	}
+
+	replicaNames := make([]map[string]bool, len(r.Replicas))
+	for i, replica := range r.Replicas {
+		cl, ok := replica.(goproxy.CacheLister)
+		if !ok {
+			return 0, errors.New("replica cannot list caches")
+		}
+
+		names, err := cl.Caches(ctx, prefix)
+		if err != nil {
+			return 0, err
+		}
+
+		replicaNames[i] = make(map[string]bool, len(names))
+		for _, name := range names {
+			replicaNames[i][name] = true
+		}
+	}
+
+	copies := 0
+	for i, names := range replicaNames {
+		for name := range names {
+			var missing []goproxy.Cacher
+			for j, otherNames := range replicaNames {
+				if j != i && !otherNames[name] {
+					missing = append(missing, r.Replicas[j])
+					otherNames[name] = true
+				}
+			}
+
+			if len(missing) == 0 {
+				continue
+			}
+
+			n, err := copyCache(ctx, name, r.Replicas[i], missing)
+			copies += n
+			if err != nil {
+				return copies, err
+			}
+		}
+	}
+
+	return copies, nil
+}
+
+// Close waits for the background copies of the r to finish. The r can still
+// be used after being closed, but the replicas missing a cache are no longer
+// repaired in the background, which is left to the `Reconcile`.
+func (r *Replicated) Close() error {
+	r.mutex.Lock()
+	r.closed = true
+	r.mutex.Unlock()
+
+	r.waitGroup.Wait()
+
+	return nil
+}
+
+// goCopy copies the cache of the name from the replica to the missing replicas
+// in the background. The copy is skipped if one of the same name is already in
+// progress or the r is closed, and its error is ignored since the `Reconcile`
+// makes up for it.
+func (r *Replicated) goCopy(
+	name string,
+	replica goproxy.Cacher,
+	missing []goproxy.Cacher,
+) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed || r.repairing[name] {
+		return
+	}
+
+	if r.repairing == nil {
+		r.repairing = map[string]bool{}
+	}
+
+	r.repairing[name] = true
+	r.waitGroup.Add(1)
+
+	go func() {
+		defer func() {
+			r.mutex.Lock()
+			delete(r.repairing, name)
+			r.mutex.Unlock()
+
+			r.waitGroup.Done()
+		}()
+
+		ctx, cancel := context.WithTimeout(
+			context.Background(),
+			10*time.Minute,
+		)
+		defer cancel()
+
+		copyCache(ctx, name, replica, missing)
+	}()
+}
+
+// copyCache copies the cache of the name from the src to each of the dsts. It
+// returns the number of the copies made.
+func copyCache(
+	ctx context.Context,
+	name string,
+	src goproxy.Cacher,
+	dsts []goproxy.Cacher,
+) (int, error) {
+	copies := 0
+	for _, dst := range dsts {
+		c, err := src.Cache(ctx, name)
+		if err != nil {
+			return copies, err
+		}
+
+		err = dst.SetCache(ctx, c)
+		c.Close()
+		if err != nil {
+			return copies, err
+		}
+
+		copies++
+	}
+
+	return copies, nil
+}
@@ -0,0 +1,274 @@
+package cacher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goproxy/goproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicatedSetCache(t *testing.T) {
+	m1, m2, m3 := &Memory{}, &Memory{}, &Memory{}
+	r := &Replicated{Replicas: []goproxy.Cacher{m1, m2, m3}, Quorum: 2}
+
+	assert.NoError(t, r.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+
+	// The replicas beyond the quorum are written in the background.
+	assert.NoError(t, r.Close())
+	for _, m := range []*Memory{m1, m2, m3} {
+		b, err := cacheContent(m, "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("foo"), b)
+	}
+
+	// A failed replica is repaired from one that succeeded once the
+	// quorum is reached anyway.
+	m1 = &Memory{}
+	r = &Replicated{
+		Replicas: []goproxy.Cacher{&failingCacher{}, m1, &Memory{}},
+		Quorum:   2,
+	}
+	assert.NoError(t, r.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+	assert.NoError(t, r.Close())
+
+	b, err := cacheContent(m1, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), b)
+
+	// Without the quorum, the last error is returned.
+	for _, r := range []*Replicated{
+		{Replicas: []goproxy.Cacher{&failingCacher{}, &Memory{}}},
+		{
+			Replicas: []goproxy.Cacher{
+				&Memory{},
+				&failingCacher{},
+				&failingCacher{},
+			},
+			Quorum: 2,
+		},
+	} {
+		assert.EqualError(t, r.SetCache(
+			context.Background(),
+			newTestCache("foo", []byte("foo")),
+		), "failing")
+		assert.NoError(t, r.Close())
+	}
+}
+
+func TestReplicatedCache(t *testing.T) {
+	m1, m2 := &Memory{}, &Memory{}
+	r := &Replicated{Replicas: []goproxy.Cacher{m1, m2}}
+
+	assert.NoError(t, m2.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+
+	b, err := cacheContent(r, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), b)
+
+	// The preceding replica that missed the cache is repaired in the
+	// background.
+	assert.NoError(t, r.Close())
+	b, err = cacheContent(m1, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), b)
+
+	_, err = r.Cache(context.Background(), "bar")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	// A failing replica is skipped, and its error is only returned if no
+	// other replica has the cache.
+	r = &Replicated{Replicas: []goproxy.Cacher{&failingCacher{}, m2}}
+
+	b, err = cacheContent(r, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), b)
+
+	_, err = r.Cache(context.Background(), "bar")
+	assert.EqualError(t, err, "failing")
+	assert.NoError(t, r.Close())
+
+	// A closed one no longer repairs in the background.
+	m1 = &Memory{}
+	r = &Replicated{Replicas: []goproxy.Cacher{m1, m2}}
+	assert.NoError(t, r.Close())
+
+	b, err = cacheContent(r, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), b)
+	assert.NoError(t, r.Close())
+
+	_, err = m1.Cache(context.Background(), "foo")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+}
+
+func TestReplicatedCaches(t *testing.T) {
+	m1, m2 := &Memory{}, &Memory{}
+	r := &Replicated{
+		Replicas: []goproxy.Cacher{m1, m2, &plainCacher{&Memory{}}},
+	}
+	defer r.Close()
+
+	for m, names := range map[*Memory][]string{
+		m1: {"example.com/bar/@v/list", "example.com/foo/@v/list"},
+		m2: {
+			"example.com/foo/@v/list",
+			"example.com/foo/@v/v1.0.0.mod",
+		},
+	} {
+		for _, name := range names {
+			assert.NoError(t, m.SetCache(
+				context.Background(),
+				newTestCache(name, []byte(name)),
+			))
+		}
+	}
+
+	names, err := r.Caches(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"example.com/bar/@v/list",
+		"example.com/foo/@v/list",
+		"example.com/foo/@v/v1.0.0.mod",
+	}, names)
+
+	names, err = r.Caches(context.Background(), "example.com/foo/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"example.com/foo/@v/list",
+		"example.com/foo/@v/v1.0.0.mod",
+	}, names)
+
+	assert.NoError(t, r.DeleteCache(
+		context.Background(),
+		"example.com/foo/@v/list",
+	))
+	assert.Equal(t, goproxy.ErrCacheNotFound, r.DeleteCache(
+		context.Background(),
+		"example.com/foo/@v/list",
+	))
+
+	for _, m := range []*Memory{m1, m2} {
+		_, err := m.Cache(
+			context.Background(),
+			"example.com/foo/@v/list",
+		)
+		assert.Equal(t, goproxy.ErrCacheNotFound, err)
+	}
+}
+
+func TestReplicatedReconcile(t *testing.T) {
+	m1, m2, m3 := &Memory{}, &Memory{}, &Memory{}
+	r := &Replicated{Replicas: []goproxy.Cacher{m1, m2, m3}}
+	defer r.Close()
+
+	for m, names := range map[*Memory][]string{
+		m1: {"example.com/foo/@v/list"},
+		m2: {
+			"example.com/foo/@v/v1.0.0.mod",
+			"example.com/bar/@v/list",
+		},
+		m3: {
+			"example.com/foo/@v/list",
+			"example.com/foo/@v/v1.0.0.mod",
+		},
+	} {
+		for _, name := range names {
+			assert.NoError(t, m.SetCache(
+				context.Background(),
+				newTestCache(name, []byte(name)),
+			))
+		}
+	}
+
+	copies, err := r.Reconcile(context.Background(), "example.com/foo/")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, copies)
+
+	for _, m := range []*Memory{m1, m2, m3} {
+		for _, name := range []string{
+			"example.com/foo/@v/list",
+			"example.com/foo/@v/v1.0.0.mod",
+		} {
+			b, err := cacheContent(m, name)
+			assert.NoError(t, err, name)
+			assert.Equal(t, []byte(name), b, name)
+		}
+	}
+
+	// The caches without the prefix are left alone.
+	_, err = m1.Cache(context.Background(), "example.com/bar/@v/list")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	copies, err = r.Reconcile(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, copies)
+
+	copies, err = r.Reconcile(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, copies)
+
+	r = &Replicated{Replicas: []goproxy.Cacher{m1, &plainCacher{m2}}}
+	_, err = r.Reconcile(context.Background(), "")
+	assert.EqualError(t, err, "replica cannot list caches")
+}
+
+func TestReplicatedInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		r   *Replicated
+		err string
+	}{
+		{&Replicated{}, "no replicas"},
+		{
+			&Replicated{Replicas: []goproxy.Cacher{&Memory{}, nil}},
+			"replica 1 is nil",
+		},
+		{
+			&Replicated{
+				Replicas: []goproxy.Cacher{&Memory{}},
+				Quorum:   2,
+			},
+			"invalid quorum 2",
+		},
+		{
+			&Replicated{
+				Replicas: []goproxy.Cacher{&Memory{}},
+				Quorum:   -1,
+			},
+			"invalid quorum -1",
+		},
+	} {
+		assert.NotNil(t, tc.r.NewHash())
+
+		_, err := tc.r.Cache(context.Background(), "foo")
+		assert.EqualError(t, err, tc.err)
+
+		assert.EqualError(t, tc.r.SetCache(
+			context.Background(),
+			newTestCache("foo", []byte("foo")),
+		), tc.err)
+
+		_, err = tc.r.Caches(context.Background(), "")
+		assert.EqualError(t, err, tc.err)
+
+		assert.EqualError(
+			t,
+			tc.r.DeleteCache(context.Background(), "foo"),
+			tc.err,
+		)
+
+		_, err = tc.r.Reconcile(context.Background(), "")
+		assert.EqualError(t, err, tc.err)
+
+		assert.NoError(t, tc.r.Close())
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"hash"
 	"sync"
+	"time"
 
 	"github.com/goproxy/goproxy"
 )
@@ -28,6 +29,9 @@ type S3 struct {
 	// Root is the root of the caches.
 	Root string `mapstructure:"root"`
 
+	// MinIOOptions is the advanced options.
+	MinIOOptions `mapstructure:",squash"`
+
 	loadOnce sync.Once
 	minio    *MinIO
 }
@@ -45,6 +49,7 @@ func (s *S3) load() {
 		SecretAccessKey: s.SecretAccessKey,
 		BucketName:      s.BucketName,
 		Root:            s.Root,
+		MinIOOptions:    s.MinIOOptions,
 		virtualHosted:   true,
 	}
 }
@@ -65,3 +70,25 @@ func (s *S3) SetCache(ctx context.Context, c goproxy.Cache) error {
 	s.loadOnce.Do(s.load)
 	return s.minio.SetCache(ctx, c)
 }
+
+// Caches implements the `goproxy.CacheLister`.
+func (s *S3) Caches(ctx context.Context, prefix string) ([]string, error) {
+	s.loadOnce.Do(s.load)
+	return s.minio.Caches(ctx, prefix)
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (s *S3) DeleteCache(ctx context.Context, name string) error {
+	s.loadOnce.Do(s.load)
+	return s.minio.DeleteCache(ctx, name)
+}
+
+// PresignCache implements the `goproxy.CachePresigner`.
+func (s *S3) PresignCache(
+	ctx context.Context,
+	name string,
+	expiry time.Duration,
+) (string, error) {
+	s.loadOnce.Do(s.load)
+	return s.minio.PresignCache(ctx, name, expiry)
+}
@@ -0,0 +1,264 @@
+package cacher
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/goproxy/goproxy"
+)
+
+// Tiered implements the `goproxy.Cacher` by chaining several cachers, such as
+// a memory, a local disk, and a shared object storage.
+//
+// Reads try each tier in order and promote hits into the preceding tiers that
+// accept them. The promotion is synchronous: the read that hits a slower tier
+// first writes the cache to the faster ones, and only then returns it, so that
+// the next read is served by the fastest tier without racing the promotion.
+// Writes go to all tiers that accept them.
+type Tiered struct {
+	// Tiers is the tiers, fastest first. All of their cachers should use
+	// the same kind of checksum, since the `NewHash` of the first one is
+	// used for all of them.
+	//
+	// The `Tiers` must not be empty, otherwise all operations fail.
+	Tiers []*Tier `mapstructure:"tiers"`
+}
+
+// Tier is a tier of the `Tiered`.
+type Tier struct {
+	// Cacher is the cacher of the tier.
+	Cacher goproxy.Cacher `mapstructure:"cacher"`
+
+	// Required indicates whether writes to the tier must succeed.
+	//
+	// If none of the tiers is required, writes only fail when all tiers
+	// fail.
+	Required bool `mapstructure:"required"`
+
+	// MaxCacheBytes is the maximum size in bytes of the caches accepted by
+	// the tier.
+	//
+	// If the `MaxCacheBytes` is zero, then there will be no limitations.
+	MaxCacheBytes int64 `mapstructure:"max_cache_bytes"`
+
+	// NameExts is the name extensions of the caches accepted by the tier,
+	// such as ".info" and ".mod".
+	//
+	// If the `NameExts` is empty, then all caches are accepted.
+	NameExts []string `mapstructure:"name_exts"`
+}
+
+// accepts reports whether the t accepts the c.
+func (t *Tier) accepts(c goproxy.Cache) bool {
+	if t.MaxCacheBytes > 0 && c.Size() > t.MaxCacheBytes {
+		return false
+	}
+
+	if len(t.NameExts) == 0 {
+		return true
+	}
+
+	nameExt := path.Ext(c.Name())
+	for _, ne := range t.NameExts {
+		if ne == nameExt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate validates the t.
+func (t *Tiered) validate() error {
+	if len(t.Tiers) == 0 {
+		return errors.New("no tiers")
+	}
+
+	for i, tier := range t.Tiers {
+		if tier == nil || tier.Cacher == nil {
+			return fmt.Errorf("tier %d has no cacher", i)
+		}
+	}
+
+	return nil
+}
+
+// NewHash implements the `goproxy.Cacher`. It falls back to MD5 if the t is
+// invalid, whose caches cannot be read or written anyway.
+func (t *Tiered) NewHash() hash.Hash {
+	if t.validate() != nil {
+		return md5.New()
+	}
+
+	return t.Tiers[0].Cacher.NewHash()
+}
+
+// Cache implements the `goproxy.Cacher`. A tier failing to read is skipped,
+// its error is only returned if no other tier has the cache. A hit in a tier
+// other than the first one is promoted before being returned.
+func (t *Tiered) Cache(
+	ctx context.Context,
+	name string,
+) (goproxy.Cache, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for i, tier := range t.Tiers {
+		c, err := tier.Cacher.Cache(ctx, name)
+		if err != nil {
+			if err != goproxy.ErrCacheNotFound {
+				lastErr = err
+			}
+
+			continue
+		}
+
+		if i > 0 {
+			// The promotion is best-effort, so its error is
+			// ignored.
+			t.setCache(ctx, c, t.Tiers[:i])
+
+			if _, err := c.Seek(0, io.SeekStart); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+
+		return c, nil
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	return nil, goproxy.ErrCacheNotFound
+}
+
+// SetCache implements the `goproxy.Cacher`. It fails if any of the required
+// tiers fails, or if all tiers fail when none of them is required.
+func (t *Tiered) SetCache(ctx context.Context, c goproxy.Cache) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	return t.setCache(ctx, c, t.Tiers)
+}
+
+// setCache sets the c to the tiers that accept it.
+func (t *Tiered) setCache(
+	ctx context.Context,
+	c goproxy.Cache,
+	tiers []*Tier,
+) error {
+	var (
+		requiredErr error
+		lastErr     error
+		succeeded   bool
+	)
+	for _, tier := range tiers {
+		if !tier.accepts(c) {
+			continue
+		}
+
+		_, err := c.Seek(0, io.SeekStart)
+		if err == nil {
+			err = tier.Cacher.SetCache(ctx, c)
+		}
+
+		if err != nil {
+			if tier.Required && requiredErr == nil {
+				requiredErr = err
+			}
+
+			lastErr = err
+		} else {
+			succeeded = true
+		}
+	}
+
+	if requiredErr != nil {
+		return requiredErr
+	}
+
+	if !succeeded {
+		return lastErr
+	}
+
+	return nil
+}
+
+// Caches implements the `goproxy.CacheLister`. It merges the caches of the
+// tiers that implement the `goproxy.CacheLister`.
+func (t *Tiered) Caches(ctx context.Context, prefix string) ([]string, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+	for _, tier := range t.Tiers {
+		cl, ok := tier.Cacher.(goproxy.CacheLister)
+		if !ok {
+			continue
+		}
+
+		tierNames, err := cl.Caches(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range tierNames {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`. It deletes the cache from
+// all tiers, and fails without deleting anything if any of them does not
+// implement the `goproxy.CacheDeleter`, since the cache would otherwise be
+// promoted back from it.
+func (t *Tiered) DeleteCache(ctx context.Context, name string) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	cds := make([]goproxy.CacheDeleter, 0, len(t.Tiers))
+	for i, tier := range t.Tiers {
+		cd, ok := tier.Cacher.(goproxy.CacheDeleter)
+		if !ok {
+			return fmt.Errorf("tier %d cannot delete caches", i)
+		}
+
+		cds = append(cds, cd)
+	}
+
+	found := false
+	for _, cd := range cds {
+		if err := cd.DeleteCache(ctx, name); err == nil {
+			found = true
+		} else if err != goproxy.ErrCacheNotFound {
+			return err
+		}
+	}
+
+	if !found {
+		return goproxy.ErrCacheNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package cacher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/goproxy/goproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// plainCacher hides the optional interfaces of a `goproxy.Cacher`.
+type plainCacher struct {
+	goproxy.Cacher
+}
+
+// failingCacher is a `goproxy.Cacher` that always fails.
+type failingCacher struct {
+	Memory
+}
+
+func (fc *failingCacher) Cache(
+	ctx context.Context,
+	name string,
+) (goproxy.Cache, error) {
+	return nil, errors.New("failing")
+}
+
+func (fc *failingCacher) SetCache(ctx context.Context, c goproxy.Cache) error {
+	return errors.New("failing")
+}
+
+func TestTieredCache(t *testing.T) {
+	fast, slow := &Memory{}, &Memory{}
+	tiered := &Tiered{Tiers: []*Tier{
+		{Cacher: fast, NameExts: []string{".info", ".mod"}},
+		{Cacher: &failingCacher{}},
+		{Cacher: slow},
+	}}
+
+	assert.NoError(t, fast.SetCache(
+		context.Background(),
+		newTestCache("foo.info", []byte("fast")),
+	))
+	assert.NoError(t, slow.SetCache(
+		context.Background(),
+		newTestCache("foo.info", []byte("slow")),
+	))
+
+	// The first tier having the cache wins, the failing tier is skipped.
+	b, err := cacheContent(tiered, "foo.info")
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", string(b))
+
+	// A hit in a slower tier is promoted into the faster tiers that accept
+	// it.
+	for name, promoted := range map[string]bool{
+		"bar.mod": true,
+		"bar.zip": false,
+	} {
+		assert.NoError(t, slow.SetCache(
+			context.Background(),
+			newTestCache(name, []byte(name)),
+		))
+
+		b, err := cacheContent(tiered, name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, name, string(b), name)
+
+		b, err = cacheContent(fast, name)
+		if promoted {
+			assert.NoError(t, err, name)
+			assert.Equal(t, name, string(b), name)
+		} else {
+			assert.Equal(t, goproxy.ErrCacheNotFound, err, name)
+		}
+	}
+
+	_, err = tiered.Cache(context.Background(), "missing")
+	assert.EqualError(t, err, "failing")
+
+	tiered = &Tiered{Tiers: []*Tier{{Cacher: fast}}}
+	_, err = tiered.Cache(context.Background(), "missing")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+}
+
+func TestTieredSetCache(t *testing.T) {
+	fast, slow := &Memory{}, &Memory{}
+	tiered := &Tiered{Tiers: []*Tier{
+		{Cacher: fast, MaxCacheBytes: 3},
+		{Cacher: &failingCacher{}},
+		{Cacher: slow},
+	}}
+
+	assert.NoError(t, tiered.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+	assert.NoError(t, tiered.SetCache(
+		context.Background(),
+		newTestCache("foobar", []byte("foobar")),
+	))
+
+	for cacher, names := range map[goproxy.Cacher][]string{
+		fast: {"foo"},
+		slow: {"foo", "foobar"},
+	} {
+		got, err := cacher.(goproxy.CacheLister).Caches(
+			context.Background(),
+			"",
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, names, got)
+	}
+
+	tiered.Tiers[1].Required = true
+	assert.EqualError(t, tiered.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	), "failing")
+
+	tiered = &Tiered{Tiers: []*Tier{{Cacher: &failingCacher{}}}}
+	assert.EqualError(t, tiered.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	), "failing")
+}
+
+func TestTieredDeleteCache(t *testing.T) {
+	fast, slow := &Memory{}, &Memory{}
+	tiered := &Tiered{Tiers: []*Tier{{Cacher: fast}, {Cacher: slow}}}
+
+	assert.NoError(t, tiered.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+	assert.NoError(t, slow.SetCache(
+		context.Background(),
+		newTestCache("bar", []byte("bar")),
+	))
+
+	names, err := tiered.Caches(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bar", "foo"}, names)
+
+	for _, name := range []string{"foo", "bar"} {
+		assert.NoError(t, tiered.DeleteCache(
+			context.Background(),
+			name,
+		))
+
+		_, err := tiered.Cache(context.Background(), name)
+		assert.Equal(t, goproxy.ErrCacheNotFound, err)
+	}
+
+	assert.Equal(
+		t,
+		goproxy.ErrCacheNotFound,
+		tiered.DeleteCache(context.Background(), "foo"),
+	)
+
+	// A tier that cannot delete caches would promote them back, so
+	// nothing is deleted.
+	assert.NoError(t, tiered.SetCache(
+		context.Background(),
+		newTestCache("foo", []byte("foo")),
+	))
+
+	tiered.Tiers[1].Cacher = &plainCacher{slow}
+	assert.EqualError(
+		t,
+		tiered.DeleteCache(context.Background(), "foo"),
+		"tier 1 cannot delete caches",
+	)
+
+	_, err = fast.Cache(context.Background(), "foo")
+	assert.NoError(t, err)
+}
+
+func TestTieredInvalid(t *testing.T) {
+	for _, tiered := range []*Tiered{
+		{},
+		{Tiers: []*Tier{{}}},
+	} {
+		assert.NotNil(t, tiered.NewHash())
+
+		_, err := tiered.Cache(context.Background(), "foo")
+		assert.Error(t, err)
+
+		assert.Error(t, tiered.SetCache(
+			context.Background(),
+			newTestCache("foo", []byte("foo")),
+		))
+
+		_, err = tiered.Caches(context.Background(), "")
+		assert.Error(t, err)
+
+		assert.Error(t, tiered.DeleteCache(context.Background(), "foo"))
+	}
+
+	_, err := (&Tiered{}).Cache(context.Background(), "foo")
+	assert.EqualError(t, err, "no tiers")
+}
@@ -0,0 +1,113 @@
+package cacher
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v6/pkg/credentials"
+)
+
+// webIdentityCredentials implements the `credentials.Provider` by exchanging
+// the web identity token file of the IAM roles for service accounts for
+// temporary credentials through the AWS Security Token Service. The
+// credentials are kept until they expire.
+type webIdentityCredentials struct {
+	credentials.Expiry
+
+	client          *http.Client
+	endpoint        string
+	tokenFilename   string
+	roleARN         string
+	roleSessionName string
+}
+
+// newWebIdentityCredentials returns a new instance of the
+// `webIdentityCredentials` configured by the AWS_WEB_IDENTITY_TOKEN_FILE,
+// AWS_ROLE_ARN, AWS_ROLE_SESSION_NAME, and AWS_REGION environment variables
+// with the client. It returns nil if the AWS_WEB_IDENTITY_TOKEN_FILE is not
+// set.
+func newWebIdentityCredentials(client *http.Client) *webIdentityCredentials {
+	tokenFilename := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if tokenFilename == "" {
+		return nil
+	}
+
+	endpoint := "https://sts.amazonaws.com"
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+	}
+
+	return &webIdentityCredentials{
+		client:          client,
+		endpoint:        endpoint,
+		tokenFilename:   tokenFilename,
+		roleARN:         os.Getenv("AWS_ROLE_ARN"),
+		roleSessionName: os.Getenv("AWS_ROLE_SESSION_NAME"),
+	}
+}
+
+// Retrieve implements the `credentials.Provider`.
+func (wic *webIdentityCredentials) Retrieve() (credentials.Value, error) {
+	// The token file is read every time, since it is rotated.
+	token, err := ioutil.ReadFile(wic.tokenFilename)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	roleSessionName := wic.roleSessionName
+	if roleSessionName == "" {
+		roleSessionName = "goproxy-" +
+			strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRoleWithWebIdentity")
+	form.Set("Version", "2011-06-15")
+	form.Set("RoleArn", wic.roleARN)
+	form.Set("RoleSessionName", roleSessionName)
+	form.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	res, err := wic.client.PostForm(wic.endpoint, form)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(res.Body)
+		return credentials.Value{}, fmt.Errorf(
+			"AssumeRoleWithWebIdentity: %s: %s",
+			res.Status,
+			b,
+		)
+	}
+
+	var result credentials.AssumeRoleWithWebIdentityResponse
+	if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return credentials.Value{}, err
+	}
+
+	creds := result.Result.Credentials
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return credentials.Value{}, errors.New(
+			"AssumeRoleWithWebIdentity: no credentials returned",
+		)
+	}
+
+	wic.SetExpiration(creds.Expiration, credentials.DefaultExpiryWindow)
+
+	return credentials.Value{
+		AccessKeyID:     creds.AccessKey,
+		SecretAccessKey: creds.SecretKey,
+		SessionToken:    creds.SessionToken,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
@@ -0,0 +1,607 @@
+package cacher
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goproxy/goproxy"
+)
+
+// WebDAV implements the `goproxy.Cacher` by using an HTTP server that accepts
+// the PUT, GET, HEAD, and DELETE, such as a WebDAV server or an Artifactory
+// generic repository. Listing the caches additionally requires the PROPFIND.
+//
+// The MIME type and the checksum of each cache are stored in a sidecar named
+// "<name>.metadata", which is written after the content, so that a cache is
+// never found before it is complete.
+type WebDAV struct {
+	// BaseURL is the base URL of the caches.
+	BaseURL string `mapstructure:"base_url"`
+
+	// Username is the username of the basic authentication.
+	Username string `mapstructure:"username"`
+
+	// Password is the password of the basic authentication.
+	Password string `mapstructure:"password"`
+
+	// BearerToken is the token of the bearer authentication. It takes
+	// precedence over the `Username` and the `Password`.
+	BearerToken string `mapstructure:"bearer_token"`
+
+	// HTTPClient is the client used to send the requests.
+	//
+	// If the `HTTPClient` is nil, the `http.DefaultClient` is used.
+	HTTPClient *http.Client `mapstructure:"-"`
+
+	loadOnce  sync.Once
+	loadError error
+	baseURL   *url.URL
+}
+
+// webDAVMetadata is the metadata of a cache of a `WebDAV`.
+type webDAVMetadata struct {
+	MIMEType string
+	Checksum []byte
+}
+
+// webDAVMultistatus is the response body of a PROPFIND.
+type webDAVMultistatus struct {
+	Responses []webDAVResponse `xml:"DAV: response"`
+}
+
+// webDAVResponse is a response of a `webDAVMultistatus`.
+type webDAVResponse struct {
+	Href      string           `xml:"DAV: href"`
+	Propstats []webDAVPropstat `xml:"DAV: propstat"`
+}
+
+// webDAVPropstat is a propstat of a `webDAVResponse`.
+type webDAVPropstat struct {
+	Collection *struct{} `xml:"DAV: prop>resourcetype>collection"`
+}
+
+// isCollection reports whether the wr is a collection.
+func (wr *webDAVResponse) isCollection() bool {
+	for _, ps := range wr.Propstats {
+		if ps.Collection != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// load loads the stuff of the w up.
+func (w *WebDAV) load() {
+	if w.baseURL, w.loadError = url.Parse(w.BaseURL); w.loadError != nil {
+		return
+	}
+
+	w.baseURL.Path = strings.TrimSuffix(w.baseURL.Path, "/") + "/"
+	w.baseURL.RawPath = ""
+}
+
+// NewHash implements the `goproxy.Cacher`.
+func (w *WebDAV) NewHash() hash.Hash {
+	return md5.New()
+}
+
+// Cache implements the `goproxy.Cacher`.
+func (w *WebDAV) Cache(
+	ctx context.Context,
+	name string,
+) (goproxy.Cache, error) {
+	if w.loadOnce.Do(w.load); w.loadError != nil {
+		return nil, w.loadError
+	}
+
+	res, err := w.do(ctx, http.MethodGet, w.url(name+".metadata"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var wm webDAVMetadata
+	if err := json.Unmarshal(b, &wm); err != nil {
+		return nil, err
+	}
+
+	res, err = w.do(ctx, http.MethodHead, w.url(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.ContentLength < 0 {
+		return nil, fmt.Errorf("unknown size of cache %q", name)
+	}
+
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+
+	return &webDAVCache{
+		webDAV:   w,
+		ctx:      ctx,
+		name:     name,
+		mimeType: wm.MIMEType,
+		size:     res.ContentLength,
+		modTime:  modTime,
+		checksum: wm.Checksum,
+	}, nil
+}
+
+// SetCache implements the `goproxy.Cacher`.
+func (w *WebDAV) SetCache(ctx context.Context, c goproxy.Cache) error {
+	if w.loadOnce.Do(w.load); w.loadError != nil {
+		return w.loadError
+	}
+
+	err := w.put(ctx, c.Name(), c.MIMEType(), c, c.Size(), c.Checksum())
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(&webDAVMetadata{
+		MIMEType: c.MIMEType(),
+		Checksum: c.Checksum(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.put(
+		ctx,
+		c.Name()+".metadata",
+		"application/json; charset=utf-8",
+		bytes.NewReader(b),
+		int64(len(b)),
+		nil,
+	)
+}
+
+// put puts the content of the size read from the rs to the name. The parent
+// collections are created if the server requires them to exist.
+func (w *WebDAV) put(
+	ctx context.Context,
+	name string,
+	mimeType string,
+	rs io.ReadSeeker,
+	size int64,
+	checksum []byte,
+) error {
+	header := http.Header{}
+	header.Set("Content-Type", mimeType)
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	if len(checksum) == md5.Size {
+		// Some servers, such as the Artifactory, verify it.
+		header.Set("X-Checksum-Md5", hex.EncodeToString(checksum))
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := w.doWithHeader(
+			ctx,
+			http.MethodPut,
+			w.url(name),
+			header,
+			ioutil.NopCloser(rs),
+		)
+		if err == nil {
+			res.Body.Close()
+			return nil
+		} else if attempt > 0 || !isWebDAVConflict(err) {
+			return err
+		}
+
+		if err := w.mkcols(ctx, path.Dir(name)); err != nil {
+			return err
+		}
+
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+}
+
+// mkcols creates the collection of the dir along with its missing parents up
+// to the one of the `BaseURL`.
+func (w *WebDAV) mkcols(ctx context.Context, dir string) error {
+	col := ""
+	if dir != "." && dir != "/" {
+		col = dir + "/"
+	}
+
+	res, err := w.do(ctx, "MKCOL", w.url(col), nil)
+	if err == nil {
+		return res.Body.Close()
+	} else if isWebDAVStatus(err, http.StatusMethodNotAllowed) {
+		// The collection already exists.
+		return nil
+	} else if col == "" || !isWebDAVConflict(err) {
+		return err
+	}
+
+	if err := w.mkcols(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+
+	res, err = w.do(ctx, "MKCOL", w.url(col), nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// Caches implements the `goproxy.CacheLister`.
+func (w *WebDAV) Caches(ctx context.Context, prefix string) ([]string, error) {
+	if w.loadOnce.Do(w.load); w.loadError != nil {
+		return nil, w.loadError
+	}
+
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i+1]
+	}
+
+	names := []string{}
+	if err := w.walk(ctx, dir, func(name string) {
+		if strings.HasPrefix(name, prefix) &&
+			!strings.HasSuffix(name, ".metadata") {
+			names = append(names, name)
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// walk calls the f with the name of each non-collection resource under the
+// dir, recursively. It only uses the PROPFIND of depth 1, since many servers
+// refuse the infinite depth.
+func (w *WebDAV) walk(ctx context.Context, dir string, f func(string)) error {
+	body := `<?xml version="1.0" encoding="utf-8"?>` +
+		`<propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`
+
+	header := http.Header{}
+	header.Set("Depth", "1")
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	res, err := w.doWithHeader(
+		ctx,
+		"PROPFIND",
+		w.url(dir),
+		header,
+		ioutil.NopCloser(strings.NewReader(body)),
+	)
+	if err != nil {
+		if err == goproxy.ErrCacheNotFound {
+			return nil
+		}
+
+		return err
+	}
+	defer res.Body.Close()
+
+	var ms webDAVMultistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return err
+	}
+
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return err
+		}
+
+		href = res.Request.URL.ResolveReference(href)
+		if !strings.HasPrefix(href.Path, w.baseURL.Path) {
+			continue
+		}
+
+		name := strings.TrimPrefix(href.Path, w.baseURL.Path)
+		if !r.isCollection() {
+			f(strings.TrimSuffix(name, "/"))
+			continue
+		}
+
+		name = strings.TrimSuffix(name, "/") + "/"
+		if name == "/" || name == dir {
+			continue
+		}
+
+		if err := w.walk(ctx, name, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteCache implements the `goproxy.CacheDeleter`.
+func (w *WebDAV) DeleteCache(ctx context.Context, name string) error {
+	if w.loadOnce.Do(w.load); w.loadError != nil {
+		return w.loadError
+	}
+
+	res, err := w.do(ctx, http.MethodDelete, w.url(name), nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	res, err = w.do(ctx, http.MethodDelete, w.url(name+".metadata"), nil)
+	if err != nil {
+		if err == goproxy.ErrCacheNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// url returns the URL of the name.
+func (w *WebDAV) url(name string) *url.URL {
+	u := *w.baseURL
+	u.Path += strings.TrimPrefix(name, "/")
+	return &u
+}
+
+// do is like the `doWithHeader` without any extra header.
+func (w *WebDAV) do(
+	ctx context.Context,
+	method string,
+	u *url.URL,
+	body io.ReadCloser,
+) (*http.Response, error) {
+	return w.doWithHeader(ctx, method, u, nil, body)
+}
+
+// doWithHeader sends a request of the method to the u with the header and the
+// body. It returns the `goproxy.ErrCacheNotFound` if the response status code
+// is 404, and a `webDAVStatusError` if it is otherwise not 2xx.
+func (w *WebDAV) doWithHeader(
+	ctx context.Context,
+	method string,
+	u *url.URL,
+	header http.Header,
+	body io.ReadCloser,
+) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+
+	// The Content-Length header is not sent as is, the length must be set
+	// explicitly since the body is of an unknown type.
+	if cl := header.Get("Content-Length"); cl != "" {
+		req.ContentLength, _ = strconv.ParseInt(cl, 10, 64)
+		if req.ContentLength == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	if w.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.BearerToken)
+	} else if w.Username != "" || w.Password != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+
+	client := w.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, goproxy.ErrCacheNotFound
+	}
+
+	return nil, &webDAVStatusError{
+		method:     method,
+		url:        redactedWebDAVURL(u),
+		statusCode: res.StatusCode,
+		status:     res.Status,
+		body:       strings.TrimSpace(string(b)),
+	}
+}
+
+// redactedWebDAVURL returns the string form of the u without its user info.
+func redactedWebDAVURL(u *url.URL) string {
+	ru := *u
+	ru.User = nil
+	return ru.String()
+}
+
+// webDAVStatusError is the error resulting if a `WebDAV` receives an
+// unexpected response status code.
+type webDAVStatusError struct {
+	method     string
+	url        string
+	statusCode int
+	status     string
+	body       string
+}
+
+// Error implements the `error`.
+func (wse *webDAVStatusError) Error() string {
+	if wse.body == "" {
+		return fmt.Sprintf("%s %s: %s", wse.method, wse.url, wse.status)
+	}
+
+	return fmt.Sprintf(
+		"%s %s: %s: %s",
+		wse.method,
+		wse.url,
+		wse.status,
+		wse.body,
+	)
+}
+
+// isWebDAVStatus reports whether the err is a `webDAVStatusError` of the
+// statusCode.
+func isWebDAVStatus(err error, statusCode int) bool {
+	wse, ok := err.(*webDAVStatusError)
+	return ok && wse.statusCode == statusCode
+}
+
+// isWebDAVConflict reports whether the err means that the parent collection of
+// a resource does not exist. Some servers respond 404 instead of 409.
+func isWebDAVConflict(err error) bool {
+	return err == goproxy.ErrCacheNotFound ||
+		isWebDAVStatus(err, http.StatusConflict)
+}
+
+// webDAVCache implements the `goproxy.Cache`. It is the cache unit of the
+// `WebDAV`, which reads its content with range requests on demand.
+type webDAVCache struct {
+	webDAV   *WebDAV
+	ctx      context.Context
+	name     string
+	mimeType string
+	size     int64
+	modTime  time.Time
+	checksum []byte
+	offset   int64
+	body     io.ReadCloser
+}
+
+// Read implements the `goproxy.Cache`.
+func (wc *webDAVCache) Read(b []byte) (int, error) {
+	if wc.offset >= wc.size {
+		return 0, io.EOF
+	}
+
+	if wc.body == nil {
+		header := http.Header{}
+		if wc.offset > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", wc.offset))
+		}
+
+		res, err := wc.webDAV.doWithHeader(
+			wc.ctx,
+			http.MethodGet,
+			wc.webDAV.url(wc.name),
+			header,
+			nil,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		// The server may ignore the range.
+		partial := res.StatusCode == http.StatusPartialContent
+		if wc.offset > 0 && !partial {
+			if _, err := io.CopyN(
+				ioutil.Discard,
+				res.Body,
+				wc.offset,
+			); err != nil {
+				res.Body.Close()
+				return 0, err
+			}
+		}
+
+		wc.body = res.Body
+	}
+
+	n, err := wc.body.Read(b)
+	wc.offset += int64(n)
+	if err == io.EOF && wc.offset < wc.size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// Seek implements the `goproxy.Cache`.
+func (wc *webDAVCache) Seek(offset int64, whence int) (int64, error) {
+	current := wc.offset
+	if _, err := seekOffset(&current, wc.size, offset, whence); err != nil {
+		return 0, err
+	}
+
+	if current != wc.offset && wc.body != nil {
+		wc.body.Close()
+		wc.body = nil
+	}
+
+	wc.offset = current
+
+	return current, nil
+}
+
+// Close implements the `goproxy.Cache`.
+func (wc *webDAVCache) Close() error {
+	if wc.body != nil {
+		return wc.body.Close()
+	}
+
+	return nil
+}
+
+// Name implements the `goproxy.Cache`.
+func (wc *webDAVCache) Name() string {
+	return wc.name
+}
+
+// MIMEType implements the `goproxy.Cache`.
+func (wc *webDAVCache) MIMEType() string {
+	return wc.mimeType
+}
+
+// Size implements the `goproxy.Cache`.
+func (wc *webDAVCache) Size() int64 {
+	return wc.size
+}
+
+// ModTime implements the `goproxy.Cache`.
+func (wc *webDAVCache) ModTime() time.Time {
+	return wc.modTime
+}
+
+// Checksum implements the `goproxy.Cache`.
+func (wc *webDAVCache) Checksum() []byte {
+	return wc.checksum
+}
@@ -0,0 +1,263 @@
+package cacher
+
+import (
+	"context"
+	"crypto/md5"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/goproxy/goproxy"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+)
+
+// testWebDAVServer is a WebDAV server for testing. It responds 409 to the PUTs
+// whose parent collections do not exist, like most servers do, and records the
+// methods of the requests it receives.
+type testWebDAVServer struct {
+	*httptest.Server
+
+	fs          webdav.FileSystem
+	ignoreRange bool
+
+	mutex   sync.Mutex
+	methods []string
+}
+
+// newTestWebDAVServer returns a new instance of the `testWebDAVServer` serving
+// under the "/dav/".
+func newTestWebDAVServer() *testWebDAVServer {
+	tws := &testWebDAVServer{fs: webdav.NewMemFS()}
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: tws.fs,
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	tws.Server = httptest.NewServer(http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			tws.mutex.Lock()
+			tws.methods = append(tws.methods, r.Method)
+			ignoreRange := tws.ignoreRange
+			tws.mutex.Unlock()
+
+			if r.Header.Get("Authorization") != "Bearer token" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			name := strings.TrimPrefix(r.URL.Path, "/dav")
+			if r.Method == http.MethodPut {
+				_, err := tws.fs.Stat(
+					r.Context(),
+					path.Dir(name),
+				)
+				if os.IsNotExist(err) {
+					rw.WriteHeader(http.StatusConflict)
+					return
+				}
+			}
+
+			if ignoreRange {
+				r.Header.Del("Range")
+			}
+
+			handler.ServeHTTP(rw, r)
+		},
+	))
+
+	return tws
+}
+
+// countMethod returns the number of the requests of the method received by the
+// tws, and resets the record.
+func (tws *testWebDAVServer) countMethod(method string) int {
+	tws.mutex.Lock()
+	defer tws.mutex.Unlock()
+
+	n := 0
+	for _, m := range tws.methods {
+		if m == method {
+			n++
+		}
+	}
+
+	tws.methods = nil
+
+	return n
+}
+
+func TestWebDAV(t *testing.T) {
+	tws := newTestWebDAVServer()
+	defer tws.Close()
+
+	w := &WebDAV{BaseURL: tws.URL + "/dav", BearerToken: "token"}
+
+	// The missing parent collections are created from the outermost one
+	// on 409: the "@v/" and the "foo/" fail first, then the
+	// "example.com/", the "foo/", and the "@v/" succeed.
+	content := []byte(`{"Version":"v1.0.0"}`)
+	assert.NoError(t, w.SetCache(
+		context.Background(),
+		newTestCache("example.com/foo/@v/v1.0.0.info", content),
+	))
+	assert.Equal(t, 5, tws.countMethod("MKCOL"))
+
+	assert.NoError(t, w.SetCache(
+		context.Background(),
+		newTestCache("example.com/foo/@v/v1.0.0.mod", []byte("module")),
+	))
+	assert.Equal(t, 0, tws.countMethod("MKCOL"))
+
+	// The metadata comes from the sidecar and the HEAD.
+	c, err := w.Cache(
+		context.Background(),
+		"example.com/foo/@v/v1.0.0.info",
+	)
+	assert.NoError(t, err)
+	checksum := md5.Sum(content)
+	assert.Equal(t, "example.com/foo/@v/v1.0.0.info", c.Name())
+	assert.Equal(t, "application/octet-stream", c.MIMEType())
+	assert.Equal(t, int64(len(content)), c.Size())
+	assert.Equal(t, checksum[:], c.Checksum())
+	assert.False(t, c.ModTime().IsZero())
+
+	b, err := ioutil.ReadAll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, content, b)
+	assert.NoError(t, c.Close())
+
+	_, err = w.Cache(context.Background(), "example.com/foo/@v/v2.0.0.info")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	// A content without its sidecar is not found.
+	f, err := tws.fs.OpenFile(
+		context.Background(),
+		"/example.com/foo/@v/v3.0.0.info",
+		os.O_WRONLY|os.O_CREATE,
+		0644,
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	_, err = w.Cache(context.Background(), "example.com/foo/@v/v3.0.0.info")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	w.BearerToken = "wrong"
+	_, err = w.Cache(context.Background(), "example.com/foo/@v/v1.0.0.info")
+	assert.EqualError(
+		t,
+		err,
+		"GET "+tws.URL+"/dav/example.com/foo/@v/v1.0.0.info.metadata: "+
+			"401 Unauthorized",
+	)
+	w.BearerToken = "token"
+}
+
+func TestWebDAVIgnoredRange(t *testing.T) {
+	tws := newTestWebDAVServer()
+	defer tws.Close()
+
+	w := &WebDAV{BaseURL: tws.URL + "/dav/", BearerToken: "token"}
+
+	content := []byte("0123456789abcdef")
+	assert.NoError(t, w.SetCache(
+		context.Background(),
+		newTestCache("foo", content),
+	))
+
+	for _, ignoreRange := range []bool{false, true} {
+		tws.mutex.Lock()
+		tws.ignoreRange = ignoreRange
+		tws.mutex.Unlock()
+
+		c, err := w.Cache(context.Background(), "foo")
+		assert.NoError(t, err)
+
+		for _, offset := range []int64{10, 3, 15} {
+			_, err := c.Seek(offset, io.SeekStart)
+			assert.NoError(t, err)
+
+			b := make([]byte, 1)
+			_, err = io.ReadFull(c, b)
+			assert.NoError(t, err)
+			assert.Equal(t, content[offset:offset+1], b)
+		}
+
+		_, err = c.Seek(4, io.SeekStart)
+		assert.NoError(t, err)
+
+		b, err := ioutil.ReadAll(c)
+		assert.NoError(t, err)
+		assert.Equal(t, content[4:], b)
+
+		assert.NoError(t, c.Close())
+	}
+}
+
+func TestWebDAVCaches(t *testing.T) {
+	tws := newTestWebDAVServer()
+	defer tws.Close()
+
+	w := &WebDAV{BaseURL: tws.URL + "/dav", BearerToken: "token"}
+
+	names := []string{
+		"example.com/bar/@v/list",
+		"example.com/foo/@v/v1.0.0.info",
+		"example.com/foo/@v/v1.0.0.mod",
+		"example.com/foo/@v/v1.1.0.info",
+		"example.com/foobar/@v/v1.0.0.info",
+	}
+	for _, name := range names {
+		assert.NoError(t, w.SetCache(
+			context.Background(),
+			newTestCache(name, []byte(name)),
+		))
+	}
+
+	// The sidecars are hidden, and the collections are walked one
+	// PROPFIND at a time.
+	got, err := w.Caches(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, names, got)
+	assert.Equal(t, 8, tws.countMethod("PROPFIND"))
+
+	got, err = w.Caches(context.Background(), "example.com/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, names[1:], got)
+
+	got, err = w.Caches(context.Background(), "example.com/foo/@v/v1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, names[1:3], got)
+
+	got, err = w.Caches(context.Background(), "example.com/baz/")
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+
+	assert.NoError(t, w.DeleteCache(
+		context.Background(),
+		"example.com/foo/@v/v1.0.0.mod",
+	))
+	assert.Equal(
+		t,
+		goproxy.ErrCacheNotFound,
+		w.DeleteCache(
+			context.Background(),
+			"example.com/foo/@v/v1.0.0.mod",
+		),
+	)
+
+	_, err = w.Cache(context.Background(), "example.com/foo/@v/v1.0.0.mod")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	got, err = w.Caches(context.Background(), "example.com/foo/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{names[1], names[3]}, got)
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/goproxy/goproxy"
+	"github.com/goproxy/goproxy/auditor"
+	"github.com/goproxy/goproxy/cacher"
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is the prefix of the environment variables that override the
+// config. The rest of the name is the path of the overridden key, in upper
+// case, with its parts separated by "__". For example, the
+// "GOPROXY_CONFIG_GOPROXY__CACHER__ROOT" overrides the "root" of the "cacher"
+// of the "goproxy".
+const envPrefix = "GOPROXY_CONFIG_"
+
+// config is the config of the command.
+type config struct {
+	// Address is the TCP address to listen on.
+	//
+	// Default value: ":8080"
+	Address string `mapstructure:"address"`
+
+	// TLSCertFile is the certificate file to serve HTTPS. It must be set
+	// together with the `TLSKeyFile`.
+	//
+	// Default value: ""
+	TLSCertFile string `mapstructure:"tls_cert_file"`
+
+	// TLSKeyFile is the private key file to serve HTTPS. It must be set
+	// together with the `TLSCertFile`.
+	//
+	// Default value: ""
+	TLSKeyFile string `mapstructure:"tls_key_file"`
+
+	// ShutdownTimeout is the maximum duration of the graceful shutdown.
+	//
+	// Default value: 30s
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+
+	// LogFormat is the format of the log. It is one of the "text" and the
+	// "json".
+	//
+	// Default value: "text"
+	LogFormat string `mapstructure:"log_format"`
+
+	// LogLevel is the minimum level of the log. It is one of the "debug",
+	// the "info", the "warn", and the "error".
+	//
+	// Default value: "info"
+	LogLevel string `mapstructure:"log_level"`
+
+	// MetricsPath is the path to serve the metrics. The metrics are not
+	// served if it is empty.
+	//
+	// Default value: ""
+	MetricsPath string `mapstructure:"metrics_path"`
+
+	// Health indicates whether to serve the "/healthz" and the "/readyz".
+	//
+	// Default value: false
+	Health bool `mapstructure:"health"`
+
+	// Admin is the administration API. It is not served if its
+	// `PathPrefix` is empty.
+	Admin struct {
+		PathPrefix string `mapstructure:"path_prefix"`
+		Username   string `mapstructure:"username"`
+		Password   string `mapstructure:"password"`
+		Token      string `mapstructure:"token"`
+	} `mapstructure:"admin"`
+
+	// Goproxy is the config of the `goproxy.Goproxy`. Its "cacher" and
+	// "auditor", as well as the cachers nested in them, such as the ones of
+	// the "tiers" of a "tiered" cacher, have a "type" that selects the
+	// implementation. The entries of its "go_bin_env" are appended to the
+	// environment.
+	Goproxy map[string]interface{} `mapstructure:"goproxy"`
+}
+
+// cacherTypes is the constructors of the `goproxy.Cacher` keyed by the type
+// name.
+var cacherTypes = map[string]func() goproxy.Cacher{
+	"disk":   func() goproxy.Cacher { return &cacher.Disk{} },
+	"minio":  func() goproxy.Cacher { return &cacher.MinIO{} },
+	"s3":     func() goproxy.Cacher { return &cacher.S3{} },
+	"gcs":    func() goproxy.Cacher { return &cacher.GCS{} },
+	"oss":    func() goproxy.Cacher { return &cacher.OSS{} },
+	"dos":    func() goproxy.Cacher { return &cacher.DOS{} },
+	"kodo":   func() goproxy.Cacher { return &cacher.Kodo{} },
+	"mabs":   func() goproxy.Cacher { return &cacher.MABS{} },
+	"webdav": func() goproxy.Cacher { return &cacher.WebDAV{} },
+	"memory": func() goproxy.Cacher { return &cacher.Memory{} },
+	"bolt":   func() goproxy.Cacher { return &cacher.Bolt{} },
+	"tiered": func() goproxy.Cacher { return &cacher.Tiered{} },
+	"dedup":  func() goproxy.Cacher { return &cacher.Dedup{} },
+	"replicated": func() goproxy.Cacher {
+		return &cacher.Replicated{}
+	},
+	"encrypted": func() goproxy.Cacher {
+		return &cacher.Encrypted{}
+	},
+	"compressed": func() goproxy.Cacher {
+		return &cacher.Compressed{}
+	},
+}
+
+// auditorTypes is the constructors of the `goproxy.Auditor` keyed by the type
+// name.
+var auditorTypes = map[string]func() goproxy.Auditor{
+	"file": func() goproxy.Auditor { return &auditor.File{} },
+	"rotating_file": func() goproxy.Auditor {
+		return &auditor.RotatingFile{}
+	},
+}
+
+// logLevels is the `goproxy.LogLevel`s keyed by the name.
+var logLevels = map[string]goproxy.LogLevel{
+	"debug": goproxy.LogLevelDebug,
+	"info":  goproxy.LogLevelInfo,
+	"warn":  goproxy.LogLevelWarn,
+	"error": goproxy.LogLevelError,
+}
+
+// loadConfig loads the config from the file targeted by the filename, if not
+// empty, and then from the environ.
+func loadConfig(filename string, environ []string) (*config, error) {
+	raw := map[string]interface{}{}
+	if filename != "" {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+		case ".yaml", ".yml":
+			var v interface{}
+			if err := yaml.Unmarshal(b, &v); err != nil {
+				return nil, err
+			}
+
+			if m, ok := stringKeys(v).(map[string]interface{}); ok {
+				raw = m
+			} else if v != nil {
+				return nil, errors.New(
+					"config must be a mapping",
+				)
+			}
+		case ".toml":
+			if err := toml.Unmarshal(b, &raw); err != nil {
+				return nil, err
+			}
+		case ".json":
+			if err := json.Unmarshal(b, &raw); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf(
+				"unsupported config format: %q",
+				ext,
+			)
+		}
+	}
+
+	for _, env := range environ {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], envPrefix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(parts[0], envPrefix)
+		keys := strings.Split(strings.ToLower(key), "__")
+		if err := setRawValue(raw, keys, parts[1]); err != nil {
+			return nil, fmt.Errorf("%s: %v", parts[0], err)
+		}
+	}
+
+	c := &config{
+		Address:         ":8080",
+		ShutdownTimeout: 30 * time.Second,
+		LogFormat:       "text",
+		LogLevel:        "info",
+	}
+	if err := decode(raw, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// validate validates the c.
+func (c *config) validate() error {
+	if c.Address == "" {
+		return errors.New("address must not be empty")
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New(
+			"tls_cert_file and tls_key_file must be set together",
+		)
+	}
+
+	for _, filename := range []string{c.TLSCertFile, c.TLSKeyFile} {
+		if filename == "" {
+			continue
+		}
+
+		if _, err := os.Stat(filename); err != nil {
+			return err
+		}
+	}
+
+	if c.ShutdownTimeout < 0 {
+		return errors.New("shutdown_timeout must not be negative")
+	}
+
+	switch c.LogFormat {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unknown log_format: %q", c.LogFormat)
+	}
+
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		return fmt.Errorf("unknown log_level: %q", c.LogLevel)
+	}
+
+	for name, p := range map[string]string{
+		"metrics_path":      c.MetricsPath,
+		"admin.path_prefix": c.Admin.PathPrefix,
+	} {
+		if p != "" && !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("%s must start with \"/\"", name)
+		}
+	}
+
+	if c.Admin.PathPrefix != "" {
+		if (c.Admin.Username == "") != (c.Admin.Password == "") {
+			return errors.New(
+				"admin requires both username and password",
+			)
+		}
+
+		if c.Admin.Token == "" && c.Admin.Username == "" {
+			return errors.New(
+				"admin requires token or username and password",
+			)
+		}
+	}
+
+	return nil
+}
+
+// newGoproxy returns a new instance of the `goproxy.Goproxy` from the c.
+func (c *config) newGoproxy() (*goproxy.Goproxy, error) {
+	raw := make(map[string]interface{}, len(c.Goproxy))
+	for k, v := range c.Goproxy {
+		raw[k] = v
+	}
+
+	goBinEnv := raw["go_bin_env"]
+	delete(raw, "go_bin_env")
+
+	g := goproxy.New()
+	if err := decode(raw, g); err != nil {
+		return nil, fmt.Errorf("goproxy: %v", err)
+	}
+
+	if goBinEnv != nil {
+		var extraGoBinEnv []string
+		if err := decode(goBinEnv, &extraGoBinEnv); err != nil {
+			return nil, fmt.Errorf("goproxy.go_bin_env: %v", err)
+		}
+
+		g.GoBinEnv = append(g.GoBinEnv, extraGoBinEnv...)
+	}
+
+	if g.PathPrefix != "" && !strings.HasPrefix(g.PathPrefix, "/") {
+		return nil, errors.New(
+			"goproxy.path_prefix must start with \"/\"",
+		)
+	}
+
+	if _, err := exec.LookPath(g.GoBinName); err != nil {
+		return nil, fmt.Errorf("goproxy.go_bin_name: %v", err)
+	}
+
+	return g, nil
+}
+
+// typedHookFunc returns a `mapstructure.DecodeHookFunc` that creates the
+// `goproxy.Cacher`s and the `goproxy.Auditor`s from their raw configs by using
+// the `newTyped`, and validates their required fields.
+func typedHookFunc() mapstructure.DecodeHookFuncType {
+	cacherType := reflect.TypeOf((*goproxy.Cacher)(nil)).Elem()
+	auditorType := reflect.TypeOf((*goproxy.Auditor)(nil)).Elem()
+	return func(
+		from reflect.Type,
+		to reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		raw, ok := data.(map[string]interface{})
+		if !ok {
+			return data, nil
+		}
+
+		switch to {
+		case cacherType:
+			v, err := newTyped(raw, func(typ string) interface{} {
+				if newCacher, ok := cacherTypes[typ]; ok {
+					return newCacher()
+				}
+
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			if err := validateCacher(
+				v.(goproxy.Cacher),
+			); err != nil {
+				return nil, err
+			}
+
+			return v, nil
+		case auditorType:
+			v, err := newTyped(raw, func(typ string) interface{} {
+				if newAuditor, ok := auditorTypes[typ]; ok {
+					return newAuditor()
+				}
+
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			if err := validateAuditor(
+				v.(goproxy.Auditor),
+			); err != nil {
+				return nil, err
+			}
+
+			return v, nil
+		}
+
+		return data, nil
+	}
+}
+
+// validateCacher validates the required fields of the c. The cachers nested in
+// the c are validated when they are decoded.
+func validateCacher(c goproxy.Cacher) error {
+	var required map[string]string
+	switch c := c.(type) {
+	case *cacher.Disk:
+		required = map[string]string{"root": c.Root}
+	case *cacher.MinIO:
+		required = map[string]string{
+			"endpoint":    c.Endpoint,
+			"bucket_name": c.BucketName,
+		}
+	case *cacher.S3:
+		required = map[string]string{"bucket_name": c.BucketName}
+	case *cacher.GCS:
+		required = map[string]string{"bucket_name": c.BucketName}
+	case *cacher.OSS:
+		required = map[string]string{"bucket_name": c.BucketName}
+	case *cacher.DOS:
+		required = map[string]string{"space_name": c.SpaceName}
+	case *cacher.Kodo:
+		required = map[string]string{"bucket_name": c.BucketName}
+	case *cacher.MABS:
+		required = map[string]string{
+			"account_name":     c.AccountName,
+			"bucket_container": c.ContainerName,
+		}
+	case *cacher.WebDAV:
+		required = map[string]string{"base_url": c.BaseURL}
+	case *cacher.Bolt:
+		required = map[string]string{"filename": c.Filename}
+	case *cacher.Tiered:
+		if len(c.Tiers) == 0 {
+			return errors.New("tiers must not be empty")
+		}
+
+		for i, tier := range c.Tiers {
+			if tier == nil || tier.Cacher == nil {
+				return fmt.Errorf(
+					"tiers[%d]: cacher is required",
+					i,
+				)
+			}
+		}
+	case *cacher.Replicated:
+		if len(c.Replicas) == 0 {
+			return errors.New("replicas must not be empty")
+		}
+
+		for i, replica := range c.Replicas {
+			if replica == nil {
+				return fmt.Errorf(
+					"replicas[%d] must not be empty",
+					i,
+				)
+			}
+		}
+
+		if c.Quorum < 0 || c.Quorum > len(c.Replicas) {
+			return fmt.Errorf(
+				"quorum must be between 0 and %d",
+				len(c.Replicas),
+			)
+		}
+	case *cacher.Dedup:
+		if c.Cacher == nil {
+			return errors.New("cacher is required")
+		}
+	case *cacher.Compressed:
+		if c.Cacher == nil {
+			return errors.New("cacher is required")
+		}
+
+		switch c.Encoding {
+		case "", "gzip", "zstd":
+		default:
+			return fmt.Errorf("unknown encoding: %q", c.Encoding)
+		}
+	case *cacher.Encrypted:
+		if c.Cacher == nil {
+			return errors.New("cacher is required")
+		}
+
+		return validateEncryptionKeys(c.Keys, c.KeyID)
+	}
+
+	for _, name := range sortedKeys(required) {
+		if required[name] == "" {
+			return fmt.Errorf("%s must not be empty", name)
+		}
+	}
+
+	return nil
+}
+
+// validateEncryptionKeys validates the keys and the keyID of a
+// `cacher.Encrypted`.
+func validateEncryptionKeys(keys map[string]string, keyID string) error {
+	if len(keys) == 0 {
+		return errors.New("keys must not be empty")
+	}
+
+	for _, id := range sortedKeys(keys) {
+		key, err := base64.StdEncoding.DecodeString(keys[id])
+		if err != nil {
+			return fmt.Errorf("keys.%s: %v", id, err)
+		}
+
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return fmt.Errorf(
+				"keys.%s must be 16, 24, or 32 bytes, got %d",
+				id,
+				len(key),
+			)
+		}
+	}
+
+	if _, ok := keys[keyID]; !ok {
+		return fmt.Errorf("unknown key_id: %q", keyID)
+	}
+
+	return nil
+}
+
+// validateAuditor validates the required fields of the a.
+func validateAuditor(a goproxy.Auditor) error {
+	var filename string
+	switch a := a.(type) {
+	case *auditor.File:
+		filename = a.Filename
+	case *auditor.RotatingFile:
+		if a.MaxBytes < 0 || a.MaxBackups < 0 {
+			return errors.New("max_bytes and max_backups " +
+				"must not be negative")
+		}
+
+		filename = a.Filename
+	}
+
+	if filename == "" {
+		return errors.New("filename must not be empty")
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys of the m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// newTyped returns a new instance created by the newByType for the "type" of
+// the raw, with the rest of the raw decoded into it.
+func newTyped(
+	raw map[string]interface{},
+	newByType func(typ string) interface{},
+) (interface{}, error) {
+	typ, _ := raw["type"].(string)
+	if typ == "" {
+		return nil, errors.New("type must not be empty")
+	}
+
+	v := newByType(strings.ToLower(typ))
+	if v == nil {
+		return nil, fmt.Errorf("unknown type: %q", typ)
+	}
+
+	rest := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		if k != "type" {
+			rest[k] = v
+		}
+	}
+
+	if err := decode(rest, v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// decode decodes the input into the output, rejecting unknown keys.
+func decode(input, output interface{}) error {
+	d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+			typedHookFunc(),
+		),
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           output,
+	})
+	if err != nil {
+		return err
+	}
+
+	return d.Decode(input)
+}
+
+// setRawValue sets the value to the raw at the path of the keys, creating the
+// intermediate mappings as needed.
+func setRawValue(
+	raw map[string]interface{},
+	keys []string,
+	value string,
+) error {
+	for i, key := range keys {
+		if key == "" {
+			return errors.New("empty key")
+		}
+
+		if i == len(keys)-1 {
+			raw[key] = value
+			break
+		}
+
+		next, ok := raw[key].(map[string]interface{})
+		if !ok {
+			if raw[key] != nil {
+				return fmt.Errorf(
+					"%s is not a mapping",
+					strings.Join(keys[:i+1], "."),
+				)
+			}
+
+			next = map[string]interface{}{}
+			raw[key] = next
+		}
+
+		raw = next
+	}
+
+	return nil
+}
+
+// stringKeys converts the mappings in the v, as unmarshaled by the YAML, to
+// have string keys.
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, v := range v {
+			m[fmt.Sprint(k)] = stringKeys(v)
+		}
+
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = stringKeys(v[i])
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goproxy/goproxy/auditor"
+	"github.com/goproxy/goproxy/cacher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"config.yaml": `
+address: ":9090"
+shutdown_timeout: 5s
+goproxy:
+  max_zip_cache_bytes: 1024
+  quarantine_period: 1h
+  cacher:
+    type: disk
+    root: /tmp/goproxy
+`,
+		"config.toml": `
+address = ":9090"
+shutdown_timeout = "5s"
+
+[goproxy]
+max_zip_cache_bytes = 1024
+quarantine_period = "1h"
+
+[goproxy.cacher]
+type = "disk"
+root = "/tmp/goproxy"
+`,
+		"config.json": `{
+	"address": ":9090",
+	"shutdown_timeout": "5s",
+	"goproxy": {
+		"max_zip_cache_bytes": 1024,
+		"quarantine_period": "1h",
+		"cacher": {"type": "disk", "root": "/tmp/goproxy"}
+	}
+}`,
+	} {
+		filename := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(
+			filename,
+			[]byte(content),
+			0600,
+		))
+
+		c, err := loadConfig(filename, []string{
+			"GOPROXY_CONFIG_LOG_FORMAT=json",
+			"GOPROXY_CONFIG_GOPROXY__CACHER__ROOT=/tmp/env",
+			"GOPROXY_CONFIG_GOPROXY__AUDITOR__TYPE=file",
+			"GOPROXY_CONFIG_GOPROXY__AUDITOR__FILENAME=/tmp/audit",
+			"GOPROXY=off",
+		})
+		assert.NoError(t, err, name)
+		assert.NoError(t, c.validate(), name)
+		assert.Equal(t, ":9090", c.Address, name)
+		assert.Equal(t, 5*time.Second, c.ShutdownTimeout, name)
+		assert.Equal(t, "json", c.LogFormat, name)
+		assert.Equal(t, "info", c.LogLevel, name)
+
+		g, err := c.newGoproxy()
+		assert.NoError(t, err, name)
+		assert.Equal(t, 1024, g.MaxZIPCacheBytes, name)
+		assert.Equal(t, time.Hour, g.QuarantinePeriod, name)
+		assert.Equal(t, &cacher.Disk{Root: "/tmp/env"}, g.Cacher, name)
+		assert.Equal(
+			t,
+			&auditor.File{Filename: "/tmp/audit"},
+			g.Auditor,
+			name,
+		)
+	}
+
+	_, err = loadConfig(filepath.Join(dir, "config.ini"), nil)
+	assert.Error(t, err)
+
+	_, err = loadConfig("", []string{"GOPROXY_CONFIG_FOOBAR=1"})
+	assert.Error(t, err)
+}
+
+func TestLoadConfigTiered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(`
+goproxy:
+  cacher:
+    type: tiered
+    tiers:
+      - cacher:
+          type: disk
+          root: /tmp/goproxy-ssd
+        max_cache_bytes: 1048576
+        name_exts: [.info, .mod]
+      - cacher:
+          type: s3
+          bucket_name: goproxy
+        required: true
+`), 0600))
+
+	c, err := loadConfig(filename, nil)
+	assert.NoError(t, err)
+
+	g, err := c.newGoproxy()
+	assert.NoError(t, err)
+	assert.Equal(t, &cacher.Tiered{
+		Tiers: []*cacher.Tier{
+			{
+				Cacher: &cacher.Disk{
+					Root: "/tmp/goproxy-ssd",
+				},
+				MaxCacheBytes: 1 << 20,
+				NameExts:      []string{".info", ".mod"},
+			},
+			{
+				Cacher:   &cacher.S3{BucketName: "goproxy"},
+				Required: true,
+			},
+		},
+	}, g.Cacher)
+
+	c.Goproxy["cacher"] = map[string]interface{}{
+		"type": "tiered",
+		"tiers": []interface{}{
+			map[string]interface{}{
+				"cacher": map[string]interface{}{
+					"type": "disk",
+				},
+			},
+		},
+	}
+	_, err = c.newGoproxy()
+	assert.EqualError(
+		t,
+		err,
+		"goproxy: 1 error(s) decoding:\n\n"+
+			"* error decoding 'cacher': 1 error(s) decoding:\n\n"+
+			"* error decoding 'tiers[0].cacher': "+
+			"root must not be empty",
+	)
+}
+
+func TestLoadConfigMinIOOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(`
+goproxy:
+  cacher:
+    type: s3
+    bucket_name: goproxy
+    region: eu-central-1
+    bucket_lookup: path
+    storage_class: STANDARD_IA
+    server_side_encryption: SSE-KMS
+    sse_kms_key_id: alias/goproxy
+    tags:
+      team: platform
+    ca_file: /etc/goproxy/ca.pem
+    part_size: 16777216
+    concurrency: 8
+    credential_providers: [env, iam]
+`), 0600))
+
+	c, err := loadConfig(filename, nil)
+	assert.NoError(t, err)
+
+	g, err := c.newGoproxy()
+	assert.NoError(t, err)
+	assert.Equal(t, &cacher.S3{
+		BucketName: "goproxy",
+		MinIOOptions: cacher.MinIOOptions{
+			Region:               "eu-central-1",
+			BucketLookup:         "path",
+			StorageClass:         "STANDARD_IA",
+			ServerSideEncryption: "SSE-KMS",
+			SSEKMSKeyID:          "alias/goproxy",
+			Tags:                 map[string]string{"team": "platform"},
+			CAFile:               "/etc/goproxy/ca.pem",
+			PartSize:             16 << 20,
+			Concurrency:          8,
+			CredentialProviders:  []string{"env", "iam"},
+		},
+	}, g.Cacher)
+}
+
+func TestConfigValidate(t *testing.T) {
+	c, err := loadConfig("", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, c.validate())
+
+	c.TLSCertFile = "cert.pem"
+	assert.Error(t, c.validate())
+
+	c, _ = loadConfig("", []string{"GOPROXY_CONFIG_LOG_LEVEL=verbose"})
+	assert.Error(t, c.validate())
+
+	c, _ = loadConfig("", []string{"GOPROXY_CONFIG_ADMIN__PATH_PREFIX=/a"})
+	assert.Error(t, c.validate())
+
+	c, _ = loadConfig("", []string{
+		"GOPROXY_CONFIG_ADMIN__PATH_PREFIX=/a",
+		"GOPROXY_CONFIG_ADMIN__USERNAME=admin",
+	})
+	assert.EqualError(
+		t,
+		c.validate(),
+		"admin requires both username and password",
+	)
+
+	c, _ = loadConfig("", []string{
+		"GOPROXY_CONFIG_ADMIN__PATH_PREFIX=/a",
+		"GOPROXY_CONFIG_ADMIN__USERNAME=admin",
+		"GOPROXY_CONFIG_ADMIN__PASSWORD=secret",
+	})
+	assert.NoError(t, c.validate())
+
+	c, _ = loadConfig("", []string{
+		"GOPROXY_CONFIG_GOPROXY__CACHER__TYPE=foobar",
+	})
+	_, err = c.newGoproxy()
+	assert.Error(t, err)
+
+	c, _ = loadConfig("", []string{
+		"GOPROXY_CONFIG_GOPROXY__CACHER__TYPE=disk",
+	})
+	_, err = c.newGoproxy()
+	assert.Error(t, err)
+
+	c, _ = loadConfig("", []string{
+		"GOPROXY_CONFIG_GOPROXY__GO_BIN_NAME=goproxy-no-such-go",
+	})
+	_, err = c.newGoproxy()
+	assert.Error(t, err)
+}
+
+func TestConfigValidateTyped(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	disk := map[string]interface{}{"type": "disk", "root": "/tmp/goproxy"}
+	s3 := map[string]interface{}{"type": "s3"}
+	required := map[string]interface{}{"required": true}
+
+	for _, tc := range []struct {
+		cacher  map[string]interface{}
+		auditor map[string]interface{}
+		err     string
+	}{
+		{cacher: disk},
+		{cacher: map[string]interface{}{"type": "disk"}, err: "root"},
+		{
+			cacher: map[string]interface{}{
+				"type":        "minio",
+				"bucket_name": "goproxy",
+			},
+			err: "endpoint must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":     "minio",
+				"endpoint": "https://minio.example.com",
+			},
+			err: "bucket_name must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":        "s3",
+				"bucket_name": "goproxy",
+			},
+		},
+		{cacher: s3, err: "bucket_name must not be empty"},
+		{
+			cacher: map[string]interface{}{"type": "gcs"},
+			err:    "bucket_name must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{"type": "dos"},
+			err:    "space_name must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":         "mabs",
+				"account_name": "goproxy",
+			},
+			err: "bucket_container must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{"type": "webdav"},
+			err:    "base_url must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{"type": "bolt"},
+			err:    "filename must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{"type": "tiered"},
+			err:    "tiers must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type": "tiered",
+				"tiers": []interface{}{
+					map[string]interface{}{"cacher": disk},
+					required,
+				},
+			},
+			err: "tiers[1]: cacher is required",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type": "tiered",
+				"tiers": []interface{}{
+					map[string]interface{}{"cacher": s3},
+				},
+			},
+			err: "bucket_name must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{"type": "replicated"},
+			err:    "replicas must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":     "replicated",
+				"replicas": []interface{}{disk},
+				"quorum":   2,
+			},
+			err: "quorum must be between 0 and 1",
+		},
+		{
+			cacher: map[string]interface{}{"type": "dedup"},
+			err:    "cacher is required",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":     "compressed",
+				"cacher":   disk,
+				"encoding": "br",
+			},
+			err: `unknown encoding: "br"`,
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":   "encrypted",
+				"cacher": disk,
+				"keys":   map[string]interface{}{"k1": key},
+				"key_id": "k1",
+			},
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":   "encrypted",
+				"keys":   map[string]interface{}{"k1": key},
+				"key_id": "k1",
+			},
+			err: "cacher is required",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":   "encrypted",
+				"cacher": disk,
+			},
+			err: "keys must not be empty",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":   "encrypted",
+				"cacher": disk,
+				"keys":   map[string]interface{}{"k1": "!"},
+				"key_id": "k1",
+			},
+			err: "keys.k1: illegal base64 data",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":   "encrypted",
+				"cacher": disk,
+				"keys": map[string]interface{}{
+					"k1": base64.StdEncoding.EncodeToString(
+						make([]byte, 8),
+					),
+				},
+				"key_id": "k1",
+			},
+			err: "keys.k1 must be 16, 24, or 32 bytes, got 8",
+		},
+		{
+			cacher: map[string]interface{}{
+				"type":   "encrypted",
+				"cacher": disk,
+				"keys":   map[string]interface{}{"k1": key},
+				"key_id": "k2",
+			},
+			err: `unknown key_id: "k2"`,
+		},
+		{
+			auditor: map[string]interface{}{"type": "file"},
+			err:     "filename must not be empty",
+		},
+		{
+			auditor: map[string]interface{}{
+				"type":        "rotating_file",
+				"filename":    "/tmp/goproxy-audit.log",
+				"max_backups": -1,
+			},
+			err: "must not be negative",
+		},
+	} {
+		c, err := loadConfig("", nil)
+		assert.NoError(t, err)
+
+		c.Goproxy = map[string]interface{}{}
+		if tc.cacher != nil {
+			c.Goproxy["cacher"] = tc.cacher
+		}
+
+		if tc.auditor != nil {
+			c.Goproxy["auditor"] = tc.auditor
+		}
+
+		_, err = c.newGoproxy()
+		if tc.err == "" {
+			assert.NoError(t, err)
+		} else if assert.Error(t, err, tc.err) {
+			assert.Contains(t, err.Error(), tc.err)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Command goproxy serves a `goproxy.Goproxy` configured by a YAML, TOML, or
+// JSON file plus environment variables.
+//
+// Usage:
+//
+//	goproxy [-config <file>]
+//
+// The format of the config file is chosen by its extension. Each key of the
+// config can be overridden by an environment variable named with the
+// "GOPROXY_CONFIG_" prefix followed by the path of the key in upper case, with
+// its parts separated by "__", such as the "GOPROXY_CONFIG_ADDRESS" and the
+// "GOPROXY_CONFIG_GOPROXY__CACHER__ROOT".
+//
+// On SIGHUP, the config is loaded again and its "goproxy" is applied without
+// interrupting the requests in flight. The other keys are only applied on
+// restart.
+//
+// An example config in YAML:
+//
+//	address: ":8080"
+//	shutdown_timeout: 30s
+//	log_format: json
+//	metrics_path: /metrics
+//	health: true
+//	goproxy:
+//	  path_prefix: /proxy
+//	  go_bin_env:
+//	    - GOPROXY=https://proxy.golang.org,direct
+//	  cacher:
+//	    type: disk
+//	    root: /var/cache/goproxy
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/goproxy/goproxy"
+)
+
+func main() {
+	configFilename := flag.String("config", "", "config file")
+	flag.Parse()
+
+	c, err := loadConfig(*configFilename, os.Environ())
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	if err := c.validate(); err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+
+	g, err := c.newGoproxy()
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+
+	if err := run(*configFilename, c, g); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the g as configured by the c until an interrupt or a termination
+// signal is received, and then shuts down gracefully. The g is reloaded from
+// the file targeted by the configFilename on each hangup signal.
+func run(configFilename string, c *config, g *goproxy.Goproxy) error {
+	switch c.LogFormat {
+	case "json":
+		g.Logger = &goproxy.JSONLogger{
+			Writer: os.Stderr,
+			Level:  logLevels[c.LogLevel],
+		}
+	default:
+		g.Logger = &goproxy.StdLogger{Level: logLevels[c.LogLevel]}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", g)
+
+	if c.MetricsPath != "" {
+		g.Metrics = &goproxy.Metrics{}
+		mux.Handle(c.MetricsPath, g.Metrics)
+	}
+
+	if c.Health {
+		h := &goproxy.Health{Goproxy: g}
+		mux.Handle("/healthz", h)
+		mux.Handle("/readyz", h)
+	}
+
+	if c.Admin.PathPrefix != "" {
+		mux.Handle(fmt.Sprint(c.Admin.PathPrefix, "/"), &goproxy.Admin{
+			Goproxy:    g,
+			PathPrefix: c.Admin.PathPrefix,
+			Username:   c.Admin.Username,
+			Password:   c.Admin.Password,
+			Token:      c.Admin.Token,
+		})
+	}
+
+	server := &http.Server{
+		Addr:    c.Address,
+		Handler: mux,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		var err error
+		if c.TLSCertFile != "" {
+			err = server.ListenAndServeTLS(
+				c.TLSCertFile,
+				c.TLSKeyFile,
+			)
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		errChan <- err
+	}()
+
+	g.Logger.Log(
+		goproxy.LogLevelInfo,
+		"serving",
+		"address",
+		c.Address,
+		"tls",
+		c.TLSCertFile != "",
+	)
+
+	currentCacher, currentAuditor := g.Cacher, g.Auditor
+
+	// The replaced cachers and auditors are closed once the requests and
+	// the background jobs still using them have finished.
+	var retiring sync.WaitGroup
+	retire := func(c interface{}, drained <-chan struct{}) {
+		retiring.Add(1)
+		go func() {
+			defer retiring.Done()
+			<-drained
+			closeAll(g.Logger, c)
+		}()
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	for shuttingDown := false; !shuttingDown; {
+		select {
+		case err := <-errChan:
+			return err
+		case sig := <-signalChan:
+			if sig != syscall.SIGHUP {
+				g.Logger.Log(
+					goproxy.LogLevelInfo,
+					"shutting down",
+					"signal",
+					sig,
+				)
+				shuttingDown = true
+				break
+			}
+
+			rc, rg, drained, err := reload(
+				configFilename,
+				c,
+				currentCacher,
+				currentAuditor,
+				g,
+			)
+			if err != nil {
+				g.Logger.Log(
+					goproxy.LogLevelError,
+					"failed to reload",
+					"error",
+					err,
+				)
+				break
+			}
+
+			// Note that a replaced `cacher.Bolt` keeps the lock of
+			// its database file until it is closed, which the new
+			// one on the same file waits for up to its timeout.
+			if rg.Cacher != currentCacher {
+				retire(currentCacher, drained)
+			}
+
+			// Likewise, a replaced `auditor.RotatingFile` may still
+			// write to the same file until it is closed.
+			if rg.Auditor != currentAuditor {
+				retire(currentAuditor, drained)
+			}
+
+			c = rc
+			currentCacher, currentAuditor = rg.Cacher, rg.Auditor
+			g.Logger.Log(goproxy.LogLevelInfo, "reloaded")
+		}
+	}
+
+	ctx := context.Background()
+	if c.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.ShutdownTimeout)
+		defer cancel()
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	if err := g.Shutdown(ctx); err != nil {
+		g.Logger.Log(goproxy.LogLevelError, err.Error())
+	}
+
+	retired := make(chan struct{})
+	go func() {
+		retiring.Wait()
+		close(retired)
+	}()
+
+	select {
+	case <-retired:
+	case <-ctx.Done():
+		g.Logger.Log(
+			goproxy.LogLevelError,
+			"replaced cachers or auditors are still in use",
+		)
+	}
+
+	closeAll(g.Logger, currentAuditor, currentCacher)
+
+	return nil
+}
+
+// closeAll closes each of the cs that implements the `io.Closer`, and logs the
+// errors with the logger.
+func closeAll(logger goproxy.Logger, cs ...interface{}) {
+	for _, c := range cs {
+		if closer, ok := c.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Log(goproxy.LogLevelError, err.Error())
+			}
+		}
+	}
+}
+
+// reload reloads the g with the "goproxy" of the config loaded from the file
+// targeted by the configFilename and the environment. The loaded config is
+// validated before anything is applied to the g. The currentCacher and the
+// currentAuditor, created from the previous config c, are reused if the
+// "cacher" and the "auditor" of the loaded config are unchanged respectively,
+// so that they are never open twice at once. It returns the loaded config, the
+// config applied to the g, and the channel returned by the
+// `goproxy.Goproxy.Reload`.
+func reload(
+	configFilename string,
+	c *config,
+	currentCacher goproxy.Cacher,
+	currentAuditor goproxy.Auditor,
+	g *goproxy.Goproxy,
+) (*config, *goproxy.Goproxy, <-chan struct{}, error) {
+	rc, err := loadConfig(configFilename, os.Environ())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := rc.validate(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	rg, err := rc.newGoproxy()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if reflect.DeepEqual(rc.Goproxy["cacher"], c.Goproxy["cacher"]) {
+		rg.Cacher = currentCacher
+	}
+
+	if reflect.DeepEqual(rc.Goproxy["auditor"], c.Goproxy["auditor"]) {
+		rg.Auditor = currentAuditor
+	}
+
+	rg.Logger = g.Logger
+	rg.Metrics = g.Metrics
+
+	return rc, rg, g.Reload(rg), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goproxy/goproxy"
+	"github.com/goproxy/goproxy/auditor"
+	"github.com/goproxy/goproxy/cacher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.yaml")
+	writeConfig := func(content string) {
+		assert.NoError(t, ioutil.WriteFile(
+			filename,
+			[]byte(content),
+			0600,
+		))
+	}
+
+	boltConfig := `
+goproxy:
+  cacher:
+    type: bolt
+    filename: ` + filepath.Join(dir, "goproxy.db") + `
+    timeout: 100ms
+`
+	writeConfig(boltConfig)
+
+	c, err := loadConfig(filename, nil)
+	assert.NoError(t, err)
+
+	g, err := c.newGoproxy()
+	assert.NoError(t, err)
+	g.Logger = &goproxy.StdLogger{}
+
+	currentCacher := g.Cacher
+	defer currentCacher.(*cacher.Bolt).Close()
+
+	// The database file is locked by the current cacher.
+	_, err = currentCacher.Cache(context.Background(), "foo")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	// The current cacher is reused since its config is unchanged, so the
+	// reload does not wait for the lock of the database file.
+	rc, rg, _, err := reload(filename, c, currentCacher, g.Auditor, g)
+	assert.NoError(t, err)
+	assert.Equal(t, currentCacher, rg.Cacher)
+
+	_, err = rg.Cacher.Cache(context.Background(), "foo")
+	assert.Equal(t, goproxy.ErrCacheNotFound, err)
+
+	// An invalid config is rejected before being applied.
+	writeConfig(boltConfig + "address: \"\"\n")
+
+	_, _, _, err = reload(filename, rc, currentCacher, g.Auditor, g)
+	assert.EqualError(t, err, "address must not be empty")
+
+	writeConfig(`
+goproxy:
+  cacher:
+    type: memory
+`)
+
+	_, rg, drained, err := reload(
+		filename,
+		rc,
+		currentCacher,
+		g.Auditor,
+		g,
+	)
+	assert.NoError(t, err)
+	assert.IsType(t, &cacher.Memory{}, rg.Cacher)
+
+	// Nothing uses the previous config anymore, so its cacher can be
+	// closed at once.
+	select {
+	case <-drained:
+	default:
+		t.Error("previous config is not drained")
+	}
+}
+
+func TestReloadAuditor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.yaml")
+	writeConfig := func(maxBytes string) {
+		assert.NoError(t, ioutil.WriteFile(filename, []byte(`
+goproxy:
+  auditor:
+    type: rotating_file
+    filename: `+filepath.Join(dir, "audit.log")+`
+    max_bytes: `+maxBytes+`
+`), 0600))
+	}
+
+	writeConfig("1048576")
+
+	c, err := loadConfig(filename, nil)
+	assert.NoError(t, err)
+
+	g, err := c.newGoproxy()
+	assert.NoError(t, err)
+	g.Logger = &goproxy.StdLogger{}
+
+	currentAuditor := g.Auditor
+	assert.IsType(t, &auditor.RotatingFile{}, currentAuditor)
+
+	// The current auditor is reused since its config is unchanged, so
+	// there is only one of them rotating the file.
+	rc, rg, _, err := reload(filename, c, g.Cacher, currentAuditor, g)
+	assert.NoError(t, err)
+	assert.True(t, currentAuditor == rg.Auditor)
+
+	writeConfig("2097152")
+
+	_, rg, _, err = reload(filename, rc, g.Cacher, currentAuditor, g)
+	assert.NoError(t, err)
+	assert.False(t, currentAuditor == rg.Auditor)
+	assert.IsType(t, &auditor.RotatingFile{}, rg.Auditor)
+}
@@ -3,13 +3,15 @@ module github.com/goproxy/goproxy
 go 1.13
 
 require (
-	github.com/minio/minio-go/v6 v6.0.39
+	github.com/BurntSushi/toml v0.4.1
+	github.com/klauspost/compress v1.11.13
+	github.com/minio/minio-go/v6 v6.0.57
+	github.com/mitchellh/mapstructure v1.4.3
 	github.com/stretchr/testify v1.4.0
-	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550 // indirect
-	golang.org/x/mod v0.1.0
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/mod v0.4.2
 	golang.org/x/net v0.0.0-20191021144547-ec77196f6094
-	golang.org/x/sys v0.0.0-20191025090151-53bf42e6b339 // indirect
 	golang.org/x/text v0.3.2 // indirect
 	gopkg.in/ini.v1 v1.49.0 // indirect
-	gopkg.in/yaml.v2 v2.2.4 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -68,9 +68,16 @@ var regModuleVersionNotFound = regexp.MustCompile(
 // introduced in Go 1.13, so we implemented a built-in support for them. Now,
 // you can set them even before Go 1.13.
 //
+// In addition to the module proxy protocol, the `Goproxy` serves a JSON record
+// of where a module version came from at "/<module>/@v/<version>.provenance".
+// It is recorded when the module version is fetched and cached alongside its
+// module files. The VCS part of it is also added to the ".info" as the "Origin"
+// if the upstream did not provide one.
+//
 // It is highly recommended not to modify the value of any field of the
 // `Goproxy` after calling the `Goproxy.ServeHTTP`, which will cause
-// unpredictable problems.
+// unpredictable problems. Use the `Goproxy.Reload` to change the config of a
+// running `Goproxy` instead.
 //
 // The new instances of the `Goproxy` should only be created by calling the
 // `New`.
@@ -122,13 +129,152 @@ type Goproxy struct {
 	// Default value: 0
 	MaxZIPCacheBytes int `mapstructure:"max_zip_cache_bytes"`
 
+	// MaxCacheWriteWorkers is the maximum number of the module versions
+	// whose caches are allowed to be set to the `Cacher` at the same time.
+	//
+	// If the `MaxCacheWriteWorkers` is zero, then there will be no
+	// limitations.
+	//
+	// Default value: 0
+	MaxCacheWriteWorkers int `mapstructure:"max_cache_write_workers"`
+
+	// MaxCacheWriteQueueSize is the maximum number of the module versions
+	// waiting, retrying, or being set to the `Cacher`. Freshly downloaded
+	// module versions are not cached while the queue is full.
+	//
+	// If the `MaxCacheWriteQueueSize` is zero, then there will be no
+	// limitations.
+	//
+	// Default value: 0
+	MaxCacheWriteQueueSize int `mapstructure:"max_cache_write_queue_size"`
+
+	// MaxCacheWriteRetries is the maximum number of times that a failed
+	// cache write of a module version will be retried.
+	//
+	// Default value: 4
+	MaxCacheWriteRetries int `mapstructure:"max_cache_write_retries"`
+
+	// CacheWriteBackoff is the delay before the first retry of a
+	// failed cache write. It doubles for each subsequent retry.
+	//
+	// Default value: 1s
+	CacheWriteBackoff time.Duration `mapstructure:"cache_write_backoff"`
+
+	// CacheWriteSpoolDir is the local directory where pending cache writes
+	// are spooled. Spooled cache writes survive restarts, they are
+	// retried once the `Goproxy` is loaded again. A spooled cache write
+	// whose retries have run out is run again later, after a delay that
+	// keeps doubling up to an hour, and is dropped after 8 runs.
+	//
+	// If the `CacheWriteSpoolDir` is empty, pending cache writes are lost
+	// on exit.
+	//
+	// Default value: ""
+	CacheWriteSpoolDir string `mapstructure:"cache_write_spool_dir"`
+
+	// PresignedRedirect indicates whether the cache hits of the ZIP files
+	// are answered with redirects to the presigned URLs issued by the
+	// `Cacher`, so that the clients download the ZIP files directly from
+	// the underlying storage. It only takes effect when the `Cacher`
+	// implements the `CachePresigner`, and the clients must be able to
+	// reach the underlying storage.
+	//
+	// Default value: false
+	PresignedRedirect bool `mapstructure:"presigned_redirect"`
+
+	// PresignMinBytes is the minimum number of bytes of the ZIP files whose
+	// cache hits are answered with redirects when the `PresignedRedirect`
+	// is true. Smaller ones are served by the `Goproxy` as usual.
+	//
+	// Default value: 0
+	PresignMinBytes int64 `mapstructure:"presign_min_bytes"`
+
+	// PresignExpiry is the time during which the presigned URLs of the
+	// redirects are valid.
+	//
+	// Default value: 15m
+	PresignExpiry time.Duration `mapstructure:"presign_expiry"`
+
 	// SupportedSUMDBNames is the supported checksum database names.
 	//
 	// Default value: ["sum.golang.org"]
 	SupportedSUMDBNames []string `mapstructure:"supported_sumdb_names"`
 
+	// QuarantinePeriod is the period of time during which newly published
+	// module versions will not be served. The publish time of a module
+	// version is the `Time` field of its ".info" file.
+	//
+	// Quarantined module versions are hidden from the "/@v/list" and the
+	// "/@latest", and requests for them are answered with "403 Forbidden".
+	//
+	// If the `QuarantinePeriod` is zero, then there will be no quarantine.
+	//
+	// Default value: 0
+	QuarantinePeriod time.Duration `mapstructure:"quarantine_period"`
+
+	// QuarantineExemptions is the glob patterns (as defined by the
+	// `path.Match`) of the module path prefixes that are exempt from the
+	// `QuarantinePeriod`. The patterns are matched in the same way as the
+	// GONOPROXY.
+	//
+	// Default value: nil
+	QuarantineExemptions []string `mapstructure:"quarantine_exemptions"`
+
+	// HonorRetractions indicates whether to honor the retract directives
+	// in the go.mod of the latest version of a module.
+	//
+	// When honored, the "/@latest" never resolves to a retracted version,
+	// and the responses of the "/@v/list" and the "/@latest" carry a
+	// "Goproxy-Retracted" header in the form of "<version>; <rationale>"
+	// for each retracted version.
+	//
+	// Default value: false
+	HonorRetractions bool `mapstructure:"honor_retractions"`
+
+	// HideRetractedVersions indicates whether to hide the retracted
+	// versions from the "/@v/list". It only takes effect when the
+	// `HonorRetractions` is true.
+	//
+	// Default value: false
+	HideRetractedVersions bool `mapstructure:"hide_retracted_versions"`
+
+	// Metrics is the `Metrics` that used to record the metrics of the
+	// `Goproxy`.
+	//
+	// If the `Metrics` is nil, nothing will be recorded.
+	//
+	// Default value: nil
+	Metrics *Metrics `mapstructure:"-"`
+
+	// Tracer is the `Tracer` that used to trace the `Goproxy`.
+	//
+	// If the `Tracer` is nil, nothing will be traced.
+	//
+	// Default value: nil
+	Tracer *Tracer `mapstructure:"tracer"`
+
+	// Auditor is the `Auditor` that used to record who downloaded what.
+	//
+	// If the `Auditor` is nil, nothing will be recorded.
+	//
+	// Default value: nil
+	Auditor Auditor `mapstructure:"auditor"`
+
+	// Logger is the `Logger` that logs what happens while proxing. Each log
+	// entry carries the request ID, the client, and, when known, the
+	// operation, the module path, and the module version of the request.
+	//
+	// If the `Logger` is nil, the log entries are written to the
+	// `ErrorLogger` in the plain format of the earlier versions: the
+	// message, followed by the error if any, without the level and the
+	// other key-value pairs. Use a `StdLogger` over the `ErrorLogger` to
+	// get them.
+	//
+	// Default value: nil
+	Logger Logger `mapstructure:"-"`
+
 	// ErrorLogger is the `log.Logger` that logs errors that occur while
-	// proxing.
+	// proxing. It is only used when the `Logger` is nil.
 	//
 	// If the `ErrorLogger` is nil, logging is done via the "log" package's
 	// standard logger.
@@ -136,16 +282,26 @@ type Goproxy struct {
 	// Default value: nil
 	ErrorLogger *log.Logger `mapstructure:"-"`
 
-	// DisableNotFoundLog is a switch that disables "Not Found" log.
+	// DisableNotFoundLog is a switch that disables "Not Found" log. The
+	// "Not Found" log is logged at the `LogLevelInfo`.
 	//
 	// Default value: false
 	DisableNotFoundLog bool `mapstructure:"disable_not_found_log"`
 
-	loadOnce            *sync.Once
-	goBinEnv            map[string]string
-	goBinWorkerChan     chan struct{}
-	sumdbClient         *sumdb.Client
-	supportedSUMDBNames map[string]bool
+	loadOnce             *sync.Once
+	reload               *reloadState
+	background           *backgroundTracker
+	usage                *snapshotUsage
+	logger               Logger
+	goBinEnv             map[string]string
+	goBinWorkerChan      chan struct{}
+	cacheWriteWorkerChan chan struct{}
+	cacheWriteQueueChan  chan struct{}
+	sumdbClientOps       *sumdbClientOps
+	sumdbClient          *sumdb.Client
+	supportedSUMDBNames  map[string]bool
+	quarantineExemptions string
+	publishTimes         *publishTimeCache
 }
 
 // New returns a new instance of the `Goproxy` with default field values.
@@ -154,17 +310,29 @@ type Goproxy struct {
 // and keeps everything working.
 func New() *Goproxy {
 	return &Goproxy{
-		GoBinName:           "go",
-		GoBinEnv:            os.Environ(),
-		SupportedSUMDBNames: []string{"sum.golang.org"},
-		loadOnce:            &sync.Once{},
-		goBinEnv:            map[string]string{},
-		supportedSUMDBNames: map[string]bool{},
+		GoBinName:            "go",
+		GoBinEnv:             os.Environ(),
+		SupportedSUMDBNames:  []string{"sum.golang.org"},
+		MaxCacheWriteRetries: 4,
+		CacheWriteBackoff:    time.Second,
+		PresignExpiry:        15 * time.Minute,
+		loadOnce:             &sync.Once{},
+		reload:               &reloadState{},
+		background:           &backgroundTracker{},
+		usage:                &snapshotUsage{},
+		goBinEnv:             map[string]string{},
+		supportedSUMDBNames:  map[string]bool{},
+		publishTimes:         &publishTimeCache{},
 	}
 }
 
 // load loads the stuff of the g up.
 func (g *Goproxy) load() {
+	g.logger = g.Logger
+	if g.logger == nil {
+		g.logger = &plainLogger{logger: g.ErrorLogger}
+	}
+
 	for _, env := range g.GoBinEnv {
 		parts := strings.SplitN(env, "=", 2)
 		if len(parts) != 2 {
@@ -178,6 +346,20 @@ func (g *Goproxy) load() {
 		g.goBinWorkerChan = make(chan struct{}, g.MaxGoBinWorkers)
 	}
 
+	if g.MaxCacheWriteWorkers != 0 {
+		g.cacheWriteWorkerChan = make(
+			chan struct{},
+			g.MaxCacheWriteWorkers,
+		)
+	}
+
+	if g.MaxCacheWriteQueueSize != 0 {
+		g.cacheWriteQueueChan = make(
+			chan struct{},
+			g.MaxCacheWriteQueueSize,
+		)
+	}
+
 	var proxies []string
 	for _, proxy := range strings.Split(g.goBinEnv["GOPROXY"], ",") {
 		proxy = strings.TrimSpace(proxy)
@@ -242,22 +424,55 @@ func (g *Goproxy) load() {
 		g.goBinEnv["GONOSUMDB"] = strings.Join(nosumdbs, ",")
 	}
 
-	g.sumdbClient = sumdb.NewClient(&sumdbClientOps{
-		envGOPROXY:  g.goBinEnv["GOPROXY"],
-		envGOSUMDB:  g.goBinEnv["GOSUMDB"],
-		errorLogger: g.ErrorLogger,
-	})
+	g.sumdbClientOps = &sumdbClientOps{
+		envGOPROXY: g.goBinEnv["GOPROXY"],
+		envGOSUMDB: g.goBinEnv["GOSUMDB"],
+		logger:     g.logger,
+		tracer:     g.Tracer,
+	}
+	g.sumdbClient = sumdb.NewClient(g.sumdbClientOps)
 
 	for _, name := range g.SupportedSUMDBNames {
 		if n, err := idna.Lookup.ToASCII(name); err == nil {
 			g.supportedSUMDBNames[n] = true
 		}
 	}
+
+	g.quarantineExemptions = strings.Join(g.QuarantineExemptions, ",")
 }
 
 // ServeHTTP implements the `http.Handler`.
 func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	g.loadOnce.Do(g.load)
+	g, release := g.acquireSnapshot()
+	defer release()
+
+	rrw := &recordingResponseWriter{ResponseWriter: rw}
+	rw = rrw
+
+	rl := g.newRequestLogger(r)
+	rw.Header().Set("X-Request-ID", rl.requestID)
+
+	endpoint := "other"
+	if g.Metrics != nil {
+		defer func() {
+			g.Metrics.observeRequest(endpoint, rrw.statusCode())
+		}()
+	}
+
+	ctx, serverSpan := g.Tracer.startServerSpan(r)
+	if serverSpan != nil {
+		r = r.WithContext(ctx)
+		serverSpan.setAttribute("http.method", r.Method)
+		serverSpan.setAttribute("http.target", r.URL.RequestURI())
+		defer func() {
+			serverSpan.setAttribute("goproxy.endpoint", endpoint)
+			serverSpan.setAttribute(
+				"http.status_code",
+				rrw.statusCode(),
+			)
+			serverSpan.end(nil)
+		}()
+	}
 
 	switch r.Method {
 	case http.MethodGet, http.MethodHead:
@@ -286,6 +501,9 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 	cachingForever := false
 	if strings.HasPrefix(name, "sumdb/") {
+		endpoint = "sumdb"
+		rl.operation = endpoint
+
 		sumdbURL, err := parseRawURL(strings.TrimPrefix(name, "sumdb/"))
 		if err != nil {
 			setResponseCacheControlHeader(rw, 3600)
@@ -332,19 +550,20 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			nil,
 		)
 		if err != nil {
-			g.logError(err)
+			rl.logError(err)
 			responseInternalServerError(rw)
 			return
 		}
 
 		sumdbReq = sumdbReq.WithContext(r.Context())
+		injectTraceparent(r.Context(), sumdbReq.Header)
 
 		sumdbRes, err := http.DefaultClient.Do(sumdbReq)
 		if err != nil {
 			if ue, ok := err.(*url.Error); ok && ue.Timeout() {
 				responseBadGateway(rw)
 			} else {
-				g.logError(err)
+				rl.logError(err)
 				responseInternalServerError(rw)
 			}
 
@@ -355,7 +574,7 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		if sumdbRes.StatusCode != http.StatusOK {
 			b, err := ioutil.ReadAll(sumdbRes.Body)
 			if err != nil {
-				g.logError(err)
+				rl.logError(err)
 				responseInternalServerError(rw)
 				return
 			}
@@ -364,9 +583,7 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			case http.StatusBadRequest,
 				http.StatusNotFound,
 				http.StatusGone:
-				if !g.DisableNotFoundLog {
-					g.logErrorf("%s", b)
-				}
+				rl.logNotFound(string(b))
 
 				if sumdbRes.StatusCode == http.StatusNotFound {
 					setResponseCacheControlHeader(rw, 60)
@@ -379,7 +596,7 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			g.logError(fmt.Errorf(
+			rl.logError(fmt.Errorf(
 				"GET %s: %s: %s",
 				redactedURL(sumdbURL),
 				sumdbRes.Status,
@@ -442,7 +659,7 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	nameBase := nameParts[1]
 	nameExt := path.Ext(nameBase)
 	switch nameExt {
-	case ".info", ".mod", ".zip":
+	case ".info", ".mod", ".zip", ".provenance":
 	default:
 		setResponseCacheControlHeader(rw, 3600)
 		responseNotFound(rw)
@@ -457,9 +674,39 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ar := &AuditRecord{
+		Time:       time.Now(),
+		Client:     clientIdentity(r),
+		ModulePath: modulePath,
+		Artifact:   strings.TrimPrefix(nameExt, "."),
+	}
+	switch {
+	case isList:
+		ar.Artifact = "list"
+	case isLatest:
+		ar.Artifact = "latest"
+	default:
+		ar.ModuleVersion = moduleVersion
+	}
+
+	endpoint = ar.Artifact
+	rl.operation = ar.Artifact
+	rl.modulePath = ar.ModulePath
+	rl.moduleVersion = ar.ModuleVersion
+
+	if g.Auditor != nil {
+		defer func() {
+			ar.Status = rrw.statusCode()
+			ar.BytesServed = rrw.written
+			if err := g.Auditor.Audit(r.Context(), ar); err != nil {
+				rl.logError(err)
+			}
+		}()
+	}
+
 	goproxyRoot, err := ioutil.TempDir("", "goproxy")
 	if err != nil {
-		g.logError(err)
+		rl.logError(err)
 		responseInternalServerError(rw)
 		return
 	}
@@ -472,36 +719,97 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	cacher := g.Cacher
+	if cacher == nil {
+		cacher = &tempCacher{}
+	}
+
+	if serverSpan != nil {
+		serverSpan.setAttribute("goproxy.module.path", modulePath)
+		cacher = &tracingCacher{cacher: cacher}
+	}
+
+	quarantineApplies := g.quarantineApplies(modulePath)
+
 	if isList {
 		mr, err := mod(
+			r.Context(),
 			"list",
 			g.GoBinName,
 			g.goBinEnv,
 			g.goBinWorkerChan,
+			g.Metrics,
 			goproxyRoot,
 			modulePath,
 			moduleVersion,
 		)
 		if err != nil {
 			if regModuleVersionNotFound.MatchString(err.Error()) {
-				if !g.DisableNotFoundLog {
-					g.logError(err)
-				}
+				rl.logNotFound(err)
 
 				setResponseCacheControlHeader(rw, 60)
 				responseNotFound(rw, err)
 			} else {
-				g.logError(err)
+				rl.logError(err)
 				responseInternalServerError(rw)
 			}
 
 			return
 		}
 
-		versions := strings.Join(mr.Versions, "\n")
+		ar.Upstream = mr.Source
+
+		versions := mr.Versions
+		if quarantineApplies {
+			versions = g.unquarantinedVersions(
+				r.Context(),
+				cacher,
+				goproxyRoot,
+				modulePath,
+				versions,
+			)
+		}
+
+		if g.HonorRetractions {
+			retracted, err := g.retractedVersions(
+				r.Context(),
+				cacher,
+				goproxyRoot,
+				modulePath,
+				mr.Versions,
+			)
+			if err != nil {
+				if regModuleVersionNotFound.MatchString(
+					err.Error(),
+				) {
+					rl.logNotFound(err)
+
+					setResponseCacheControlHeader(rw, 60)
+					responseNotFound(rw, err)
+				} else {
+					rl.logError(err)
+					responseInternalServerError(rw)
+				}
+
+				return
+			}
+
+			setResponseRetractedHeader(rw, retracted)
+			if g.HideRetractedVersions {
+				versions = unretractedVersions(
+					versions,
+					retracted,
+				)
+			}
+		}
 
 		setResponseCacheControlHeader(rw, 60)
-		responseString(rw, http.StatusOK, versions)
+		responseCompressedString(
+			rw,
+			r,
+			http.StatusOK,
+			strings.Join(versions, "\n"),
+		)
 
 		return
 	} else if isLatest || !semver.IsValid(moduleVersion) {
@@ -513,268 +821,150 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		}
 
 		mr, err := mod(
+			r.Context(),
 			operation,
 			g.GoBinName,
 			g.goBinEnv,
 			g.goBinWorkerChan,
+			g.Metrics,
 			goproxyRoot,
 			modulePath,
 			moduleVersion,
 		)
 		if err != nil {
 			if regModuleVersionNotFound.MatchString(err.Error()) {
-				if !g.DisableNotFoundLog {
-					g.logError(err)
-				}
+				rl.logNotFound(err)
 
 				setResponseCacheControlHeader(rw, 60)
 				responseNotFound(rw, err)
 			} else {
-				g.logError(err)
+				rl.logError(err)
 				responseInternalServerError(rw)
 			}
 
 			return
 		}
 
-		moduleVersion = mr.Version
-		escapedModuleVersion, err = module.EscapeVersion(moduleVersion)
-		if err != nil {
-			g.logError(err)
-			responseInternalServerError(rw)
-			return
-		}
-
-		nameBase = fmt.Sprint(escapedModuleVersion, nameExt)
-		name = path.Join(path.Dir(name), nameBase)
-	} else {
-		cachingForever = true
-	}
+		ar.Upstream = mr.Source
 
-	cacher := g.Cacher
-	if cacher == nil {
-		cacher = &tempCacher{}
-	}
-
-	cache, err := cacher.Cache(r.Context(), name)
-	if err == ErrCacheNotFound {
-		mr, err := mod(
-			"download",
-			g.GoBinName,
-			g.goBinEnv,
-			g.goBinWorkerChan,
-			goproxyRoot,
-			modulePath,
-			moduleVersion,
-		)
-		if err != nil {
-			if regModuleVersionNotFound.MatchString(err.Error()) {
-				if !g.DisableNotFoundLog {
-					g.logError(err)
-				}
-
-				setResponseCacheControlHeader(rw, 60)
-				responseNotFound(rw, err)
-			} else {
-				g.logError(err)
-				responseInternalServerError(rw)
-			}
-
-			return
-		}
-
-		if g.goBinEnv["GOSUMDB"] != "off" &&
-			!globsMatchPath(g.goBinEnv["GONOSUMDB"], modulePath) {
-			zipLines, err := g.sumdbClient.Lookup(
+		moduleVersion = mr.Version
+		if isLatest {
+			var retracted map[string]string
+			moduleVersion, retracted, err = g.servableLatestVersion(
+				r.Context(),
+				cacher,
+				goproxyRoot,
 				modulePath,
-				moduleVersion,
+				mr,
 			)
 			if err != nil {
-				err := errors.New(strings.TrimPrefix(
-					err.Error(),
-					fmt.Sprintf(
-						"%s@%s: ",
-						modulePath,
-						moduleVersion,
-					),
-				))
-
 				if regModuleVersionNotFound.MatchString(
 					err.Error(),
 				) {
-					if !g.DisableNotFoundLog {
-						g.logError(err)
-					}
+					rl.logNotFound(err)
 
 					setResponseCacheControlHeader(rw, 60)
 					responseNotFound(rw, err)
 				} else {
-					g.logError(err)
+					rl.logError(err)
 					responseInternalServerError(rw)
 				}
 
 				return
 			}
 
-			zipHash, err := dirhash.HashZip(
-				mr.Zip,
-				dirhash.DefaultHash,
-			)
-			if err != nil {
-				g.logError(err)
-				responseInternalServerError(rw)
-				return
-			}
+			setResponseRetractedHeader(rw, retracted)
+		} else if quarantineApplies && g.quarantined(mr.Time) {
+			setResponseCacheControlHeader(rw, 60)
+			responseForbidden(rw, g.quarantineMessage(
+				modulePath,
+				moduleVersion,
+				mr.Time,
+			))
+			return
+		}
 
-			if !stringSliceContains(
-				zipLines,
-				fmt.Sprintf(
-					"%s %s %s",
-					modulePath,
-					moduleVersion,
-					zipHash,
-				),
-			) {
-				setResponseCacheControlHeader(rw, 3600)
-				responseNotFound(rw, fmt.Sprintf(
-					"untrusted revision %s",
-					moduleVersion,
-				))
-				return
-			}
+		ar.ModuleVersion = moduleVersion
+		rl.moduleVersion = moduleVersion
+		serverSpan.setAttribute("goproxy.module.version", moduleVersion)
+
+		escapedModuleVersion, err = module.EscapeVersion(moduleVersion)
+		if err != nil {
+			rl.logError(err)
+			responseInternalServerError(rw)
+			return
+		}
 
-			goModLines, err := g.sumdbClient.Lookup(
+		nameBase = fmt.Sprint(escapedModuleVersion, nameExt)
+		name = path.Join(path.Dir(name), nameBase)
+	} else {
+		if quarantineApplies {
+			t, err := g.moduleVersionTime(
+				r.Context(),
+				cacher,
+				goproxyRoot,
 				modulePath,
-				fmt.Sprint(moduleVersion, "/go.mod"),
+				moduleVersion,
 			)
 			if err != nil {
-				err := errors.New(strings.TrimPrefix(
-					err.Error(),
-					fmt.Sprintf(
-						"%s@%s: ",
-						modulePath,
-						moduleVersion,
-					),
-				))
-
 				if regModuleVersionNotFound.MatchString(
 					err.Error(),
 				) {
-					if !g.DisableNotFoundLog {
-						g.logError(err)
-					}
+					rl.logNotFound(err)
 
 					setResponseCacheControlHeader(rw, 60)
 					responseNotFound(rw, err)
 				} else {
-					g.logError(err)
+					rl.logError(err)
 					responseInternalServerError(rw)
 				}
 
 				return
 			}
 
-			goModHash, err := dirhash.Hash1(
-				[]string{"go.mod"},
-				func(string) (io.ReadCloser, error) {
-					return os.Open(mr.GoMod)
-				},
-			)
-			if err != nil {
-				g.logError(err)
-				responseInternalServerError(rw)
-				return
-			}
-
-			if !stringSliceContains(
-				goModLines,
-				fmt.Sprintf(
-					"%s %s/go.mod %s",
+			if g.quarantined(t) {
+				setResponseCacheControlHeader(rw, 60)
+				responseForbidden(rw, g.quarantineMessage(
 					modulePath,
 					moduleVersion,
-					goModHash,
-				),
-			) {
-				setResponseCacheControlHeader(rw, 3600)
-				responseNotFound(rw, fmt.Sprintf(
-					"untrusted revision %s",
-					moduleVersion,
+					t,
 				))
 				return
 			}
 		}
 
-		// Setting the caches asynchronously to avoid timeouts in
-		// response.
-		hijackedGoproxyRootPurge = true
-		go func() {
-			defer func() {
-				modClean(g.GoBinName, g.goBinEnv, goproxyRoot)
-				os.RemoveAll(goproxyRoot)
-			}()
-
-			namePrefix := strings.TrimSuffix(name, nameExt)
-
-			// Using a new `context.Context` instead of the
-			// `r.Context` to avoid early timeouts.
-			ctx, cancel := context.WithTimeout(
-				context.Background(),
-				10*time.Minute,
-			)
-			defer cancel()
-
-			infoCache, err := newTempCache(
-				mr.Info,
-				fmt.Sprint(namePrefix, ".info"),
-				cacher.NewHash(),
-			)
-			if err != nil {
-				g.logError(err)
-				return
-			}
-			defer infoCache.Close()
+		cachingForever = true
+	}
 
-			if err := cacher.SetCache(ctx, infoCache); err != nil {
-				g.logError(err)
-				return
-			}
+	cache, err := cacher.Cache(r.Context(), name)
+	g.Metrics.observeCache(cacher, err)
+	if err == ErrCacheNotFound {
+		mr, err := g.download(
+			r.Context(),
+			goproxyRoot,
+			modulePath,
+			moduleVersion,
+		)
+		if err != nil {
+			if _, ok := err.(*untrustedRevisionError); ok {
+				setResponseCacheControlHeader(rw, 3600)
+				responseNotFound(rw, err)
+			} else if regModuleVersionNotFound.MatchString(
+				err.Error(),
+			) {
+				rl.logNotFound(err)
 
-			modCache, err := newTempCache(
-				mr.GoMod,
-				fmt.Sprint(namePrefix, ".mod"),
-				cacher.NewHash(),
-			)
-			if err != nil {
-				g.logError(err)
-				return
+				setResponseCacheControlHeader(rw, 60)
+				responseNotFound(rw, err)
+			} else {
+				rl.logError(err)
+				responseInternalServerError(rw)
 			}
-			defer modCache.Close()
 
-			if err := cacher.SetCache(ctx, modCache); err != nil {
-				g.logError(err)
-				return
-			}
+			return
+		}
 
-			zipCache, err := newTempCache(
-				mr.Zip,
-				fmt.Sprint(namePrefix, ".zip"),
-				cacher.NewHash(),
-			)
-			if err != nil {
-				g.logError(err)
-				return
-			}
-			defer zipCache.Close()
-
-			if g.MaxZIPCacheBytes == 0 ||
-				zipCache.Size() <= int64(g.MaxZIPCacheBytes) {
-				err := cacher.SetCache(ctx, zipCache)
-				if err != nil {
-					g.logError(err)
-					return
-				}
-			}
-		}()
+		ar.Upstream = mr.Source
 
 		var filename string
 		switch nameExt {
@@ -784,27 +974,78 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			filename = mr.GoMod
 		case ".zip":
 			filename = mr.Zip
+		case ".provenance":
+			filename = mr.Provenance
 		}
 
+		// The cache must be opened before the cache write is
+		// enqueued, since the job may purge the goproxyRoot as soon
+		// as it has spooled the files.
 		cache, err = newTempCache(filename, name, cacher.NewHash())
 		if err != nil {
-			g.logError(err)
+			rl.logError(err)
 			responseInternalServerError(rw)
 			return
 		}
+
+		// Setting the caches asynchronously to avoid timeouts in
+		// response. Only the trace context of the `r.Context` is kept
+		// to avoid early timeouts.
+		namePrefix := strings.TrimSuffix(name, nameExt)
+		hijackedGoproxyRootPurge = g.enqueueCacheWrite(
+			contextWithSpan(context.Background(), r.Context()),
+			cacher,
+			namePrefix,
+			goproxyRoot,
+			mr,
+		)
 	} else if err != nil {
-		g.logError(err)
+		rl.logError(err)
 		responseInternalServerError(rw)
 		return
+	} else {
+		ar.CacheHit = true
 	}
 	defer cache.Close()
 
+	if ar.CacheHit {
+		redirectURL, err := g.presignedRedirectURL(r, cache)
+		if err != nil {
+			rl.logError(err)
+		} else if redirectURL != "" {
+			ar.Redirected = true
+			setResponseCacheControlHeader(rw, -1)
+			http.Redirect(
+				rw,
+				r,
+				redirectURL,
+				http.StatusTemporaryRedirect,
+			)
+			return
+		}
+	}
+
 	rw.Header().Set("Content-Type", cache.MIMEType())
+
+	// The encoded representation has its own checksum, so the ETags and
+	// the ranges of both representations stay consistent.
+	content := cache
+	if ec, ok := cache.(EncodedCache); ok {
+		rw.Header().Add("Vary", "Accept-Encoding")
+		if encoding := ec.ContentEncoding(); acceptsEncoding(
+			r,
+			encoding,
+		) {
+			rw.Header().Set("Content-Encoding", encoding)
+			content = ec.Encoded()
+		}
+	}
+
 	rw.Header().Set(
 		"ETag",
 		fmt.Sprintf(
 			"%q",
-			base64.StdEncoding.EncodeToString(cache.Checksum()),
+			base64.StdEncoding.EncodeToString(content.Checksum()),
 		),
 	)
 
@@ -814,22 +1055,298 @@ func (g *Goproxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		setResponseCacheControlHeader(rw, 60)
 	}
 
-	http.ServeContent(rw, r, "", cache.ModTime(), cache)
+	http.ServeContent(rw, r, "", content.ModTime(), content)
 }
 
-// logErrorf logs the v as an error in the format.
-func (g *Goproxy) logErrorf(format string, v ...interface{}) {
-	s := fmt.Sprintf(format, v...)
-	if g.ErrorLogger != nil {
-		g.ErrorLogger.Output(2, s)
-	} else {
-		log.Output(2, s)
+// untrustedRevisionError is the error resulting if a module version does not
+// match the checksum database.
+type untrustedRevisionError struct {
+	moduleVersion string
+}
+
+// Error implements the `error`.
+func (ure *untrustedRevisionError) Error() string {
+	return fmt.Sprintf("untrusted revision %s", ure.moduleVersion)
+}
+
+// download downloads the moduleVersion of the modulePath into the goproxyRoot,
+// verifies it against the checksum database, and returns the result with its
+// ".info" carrying the origin and its ".provenance" written. It returns an
+// `untrustedRevisionError` if the verification fails.
+func (g *Goproxy) download(
+	ctx context.Context,
+	goproxyRoot string,
+	modulePath string,
+	moduleVersion string,
+) (*modResult, error) {
+	mr, err := mod(
+		ctx,
+		"download",
+		g.GoBinName,
+		g.goBinEnv,
+		g.goBinWorkerChan,
+		g.Metrics,
+		goproxyRoot,
+		modulePath,
+		moduleVersion,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pv := newProvenance(modulePath, moduleVersion, mr)
+	if g.sumdbVerifies(modulePath) {
+		zipLines, err := g.sumdbLookup(ctx, modulePath, moduleVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		_, hashSpan := startSpan(
+			ctx,
+			"dirhash.HashZip",
+			spanKindInternal,
+		)
+		zipHash, err := dirhash.HashZip(mr.Zip, dirhash.DefaultHash)
+		hashSpan.end(err)
+		if err != nil {
+			return nil, err
+		}
+
+		if !stringSliceContains(zipLines, fmt.Sprintf(
+			"%s %s %s",
+			modulePath,
+			moduleVersion,
+			zipHash,
+		)) {
+			return nil, &untrustedRevisionError{moduleVersion}
+		}
+
+		if err := g.verifyGoMod(
+			ctx,
+			modulePath,
+			moduleVersion,
+			mr.GoMod,
+		); err != nil {
+			return nil, err
+		}
+
+		pv.SUMDB = sumdbName(g.goBinEnv["GOSUMDB"])
+		pv.SUMDBTreeSize = g.sumdbClientOps.latestTreeSize()
+	}
+
+	if mr.Info, err = addInfoOrigin(
+		goproxyRoot,
+		mr.Info,
+		mr.Origin,
+	); err != nil {
+		return nil, err
+	}
+
+	if mr.Provenance, err = writeProvenance(goproxyRoot, pv); err != nil {
+		return nil, err
+	}
+
+	return mr, nil
+}
+
+// sumdbVerifies reports whether the modules of the modulePath are verified
+// against the checksum database.
+func (g *Goproxy) sumdbVerifies(modulePath string) bool {
+	return g.goBinEnv["GOSUMDB"] != "off" &&
+		!globsMatchPath(g.goBinEnv["GONOSUMDB"], modulePath)
+}
+
+// verifyGoMod verifies the go.mod file targeted by the goModFilename of the
+// moduleVersion of the modulePath against the checksum database.
+func (g *Goproxy) verifyGoMod(
+	ctx context.Context,
+	modulePath string,
+	moduleVersion string,
+	goModFilename string,
+) error {
+	goModLines, err := g.sumdbLookup(
+		ctx,
+		modulePath,
+		fmt.Sprint(moduleVersion, "/go.mod"),
+	)
+	if err != nil {
+		return err
 	}
+
+	goModHash, err := dirhash.Hash1(
+		[]string{"go.mod"},
+		func(string) (io.ReadCloser, error) {
+			return os.Open(goModFilename)
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if !stringSliceContains(goModLines, fmt.Sprintf(
+		"%s %s/go.mod %s",
+		modulePath,
+		moduleVersion,
+		goModHash,
+	)) {
+		return &untrustedRevisionError{moduleVersion}
+	}
+
+	return nil
 }
 
-// logError logs the err.
-func (g *Goproxy) logError(err error) {
-	g.logErrorf("%v", err)
+// sumdbLookup looks up the lines of the moduleVersion of the modulePath in the
+// checksum database. The moduleVersion may have a "/go.mod" suffix.
+func (g *Goproxy) sumdbLookup(
+	ctx context.Context,
+	modulePath string,
+	moduleVersion string,
+) ([]string, error) {
+	_, lookupSpan := startSpan(ctx, "sumdb.Lookup", spanKindInternal)
+	lookupStartTime := time.Now()
+	lines, err := g.sumdbClient.Lookup(modulePath, moduleVersion)
+	g.Metrics.observeSUMDBLookup(time.Since(lookupStartTime), err)
+	lookupSpan.end(err)
+	if err != nil {
+		return nil, errors.New(strings.TrimPrefix(
+			err.Error(),
+			fmt.Sprintf(
+				"%s@%s: ",
+				modulePath,
+				strings.TrimSuffix(moduleVersion, "/go.mod"),
+			),
+		))
+	}
+
+	return lines, nil
+}
+
+// setCaches sets the files of the mr downloaded by the `download` to the
+// cacher as the caches named with the namePrefix, such as
+// "example.com/foo/@v/v1.0.0". The ".zip" is skipped if it exceeds the
+// `MaxZIPCacheBytes`.
+func (g *Goproxy) setCaches(
+	ctx context.Context,
+	cacher Cacher,
+	namePrefix string,
+	mr *modResult,
+) error {
+	for _, file := range []struct {
+		filename string
+		nameExt  string
+	}{
+		{mr.Info, ".info"},
+		{mr.GoMod, ".mod"},
+		{mr.Zip, ".zip"},
+		{mr.Provenance, ".provenance"},
+	} {
+		if err := g.setCache(
+			ctx,
+			cacher,
+			file.filename,
+			fmt.Sprint(namePrefix, file.nameExt),
+		); err != nil {
+			g.Metrics.observeCacheWriteFailure(cacher)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setCache sets the file targeted by the filename to the cacher as the cache
+// of the name.
+func (g *Goproxy) setCache(
+	ctx context.Context,
+	cacher Cacher,
+	filename string,
+	name string,
+) error {
+	cache, err := newTempCache(filename, name, cacher.NewHash())
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	if path.Ext(name) == ".zip" &&
+		g.MaxZIPCacheBytes != 0 &&
+		cache.Size() > int64(g.MaxZIPCacheBytes) {
+		return nil
+	}
+
+	return cacher.SetCache(ctx, cache)
+}
+
+// servableLatestVersion returns the latest version of the modulePath that is
+// neither quarantined nor retracted, along with the rationales of the retracted
+// versions keyed by the version. The latest is the result of the "latest"
+// `mod`, its version is returned as is if it is servable.
+func (g *Goproxy) servableLatestVersion(
+	ctx context.Context,
+	cacher Cacher,
+	goproxyRoot string,
+	modulePath string,
+	latest *modResult,
+) (string, map[string]string, error) {
+	quarantineApplies := g.quarantineApplies(modulePath)
+	quarantined := quarantineApplies && g.quarantined(latest.Time)
+	if !quarantined && !g.HonorRetractions {
+		return latest.Version, nil, nil
+	}
+
+	mr, err := mod(
+		ctx,
+		"list",
+		g.GoBinName,
+		g.goBinEnv,
+		g.goBinWorkerChan,
+		g.Metrics,
+		goproxyRoot,
+		modulePath,
+		"latest",
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	versions := mr.Versions
+
+	var retracted map[string]string
+	if g.HonorRetractions {
+		retracted, err = g.retractedVersions(
+			ctx,
+			cacher,
+			goproxyRoot,
+			modulePath,
+			versions,
+		)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if _, ok := retracted[latest.Version]; !ok && !quarantined {
+			return latest.Version, retracted, nil
+		}
+
+		versions = unretractedVersions(versions, retracted)
+	}
+
+	if quarantineApplies {
+		versions = g.unquarantinedVersions(
+			ctx,
+			cacher,
+			goproxyRoot,
+			modulePath,
+			versions,
+		)
+	}
+
+	version := latestVersion(versions)
+	if version == "" {
+		return "", retracted, errors.New("no matching versions")
+	}
+
+	return version, retracted, nil
 }
 
 // parseRawURL parses the rawURL.
@@ -0,0 +1,299 @@
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// newTestModuleProxy returns the URL of a new module proxy serving the versions
+// of the modulePath published at the times, along with a function that closes
+// it.
+func newTestModuleProxy(
+	t *testing.T,
+	modulePath string,
+	versions map[string]time.Time,
+) (string, func()) {
+	dir, err := ioutil.TempDir("", "goproxy-upstream")
+	assert.NoError(t, err)
+
+	vDir := filepath.Join(dir, filepath.FromSlash(modulePath), "@v")
+	assert.NoError(t, os.MkdirAll(vDir, 0755))
+
+	list := make([]string, 0, len(versions))
+	for version, publishTime := range versions {
+		list = append(list, version)
+
+		base := filepath.Join(vDir, version)
+		goMod := []byte(fmt.Sprintf("module %s\n", modulePath))
+		assert.NoError(t, ioutil.WriteFile(base+".mod", goMod, 0644))
+		assert.NoError(t, ioutil.WriteFile(
+			base+".info",
+			[]byte(fmt.Sprintf(
+				`{"Version":%q,"Time":%q}`,
+				version,
+				publishTime.UTC().Format(time.RFC3339),
+			)),
+			0644,
+		))
+
+		buf := &bytes.Buffer{}
+		zw := zip.NewWriter(buf)
+		prefix := modulePath + "@" + version + "/"
+		for name, content := range map[string][]byte{
+			"go.mod": goMod,
+			"foo.go": []byte("package foo\n"),
+		} {
+			w, err := zw.Create(prefix + name)
+			assert.NoError(t, err)
+			_, err = w.Write(content)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, zw.Close())
+		assert.NoError(t, ioutil.WriteFile(
+			base+".zip",
+			buf.Bytes(),
+			0644,
+		))
+	}
+
+	sort.Strings(list)
+	assert.NoError(t, ioutil.WriteFile(
+		filepath.Join(vDir, "list"),
+		[]byte(strings.Join(list, "\n")+"\n"),
+		0644,
+	))
+
+	if len(list) > 0 {
+		latest, err := ioutil.ReadFile(filepath.Join(
+			vDir,
+			list[len(list)-1]+".info",
+		))
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(
+			filepath.Join(filepath.Dir(vDir), "@latest"),
+			latest,
+			0644,
+		))
+	}
+
+	upstream := httptest.NewServer(http.FileServer(http.Dir(dir)))
+
+	return upstream.URL, func() {
+		upstream.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestSUMDB returns the GOSUMDB of a new checksum database serving the
+// hashes of the module versions of the upstreamURL, along with a function that
+// closes it.
+func newTestSUMDB(t *testing.T, upstreamURL string) (string, func()) {
+	signer, verifier, err := note.GenerateKey(rand.Reader, "sumdb.test")
+	assert.NoError(t, err)
+
+	download := func(path, vers, ext string) (string, error) {
+		res, err := http.Get(fmt.Sprintf(
+			"%s/%s/@v/%s%s",
+			upstreamURL,
+			path,
+			vers,
+			ext,
+		))
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return "", fmt.Errorf(
+				"%s: %s",
+				res.Request.URL,
+				res.Status,
+			)
+		}
+
+		f, err := ioutil.TempFile("", "goproxy-sumdb")
+		if err != nil {
+			return "", err
+		}
+
+		_, err = io.Copy(f, res.Body)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+
+		return f.Name(), err
+	}
+
+	gosum := func(path, vers string) ([]byte, error) {
+		zipFilename, err := download(path, vers, ".zip")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(zipFilename)
+
+		goModFilename, err := download(path, vers, ".mod")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(goModFilename)
+
+		zipHash, err := dirhash.HashZip(
+			zipFilename,
+			dirhash.DefaultHash,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		goModHash, err := dirhash.Hash1(
+			[]string{"go.mod"},
+			func(string) (io.ReadCloser, error) {
+				return os.Open(goModFilename)
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(fmt.Sprintf(
+			"%s %s %s\n%s %s/go.mod %s\n",
+			path,
+			vers,
+			zipHash,
+			path,
+			vers,
+			goModHash,
+		)), nil
+	}
+
+	sumdbServer := httptest.NewServer(sumdb.NewServer(
+		sumdb.NewTestServer(signer, gosum),
+	))
+
+	return verifier + " " + sumdbServer.URL, sumdbServer.Close
+}
+
+type gzipCacher struct {
+	mapCacher
+}
+
+func (gc *gzipCacher) Cache(ctx context.Context, name string) (Cache, error) {
+	c, err := gc.mapCacher.Cache(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	gw.Write(gc.caches[name])
+	gw.Close()
+
+	return &gzipCache{
+		mapCache: c.(*mapCache),
+		encoded: &checksumCache{
+			mapCache: &mapCache{
+				Reader: bytes.NewReader(buf.Bytes()),
+				name:   name,
+			},
+			checksum: []byte("encoded"),
+		},
+	}, nil
+}
+
+type gzipCache struct {
+	*mapCache
+	encoded Cache
+}
+
+func (gc *gzipCache) Checksum() []byte        { return []byte("decoded") }
+func (gc *gzipCache) ContentEncoding() string { return "gzip" }
+func (gc *gzipCache) Encoded() Cache          { return gc.encoded }
+
+type checksumCache struct {
+	*mapCache
+	checksum []byte
+}
+
+func (cc *checksumCache) Checksum() []byte { return cc.checksum }
+
+func TestGoproxyServeHTTPEncodedCache(t *testing.T) {
+	name := "example.com/foo/@v/v1.0.0.mod"
+	content := []byte("module example.com/foo\n")
+
+	g := New()
+	g.Cacher = &gzipCacher{mapCacher{caches: map[string][]byte{
+		name: content,
+	}}}
+
+	do := func(acceptEncoding, rng string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+name, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := do("", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+	assert.Equal(t, `"ZGVjb2RlZA=="`, rec.Header().Get("ETag"))
+	assert.Equal(t, content, rec.Body.Bytes())
+
+	rec = do("", "bytes=7-17")
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, content[7:18], rec.Body.Bytes())
+
+	rec = do("gzip", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, `"ZW5jb2RlZA=="`, rec.Header().Get("ETag"))
+
+	encoded := append([]byte(nil), rec.Body.Bytes()...)
+
+	gr, err := gzip.NewReader(rec.Body)
+	assert.NoError(t, err)
+
+	decoded := &bytes.Buffer{}
+	_, err = decoded.ReadFrom(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, content, decoded.Bytes())
+
+	rec = do("gzip", "bytes=0-9")
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, encoded[:10], rec.Body.Bytes())
+
+	rec = do("gzip;q=0", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, content, rec.Body.Bytes())
+}
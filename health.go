@@ -0,0 +1,421 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthProbeNamePrefix is the prefix of the names of the probe caches written
+// and read by the `Health` to check the `Goproxy.Cacher`. Each `Health` appends
+// a random suffix once and overwrites the same probe cache afterwards, so that
+// it never reads the probe caches of other instances sharing the same
+// `Goproxy.Cacher` and leaves at most one behind.
+const healthProbeNamePrefix = "goproxy-health-probe-"
+
+// Health checks whether a `Goproxy` can actually serve. It implements the
+// `http.Handler` to serve the "/healthz" and the "/readyz", so simply mount it
+// at both.
+//
+// The "/healthz" checks the local dependencies: the `Goproxy.Cacher` can write
+// and read a probe cache, and the Go binary targeted by the `Goproxy.GoBinName`
+// runs. The "/readyz" additionally checks the remote ones: at least one of the
+// GOPROXY upstreams answers, and the checksum database endpoint works. Both
+// respond 200 if all checks pass, otherwise 503, with a JSON object reporting
+// each check.
+type Health struct {
+	// Goproxy is the `Goproxy` to be checked.
+	Goproxy *Goproxy
+
+	// ResultTTL is how long the result of each check is reused before it
+	// is checked again. It keeps the probes from hammering the
+	// dependencies.
+	//
+	// If the `ResultTTL` is zero, 10 seconds is used.
+	//
+	// Default value: 0
+	ResultTTL time.Duration
+
+	// Timeout is the maximum duration of each check.
+	//
+	// If the `Timeout` is zero, 5 seconds is used.
+	//
+	// Default value: 0
+	Timeout time.Duration
+
+	mutex      sync.Mutex
+	results    map[string]*healthResult
+	calls      map[string]*healthCall
+	probeMutex sync.Mutex
+	probeName  string
+}
+
+// healthResult is the result of a check of the `Health` against a snapshot of
+// the `Goproxy`.
+type healthResult struct {
+	snapshot  *Goproxy
+	err       error
+	checkTime time.Time
+}
+
+// healthCall is a check of the `Health` in progress against a snapshot of the
+// `Goproxy`, which is shared by the concurrent requests.
+type healthCall struct {
+	snapshot *Goproxy
+	err      error
+	done     chan struct{}
+}
+
+// healthCheck is a check of the `Health`.
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context, g *Goproxy) error
+}
+
+// ServeHTTP implements the `http.Handler`.
+func (h *Health) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	g, release := h.Goproxy.acquireSnapshot()
+	defer release()
+
+	checks := []healthCheck{
+		{"cacher", h.checkCacher},
+		{"go_bin", h.checkGoBin},
+	}
+	if strings.HasSuffix(r.URL.Path, "/readyz") {
+		checks = append(
+			checks,
+			healthCheck{"upstream", h.checkUpstream},
+			healthCheck{"sumdb", h.checkSUMDB},
+		)
+	}
+
+	errs := h.run(r.Context(), g, checks)
+
+	status := http.StatusOK
+	reports := make(map[string]string, len(checks))
+	for i, hc := range checks {
+		if errs[i] != nil {
+			status = http.StatusServiceUnavailable
+			reports[hc.name] = errs[i].Error()
+		} else {
+			reports[hc.name] = "ok"
+		}
+	}
+
+	b, err := json.Marshal(reports)
+	if err != nil {
+		responseInternalServerError(rw)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	setResponseCacheControlHeader(rw, -1)
+	rw.WriteHeader(status)
+	rw.Write(b)
+}
+
+// timeout returns the maximum duration of each check of the h.
+func (h *Health) timeout() time.Duration {
+	if h.Timeout == 0 {
+		return 5 * time.Second
+	}
+
+	return h.Timeout
+}
+
+// run runs the checks against the g concurrently and returns their errors in
+// order. Results of the same snapshot younger than the `ResultTTL` are reused,
+// so a reload is checked at once, and a check already in progress for the same
+// snapshot is waited for instead of being run again.
+func (h *Health) run(
+	ctx context.Context,
+	g *Goproxy,
+	checks []healthCheck,
+) []error {
+	resultTTL := h.ResultTTL
+	if resultTTL == 0 {
+		resultTTL = 10 * time.Second
+	}
+
+	errs := make([]error, len(checks))
+
+	var wg sync.WaitGroup
+	for i, hc := range checks {
+		h.mutex.Lock()
+
+		hr := h.results[hc.name]
+		if hr != nil &&
+			hr.snapshot == g &&
+			time.Since(hr.checkTime) < resultTTL {
+			h.mutex.Unlock()
+			errs[i] = hr.err
+			continue
+		}
+
+		call := h.calls[hc.name]
+		if call == nil || call.snapshot != g {
+			if h.calls == nil {
+				h.calls = map[string]*healthCall{}
+			}
+
+			call = &healthCall{
+				snapshot: g,
+				done:     make(chan struct{}),
+			}
+			h.calls[hc.name] = call
+			go h.call(call, hc)
+		}
+
+		h.mutex.Unlock()
+
+		wg.Add(1)
+		go func(i int, call *healthCall) {
+			defer wg.Done()
+			select {
+			case <-call.done:
+				errs[i] = call.err
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+			}
+		}(i, call)
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+// call runs the hc for the call and records its result. It is not bound to
+// any request, since the call is shared by the concurrent ones.
+func (h *Health) call(call *healthCall, hc healthCheck) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+	defer cancel()
+
+	call.err = hc.check(ctx, call.snapshot)
+	if call.err != nil {
+		call.snapshot.logger.Log(
+			LogLevelWarn,
+			"health check failed",
+			"check",
+			hc.name,
+			"error",
+			call.err,
+		)
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.results == nil {
+		h.results = map[string]*healthResult{}
+	}
+
+	h.results[hc.name] = &healthResult{
+		snapshot:  call.snapshot,
+		err:       call.err,
+		checkTime: time.Now(),
+	}
+
+	if h.calls[hc.name] == call {
+		delete(h.calls, hc.name)
+	}
+
+	close(call.done)
+}
+
+// checkCacher checks that the `Goproxy.Cacher` can write and read the probe
+// cache of the h. The probe cache is deleted afterwards if the `Goproxy.Cacher`
+// is a `CacheDeleter`, otherwise it is overwritten by the next check. It always
+// passes if the `Goproxy.Cacher` is nil.
+func (h *Health) checkCacher(ctx context.Context, g *Goproxy) error {
+	cacher := g.Cacher
+	if cacher == nil {
+		return nil
+	}
+
+	// The checks of the h share the same probe cache, even across
+	// snapshots, so they must not overlap.
+	h.probeMutex.Lock()
+	defer h.probeMutex.Unlock()
+
+	if h.probeName == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+
+		h.probeName = healthProbeNamePrefix + hex.EncodeToString(b)
+	}
+
+	name := h.probeName
+	probe := []byte(time.Now().UTC().Format(time.RFC3339Nano))
+
+	file, err := ioutil.TempFile("", "goproxy-health")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(probe); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	probeCache, err := newTempCache(
+		file.Name(),
+		name,
+		cacher.NewHash(),
+	)
+	if err != nil {
+		return err
+	}
+	defer probeCache.Close()
+
+	if err := cacher.SetCache(ctx, probeCache); err != nil {
+		return err
+	}
+
+	if cd, ok := cacher.(CacheDeleter); ok {
+		defer func() {
+			// The ctx may have timed out by now.
+			ctx, cancel := context.WithTimeout(
+				context.Background(),
+				h.timeout(),
+			)
+			defer cancel()
+
+			err := cd.DeleteCache(ctx, name)
+			if err != nil && err != ErrCacheNotFound {
+				g.logger.Log(
+					LogLevelWarn,
+					"failed to delete health probe cache",
+					"cache",
+					name,
+					"error",
+					err,
+				)
+			}
+		}()
+	}
+
+	cache, err := cacher.Cache(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	b, err := ioutil.ReadAll(cache)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(b, probe) {
+		return errors.New("probe cache mismatch")
+	}
+
+	return nil
+}
+
+// checkGoBin checks that the Go binary targeted by the `Goproxy.GoBinName`
+// runs.
+func (h *Health) checkGoBin(ctx context.Context, g *Goproxy) error {
+	cmd := exec.CommandContext(ctx, g.GoBinName, "version")
+	cmd.Env = make([]string, 0, len(g.goBinEnv))
+	for k, v := range g.goBinEnv {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		output = bytes.TrimSpace(output)
+		if len(output) > 0 {
+			return fmt.Errorf("%v: %s", err, output)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// checkUpstream checks that at least one of the GOPROXY upstreams answers. It
+// always passes if there is no upstream other than the "direct" and the "off".
+func (h *Health) checkUpstream(ctx context.Context, g *Goproxy) error {
+	proxies := strings.Split(g.goBinEnv["GOPROXY"], ",")
+
+	var lastErr error
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" || proxy == "direct" || proxy == "off" {
+			continue
+		}
+
+		proxyURL, err := parseRawURL(proxy)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if lastErr = healthGet(ctx, proxyURL.String()); lastErr == nil {
+			return nil
+		}
+	}
+
+	return lastErr
+}
+
+// checkSUMDB checks that the checksum database endpoint discovered by the
+// `Goproxy` works. It always passes if the GOSUMDB is "off".
+func (h *Health) checkSUMDB(ctx context.Context, g *Goproxy) error {
+	if g.goBinEnv["GOSUMDB"] == "off" {
+		return nil
+	}
+
+	sco := g.sumdbClientOps
+	if sco.loadOnce.Do(sco.load); sco.loadError != nil {
+		return sco.loadError
+	}
+
+	return healthGet(ctx, appendURL(sco.endpointURL, "/latest").String())
+}
+
+// healthGet sends a GET request to the rawURL and checks that it answers with a
+// status code less than 500.
+func healthGet(ctx context.Context, rawURL string) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf(
+			"GET %s: %s",
+			redactedURL(req.URL),
+			res.Status,
+		)
+	}
+
+	return nil
+}
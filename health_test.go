@@ -0,0 +1,263 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type brokenCacher struct {
+	tempCacher
+}
+
+func (bc *brokenCacher) SetCache(ctx context.Context, c Cache) error {
+	return errors.New("broken")
+}
+
+type cancelingCacher struct {
+	mapCacher
+
+	cancel    context.CancelFunc
+	deleteErr error
+}
+
+func (cc *cancelingCacher) Cache(
+	ctx context.Context,
+	name string,
+) (Cache, error) {
+	defer cc.cancel()
+	return cc.mapCacher.Cache(ctx, name)
+}
+
+func (cc *cancelingCacher) DeleteCache(ctx context.Context, name string) error {
+	cc.deleteErr = ctx.Err()
+	return cc.mapCacher.DeleteCache(ctx, name)
+}
+
+func TestHealth(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			responseNotFound(rw)
+		},
+	))
+	defer upstream.Close()
+
+	g := New()
+	g.GoBinEnv = append(
+		g.GoBinEnv,
+		"GOPROXY="+upstream.URL+",direct",
+		"GOSUMDB=off",
+	)
+
+	h := &Health{Goproxy: g, Timeout: 30 * time.Second}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	reports := map[string]string{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reports))
+	assert.Equal(t, map[string]string{
+		"cacher":   "ok",
+		"go_bin":   "ok",
+		"upstream": "ok",
+		"sumdb":    "ok",
+	}, reports)
+
+	g.Cacher = &brokenCacher{}
+	h.ResultTTL = time.Nanosecond
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	reports = map[string]string{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reports))
+	assert.Equal(t, map[string]string{
+		"cacher": "broken",
+		"go_bin": "ok",
+	}, reports)
+}
+
+func TestHealthCheckCacher(t *testing.T) {
+	mc := &mapCacher{}
+
+	g := New()
+	g.Cacher = mc
+	g.snapshot()
+
+	h := &Health{Goproxy: g}
+
+	// Concurrent probes never read each other's probe caches.
+	errs := make([]error, 16)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = h.checkCacher(context.Background(), g)
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// The probe caches are deleted since the cacher is a `CacheDeleter`.
+	assert.Empty(t, mc.caches)
+
+	// Otherwise the same probe cache is overwritten by every check.
+	g.Cacher = struct{ Cacher }{mc}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, h.checkCacher(context.Background(), g))
+	}
+
+	assert.Len(t, mc.caches, 1)
+	assert.Contains(t, mc.caches, h.probeName)
+
+	// The probe cache is deleted even if the check has timed out by then.
+	ctx, cancel := context.WithCancel(context.Background())
+	cc := &cancelingCacher{cancel: cancel}
+	g.Cacher = cc
+	assert.NoError(t, h.checkCacher(ctx, g))
+	assert.NoError(t, cc.deleteErr)
+	assert.Empty(t, cc.caches)
+}
+
+func TestHealthRun(t *testing.T) {
+	g := New()
+	g.snapshot()
+
+	h := &Health{Goproxy: g, ResultTTL: time.Hour}
+
+	var (
+		mutex sync.Mutex
+		runs  int
+	)
+	release := make(chan struct{})
+	checks := []healthCheck{{"slow", func(
+		ctx context.Context,
+		g *Goproxy,
+	) error {
+		mutex.Lock()
+		runs++
+		mutex.Unlock()
+
+		<-release
+
+		return errors.New("slow")
+	}}}
+
+	// The concurrent runs of the same check share a single call.
+	errs := make([][]error, 8)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = h.run(context.Background(), g, checks)
+		}(i)
+	}
+
+	// A request that gives up does not affect the call.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(
+		t,
+		[]error{context.Canceled},
+		h.run(ctx, g, checks),
+	)
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, runs)
+	for _, errs := range errs {
+		assert.EqualError(t, errs[0], "slow")
+	}
+
+	// The result is reused afterwards.
+	assert.EqualError(t, h.run(context.Background(), g, checks)[0], "slow")
+	assert.Equal(t, 1, runs)
+}
+
+func TestHealthReload(t *testing.T) {
+	g := New()
+	g.GoBinEnv = append(g.GoBinEnv, "GOPROXY=off", "GOSUMDB=off")
+
+	h := &Health{Goproxy: g, ResultTTL: time.Hour}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The results of the previous snapshot are not reused.
+	config := New()
+	config.GoBinEnv = g.GoBinEnv
+	config.Cacher = &brokenCacher{}
+	g.Reload(config)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"cacher":"broken"`)
+}
+
+func TestHealthCheckSUMDB(t *testing.T) {
+	upstreamURL, closeUpstream := newTestModuleProxy(
+		t,
+		"example.com/foo",
+		nil,
+	)
+	defer closeUpstream()
+
+	envGOSUMDB, closeSUMDB := newTestSUMDB(t, upstreamURL)
+	defer closeSUMDB()
+
+	brokenSUMDB := httptest.NewServer(http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(http.StatusBadGateway)
+		},
+	))
+	defer brokenSUMDB.Close()
+
+	for _, tc := range []struct {
+		envGOSUMDB string
+		report     string
+	}{
+		{envGOSUMDB, "ok"},
+		{
+			envGOSUMDB[:strings.Index(envGOSUMDB, " ")] +
+				" " + brokenSUMDB.URL,
+			"502 Bad Gateway",
+		},
+	} {
+		g := New()
+		g.GoBinEnv = append(
+			g.GoBinEnv,
+			"GOPROXY="+upstreamURL,
+			"GOSUMDB="+tc.envGOSUMDB,
+		)
+		g.snapshot()
+
+		h := &Health{Goproxy: g}
+
+		err := h.checkSUMDB(context.Background(), g)
+		if tc.report == "ok" {
+			assert.NoError(t, err)
+		} else if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), tc.report)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package goproxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the level of a log entry.
+type LogLevel int
+
+// The log levels.
+const (
+	LogLevelDebug LogLevel = iota - 1
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// String implements the `fmt.Stringer`.
+func (ll LogLevel) String() string {
+	switch ll {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	}
+
+	return fmt.Sprint("level(", int(ll), ")")
+}
+
+// Logger is the interface that defines a set of methods used to log what
+// happens in the `Goproxy`.
+type Logger interface {
+	// Log logs the msg at the level with the keyvals, which is a list of
+	// alternating keys and values. The keys are strings, such as "module",
+	// "version", "operation", "client", and "request_id".
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// StdLogger implements the `Logger` by using the `log.Logger`. Each log entry
+// is written as a single line in the form of `level=error msg="..." key=value`.
+type StdLogger struct {
+	// Logger is the underlying `log.Logger`.
+	//
+	// If the `Logger` is nil, the "log" package's standard logger is used.
+	Logger *log.Logger
+
+	// Level is the minimum level of the log entries to log.
+	Level LogLevel
+}
+
+// Log implements the `Logger`.
+func (sl *StdLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < sl.Level {
+		return
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "level=%s msg=%s", level, logfmtValue(msg))
+	for i := 0; i < len(keyvals); i += 2 {
+		fmt.Fprintf(
+			&sb,
+			" %s=%s",
+			logKey(keyvals, i),
+			logfmtValue(logValue(keyvals, i)),
+		)
+	}
+
+	if sl.Logger != nil {
+		sl.Logger.Output(2, sb.String())
+	} else {
+		log.Output(2, sb.String())
+	}
+}
+
+// plainLogger implements the `Logger` by using the `log.Logger` in the plain
+// format that the `Goproxy` used before the `Logger` was introduced: each log
+// entry is its msg, followed by its "error" if any, without the level and the
+// other keyvals. Entries below the `LogLevelInfo` are not logged.
+type plainLogger struct {
+	logger *log.Logger
+}
+
+// Log implements the `Logger`.
+func (pl *plainLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < LogLevelInfo {
+		return
+	}
+
+	for i := 0; i < len(keyvals); i += 2 {
+		if logKey(keyvals, i) == "error" {
+			msg = fmt.Sprintf("%s: %v", msg, logValue(keyvals, i))
+			break
+		}
+	}
+
+	if pl.logger != nil {
+		pl.logger.Output(2, msg)
+	} else {
+		log.Output(2, msg)
+	}
+}
+
+// JSONLogger implements the `Logger` by writing each log entry as a JSON
+// object on a single line.
+type JSONLogger struct {
+	// Writer is the `io.Writer` that the log entries are written to.
+	//
+	// If the `Writer` is nil, the `os.Stderr` is used.
+	Writer io.Writer
+
+	// Level is the minimum level of the log entries to log.
+	Level LogLevel
+
+	mutex sync.Mutex
+}
+
+// Log implements the `Logger`.
+func (jl *JSONLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < jl.Level {
+		return
+	}
+
+	entry := make(map[string]interface{}, 3+len(keyvals)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		v := logValue(keyvals, i)
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+
+		entry[logKey(keyvals, i)] = v
+	}
+
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b, _ = json.Marshal(map[string]interface{}{
+			"time":  entry["time"],
+			"level": entry["level"],
+			"msg":   msg,
+		})
+	}
+
+	jl.mutex.Lock()
+	defer jl.mutex.Unlock()
+
+	w := jl.Writer
+	if w == nil {
+		w = os.Stderr
+	}
+
+	w.Write(append(b, '\n'))
+}
+
+// logKey returns the key at the i of the keyvals.
+func logKey(keyvals []interface{}, i int) string {
+	if k, ok := keyvals[i].(string); ok {
+		return k
+	}
+
+	return fmt.Sprint(keyvals[i])
+}
+
+// logValue returns the value of the key at the i of the keyvals.
+func logValue(keyvals []interface{}, i int) interface{} {
+	if i+1 < len(keyvals) {
+		return keyvals[i+1]
+	}
+
+	return "(MISSING)"
+}
+
+// logfmtValue returns the logfmt representation of the v.
+func logfmtValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+// requestLogger logs for a single request. It adds the keyvals of the request
+// to every log entry.
+type requestLogger struct {
+	logger             Logger
+	disableNotFoundLog bool
+	requestID          string
+	client             string
+	operation          string
+	modulePath         string
+	moduleVersion      string
+}
+
+// newRequestLogger returns a new instance of the `requestLogger` for the r.
+// The request ID is taken from the "X-Request-ID" header of the r, or
+// generated if absent.
+func (g *Goproxy) newRequestLogger(r *http.Request) *requestLogger {
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		requestID = hex.EncodeToString(b)
+	}
+
+	return &requestLogger{
+		logger:             g.logger,
+		disableNotFoundLog: g.DisableNotFoundLog,
+		requestID:          requestID,
+		client:             clientIdentity(r),
+	}
+}
+
+// log logs the msg at the level with the keyvals of the rl and the keyvals.
+func (rl *requestLogger) log(
+	level LogLevel,
+	msg string,
+	keyvals ...interface{},
+) {
+	kvs := make([]interface{}, 0, 10+len(keyvals))
+	kvs = append(kvs, "request_id", rl.requestID, "client", rl.client)
+	if rl.operation != "" {
+		kvs = append(kvs, "operation", rl.operation)
+	}
+
+	if rl.modulePath != "" {
+		kvs = append(kvs, "module", rl.modulePath)
+	}
+
+	if rl.moduleVersion != "" {
+		kvs = append(kvs, "version", rl.moduleVersion)
+	}
+
+	rl.logger.Log(level, msg, append(kvs, keyvals...)...)
+}
+
+// logError logs the err at the `LogLevelError`.
+func (rl *requestLogger) logError(err error, keyvals ...interface{}) {
+	rl.log(LogLevelError, err.Error(), keyvals...)
+}
+
+// logNotFound logs the msg as a "Not Found" at the `LogLevelInfo` unless the
+// "Not Found" log is disabled.
+func (rl *requestLogger) logNotFound(msg interface{}) {
+	if !rl.disableNotFoundLog {
+		rl.log(LogLevelInfo, fmt.Sprint(msg))
+	}
+}
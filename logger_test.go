@@ -0,0 +1,123 @@
+package goproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sl := &StdLogger{Logger: log.New(buf, "", 0)}
+
+	sl.Log(LogLevelDebug, "ignored")
+	assert.Empty(t, buf.String())
+
+	sl.Log(
+		LogLevelError,
+		"not found",
+		"module",
+		"example.com/foo",
+		"client",
+		"",
+	)
+	assert.Equal(
+		t,
+		"level=error msg=\"not found\" module=example.com/foo "+
+			"client=\"\"\n",
+		buf.String(),
+	)
+}
+
+func TestJSONLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	jl := &JSONLogger{Writer: buf, Level: LogLevelWarn}
+
+	jl.Log(LogLevelInfo, "ignored")
+	assert.Empty(t, buf.String())
+
+	jl.Log(LogLevelWarn, "foobar", "error", errors.New("bad"), "odd")
+
+	entry := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "warn", entry["level"])
+	assert.Equal(t, "foobar", entry["msg"])
+	assert.Equal(t, "bad", entry["error"])
+	assert.Equal(t, "(MISSING)", entry["odd"])
+	assert.NotEmpty(t, entry["time"])
+
+	// A nil `Writer` falls back to the `os.Stderr`.
+	stderr := os.Stderr
+	defer func() { os.Stderr = stderr }()
+
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	os.Stderr = w
+
+	(&JSONLogger{}).Log(LogLevelInfo, "foobar")
+	assert.NoError(t, w.Close())
+
+	b, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+
+	entry = map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(b, &entry))
+	assert.Equal(t, "foobar", entry["msg"])
+}
+
+func TestPlainLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pl := &plainLogger{logger: log.New(buf, "", 0)}
+
+	pl.Log(LogLevelDebug, "ignored")
+	assert.Empty(t, buf.String())
+
+	pl.Log(
+		LogLevelError,
+		"example.com/foo@v1.0.0: not found",
+		"request_id",
+		"0123456789abcdef",
+		"module",
+		"example.com/foo",
+	)
+	pl.Log(
+		LogLevelWarn,
+		"failed to write cache",
+		"cache",
+		"example.com/foo/@v/v1.0.0.mod",
+		"error",
+		errors.New("broken"),
+	)
+	assert.Equal(
+		t,
+		"example.com/foo@v1.0.0: not found\n"+
+			"failed to write cache: broken\n",
+		buf.String(),
+	)
+}
+
+func TestGoproxyErrorLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	g := New()
+	g.GoBinEnv = append(g.GoBinEnv, "GOPROXY=off", "GOSUMDB=off")
+	g.ErrorLogger = log.New(buf, "", 0)
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet,
+		"/example.com/foo/@v/v1.0.0.info",
+		nil,
+	))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "disabled by GOPROXY=off\n", buf.String())
+}
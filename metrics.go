@@ -0,0 +1,383 @@
+package goproxy
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsDurationBuckets is the upper bounds in seconds of the buckets of the
+// duration histograms.
+var metricsDurationBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120,
+}
+
+// metricLabelValueReplacer is the `strings.Replacer` that escapes label values
+// in the Prometheus text-based exposition format.
+var metricLabelValueReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+)
+
+// Metrics is the metrics of the `Goproxy`. It implements the `http.Handler` to
+// expose them in the Prometheus text-based exposition format.
+//
+// The zero value of the `Metrics` is ready to use. Simply set it to the
+// `Goproxy.Metrics` and mount it at where the Prometheus scrapes, such as
+// "/metrics".
+type Metrics struct {
+	loadOnce sync.Once
+	mutex    sync.Mutex
+	families map[string]*metricFamily
+}
+
+// load loads the stuff of the m up.
+func (m *Metrics) load() {
+	m.families = map[string]*metricFamily{}
+	for _, mf := range []*metricFamily{
+		{
+			name:       "goproxy_requests_total",
+			help:       "Total number of requests.",
+			typ:        "counter",
+			labelNames: []string{"endpoint", "status"},
+		},
+		{
+			name:       "goproxy_cache_requests_total",
+			help:       "Total number of cache lookups.",
+			typ:        "counter",
+			labelNames: []string{"cacher", "result"},
+		},
+		{
+			name:       "goproxy_cache_write_failures_total",
+			help:       "Total number of failed cache writes.",
+			typ:        "counter",
+			labelNames: []string{"cacher"},
+		},
+		{
+			name:       "goproxy_upstream_request_duration_seconds",
+			help:       "Duration of upstream requests.",
+			typ:        "histogram",
+			labelNames: []string{"upstream"},
+		},
+		{
+			name:       "goproxy_upstream_errors_total",
+			help:       "Total number of failed upstream requests.",
+			typ:        "counter",
+			labelNames: []string{"upstream"},
+		},
+		{
+			name: "goproxy_go_bin_queue_depth",
+			help: "Number of Go binary commands waiting.",
+			typ:  "gauge",
+		},
+		{
+			name:       "goproxy_go_bin_duration_seconds",
+			help:       "Duration of Go binary commands.",
+			typ:        "histogram",
+			labelNames: []string{"operation"},
+		},
+		{
+			name:       "goproxy_sumdb_lookup_duration_seconds",
+			help:       "Duration of checksum database lookups.",
+			typ:        "histogram",
+			labelNames: []string{"result"},
+		},
+	} {
+		mf.series = map[string]*metricSeries{}
+		m.families[mf.name] = mf
+	}
+}
+
+// ServeHTTP implements the `http.Handler`.
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	m.loadOnce.Do(m.load)
+
+	rw.Header().Set(
+		"Content-Type",
+		"text/plain; version=0.0.4; charset=utf-8",
+	)
+	setResponseCacheControlHeader(rw, -1)
+
+	bw := bufio.NewWriter(rw)
+	defer bw.Flush()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		m.families[name].writeTo(bw)
+	}
+}
+
+// observeRequest records a request to the endpoint responded with the status.
+func (m *Metrics) observeRequest(endpoint string, status int) {
+	m.add(
+		"goproxy_requests_total",
+		1,
+		endpoint,
+		strconv.Itoa(status),
+	)
+}
+
+// observeCache records a cache lookup of the cacher with the err.
+func (m *Metrics) observeCache(cacher Cacher, err error) {
+	result := "hit"
+	if err == ErrCacheNotFound {
+		result = "miss"
+	} else if err != nil {
+		result = "error"
+	}
+
+	m.add("goproxy_cache_requests_total", 1, cacherName(cacher), result)
+}
+
+// observeCacheWriteFailure records a failed background cache write of the
+// cacher.
+func (m *Metrics) observeCacheWriteFailure(cacher Cacher) {
+	m.add("goproxy_cache_write_failures_total", 1, cacherName(cacher))
+}
+
+// observeUpstream records a request to the upstream that took the d and
+// failed if the failed is true.
+func (m *Metrics) observeUpstream(
+	upstream string,
+	d time.Duration,
+	failed bool,
+) {
+	m.observe(
+		"goproxy_upstream_request_duration_seconds",
+		d.Seconds(),
+		upstream,
+	)
+	if failed {
+		m.add("goproxy_upstream_errors_total", 1, upstream)
+	}
+}
+
+// addGoBinQueueDepth adds the delta to the number of the Go binary commands
+// waiting for a worker.
+func (m *Metrics) addGoBinQueueDepth(delta float64) {
+	m.add("goproxy_go_bin_queue_depth", delta)
+}
+
+// observeGoBin records a Go binary command of the operation that took the d.
+func (m *Metrics) observeGoBin(operation string, d time.Duration) {
+	m.observe("goproxy_go_bin_duration_seconds", d.Seconds(), operation)
+}
+
+// observeSUMDBLookup records a checksum database lookup that took the d with
+// the err.
+func (m *Metrics) observeSUMDBLookup(d time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	m.observe("goproxy_sumdb_lookup_duration_seconds", d.Seconds(), result)
+}
+
+// add adds the v to the counter or gauge series of the name identified by the
+// labelValues. It does nothing if the m is nil.
+func (m *Metrics) add(name string, v float64, labelValues ...string) {
+	if m == nil {
+		return
+	}
+
+	m.loadOnce.Do(m.load)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.families[name].seriesOf(labelValues).value += v
+}
+
+// observe observes the v in the histogram series of the name identified by the
+// labelValues. It does nothing if the m is nil.
+func (m *Metrics) observe(name string, v float64, labelValues ...string) {
+	if m == nil {
+		return
+	}
+
+	m.loadOnce.Do(m.load)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ms := m.families[name].seriesOf(labelValues)
+	if ms.bucketCounts == nil {
+		ms.bucketCounts = make([]uint64, len(metricsDurationBuckets))
+	}
+
+	for i, upperBound := range metricsDurationBuckets {
+		if v <= upperBound {
+			ms.bucketCounts[i]++
+		}
+	}
+
+	ms.value += v
+	ms.count++
+}
+
+// metricFamily is a family of metric series sharing the same name.
+type metricFamily struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	series     map[string]*metricSeries
+}
+
+// metricSeries is a series of a `metricFamily` identified by its label values.
+// For histograms, the value is the sum of all observations.
+type metricSeries struct {
+	labelValues  []string
+	value        float64
+	bucketCounts []uint64
+	count        uint64
+}
+
+// seriesOf returns the series of the mf identified by the labelValues, creating
+// it if it does not exist.
+func (mf *metricFamily) seriesOf(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	ms, ok := mf.series[key]
+	if !ok {
+		ms = &metricSeries{labelValues: labelValues}
+		mf.series[key] = ms
+	}
+
+	return ms
+}
+
+// writeTo writes the mf to the bw in the Prometheus text-based exposition
+// format.
+func (mf *metricFamily) writeTo(bw *bufio.Writer) {
+	fmt.Fprintf(bw, "# HELP %s %s\n", mf.name, mf.help)
+	fmt.Fprintf(bw, "# TYPE %s %s\n", mf.name, mf.typ)
+
+	keys := make([]string, 0, len(mf.series))
+	for key := range mf.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		ms := mf.series[key]
+		if mf.typ != "histogram" {
+			fmt.Fprintf(
+				bw,
+				"%s%s %s\n",
+				mf.name,
+				mf.labels(ms.labelValues),
+				formatMetricValue(ms.value),
+			)
+			continue
+		}
+
+		for i, upperBound := range metricsDurationBuckets {
+			fmt.Fprintf(
+				bw,
+				"%s_bucket%s %d\n",
+				mf.name,
+				mf.labels(
+					ms.labelValues,
+					"le",
+					formatMetricValue(upperBound),
+				),
+				ms.bucketCounts[i],
+			)
+		}
+
+		fmt.Fprintf(
+			bw,
+			"%s_bucket%s %d\n",
+			mf.name,
+			mf.labels(ms.labelValues, "le", "+Inf"),
+			ms.count,
+		)
+		fmt.Fprintf(
+			bw,
+			"%s_sum%s %s\n",
+			mf.name,
+			mf.labels(ms.labelValues),
+			formatMetricValue(ms.value),
+		)
+		fmt.Fprintf(
+			bw,
+			"%s_count%s %d\n",
+			mf.name,
+			mf.labels(ms.labelValues),
+			ms.count,
+		)
+	}
+}
+
+// labels returns the label set of the labelValues followed by the extraPairs
+// of label names and values.
+func (mf *metricFamily) labels(
+	labelValues []string,
+	extraPairs ...string,
+) string {
+	pairs := make([]string, 0, len(labelValues)+len(extraPairs)/2)
+	for i, labelValue := range labelValues {
+		pairs = append(pairs, fmt.Sprintf(
+			"%s=\"%s\"",
+			mf.labelNames[i],
+			escapeMetricLabelValue(labelValue),
+		))
+	}
+
+	for i := 0; i+1 < len(extraPairs); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(
+			"%s=\"%s\"",
+			extraPairs[i],
+			escapeMetricLabelValue(extraPairs[i+1]),
+		))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return fmt.Sprint("{", strings.Join(pairs, ","), "}")
+}
+
+// escapeMetricLabelValue escapes the v to be put between double quotes.
+func escapeMetricLabelValue(v string) string {
+	return metricLabelValueReplacer.Replace(v)
+}
+
+// formatMetricValue formats the v as a Prometheus sample value.
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// cacherName returns the name of the type of the cacher, such as
+// "cacher.Disk".
+func cacherName(cacher Cacher) string {
+	if tc, ok := cacher.(*tracingCacher); ok {
+		cacher = tc.cacher
+	}
+
+	return strings.TrimPrefix(fmt.Sprintf("%T", cacher), "*")
+}
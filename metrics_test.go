@@ -0,0 +1,57 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	m := &Metrics{}
+	m.observeRequest("zip", http.StatusOK)
+	m.observeRequest("zip", http.StatusOK)
+	m.observeCache(&tempCacher{}, ErrCacheNotFound)
+	m.observeUpstream("https://example.com/\"foo\"", time.Second, true)
+	m.addGoBinQueueDepth(1)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(
+		t,
+		"text/plain; version=0.0.4; charset=utf-8",
+		rec.HeaderMap.Get("Content-Type"),
+	)
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE goproxy_requests_total counter",
+		`goproxy_requests_total{endpoint="zip",status="200"} 2`,
+		`goproxy_cache_requests_total{cacher="goproxy.tempCacher",` +
+			`result="miss"} 1`,
+		`goproxy_upstream_request_duration_seconds_bucket{` +
+			`upstream="https://example.com/\"foo\"",le="0.5"} 0`,
+		`goproxy_upstream_request_duration_seconds_bucket{` +
+			`upstream="https://example.com/\"foo\"",le="1"} 1`,
+		`goproxy_upstream_request_duration_seconds_bucket{` +
+			`upstream="https://example.com/\"foo\"",le="+Inf"} 1`,
+		`goproxy_upstream_request_duration_seconds_sum{` +
+			`upstream="https://example.com/\"foo\""} 1`,
+		`goproxy_upstream_errors_total{` +
+			`upstream="https://example.com/\"foo\""} 1`,
+		"# TYPE goproxy_go_bin_queue_depth gauge",
+		"goproxy_go_bin_queue_depth 1",
+	} {
+		assert.Contains(t, strings.Split(body, "\n"), line)
+	}
+
+	var nilMetrics *Metrics
+	assert.NotPanics(t, func() {
+		nilMetrics.observeRequest("zip", http.StatusOK)
+		nilMetrics.observeSUMDBLookup(time.Second, nil)
+	})
+}
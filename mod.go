@@ -2,6 +2,7 @@ package goproxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"os/exec"
 	"sort"
 	"strings"
+	"time"
 
 	"golang.org/x/mod/module"
 	"golang.org/x/mod/semver"
@@ -20,24 +22,77 @@ import (
 // modResult is an unified result of the `mod`.
 type modResult struct {
 	Version  string
+	Time     time.Time
 	Versions []string
 	Info     string
 	GoMod    string
 	Zip      string
+	Origin   *modOrigin
+
+	// Provenance is the name of the JSON file of the `provenance`. It is
+	// set by the `Goproxy` after a "download".
+	Provenance string `json:"-"`
+
+	// Source is the redacted GOPROXY entry or "direct" the result was
+	// obtained from.
+	Source string `json:"-"`
 }
 
-// mod executes the Go modules related commands based on the operation.
+// mod executes the Go modules related commands based on the operation. It is
+// traced as a span of the current span in the ctx.
 func mod(
+	ctx context.Context,
 	operation string,
 	goBinName string,
 	goBinEnv map[string]string,
 	goBinWorkerChan chan struct{},
+	metrics *Metrics,
+	goproxyRoot string,
+	modulePath string,
+	moduleVersion string,
+) (*modResult, error) {
+	ctx, modSpan := startSpan(
+		ctx,
+		fmt.Sprint("mod ", operation),
+		spanKindInternal,
+	)
+	modSpan.setAttribute("goproxy.module.path", modulePath)
+	modSpan.setAttribute("goproxy.module.version", moduleVersion)
+
+	mr, err := doMod(
+		ctx,
+		operation,
+		goBinName,
+		goBinEnv,
+		goBinWorkerChan,
+		metrics,
+		goproxyRoot,
+		modulePath,
+		moduleVersion,
+	)
+	if mr != nil && mr.Source != "" {
+		modSpan.setAttribute("goproxy.upstream", mr.Source)
+	}
+
+	modSpan.end(err)
+
+	return mr, err
+}
+
+// doMod is the untraced implementation of the `mod`.
+func doMod(
+	ctx context.Context,
+	operation string,
+	goBinName string,
+	goBinEnv map[string]string,
+	goBinWorkerChan chan struct{},
+	metrics *Metrics,
 	goproxyRoot string,
 	modulePath string,
 	moduleVersion string,
 ) (*modResult, error) {
 	switch operation {
-	case "lookup", "latest", "list", "download":
+	case "lookup", "latest", "list", "gomod", "download":
 	default:
 		return nil, errors.New("invalid mod operation")
 	}
@@ -102,7 +157,12 @@ func mod(
 				)
 			}
 
-			res, err := http.Get(operationURL.String())
+			res, err := modHTTPGet(
+				ctx,
+				metrics,
+				proxyURL,
+				operationURL,
+			)
 			if err != nil {
 				return nil, err
 			}
@@ -134,6 +194,8 @@ func mod(
 				return nil, err
 			}
 
+			mr.Source = redactedURL(proxyURL)
+
 			return &mr, nil
 		case "list":
 			operationURL := appendURL(
@@ -143,7 +205,12 @@ func mod(
 				"list",
 			)
 
-			res, err := http.Get(operationURL.String())
+			res, err := modHTTPGet(
+				ctx,
+				metrics,
+				proxyURL,
+				operationURL,
+			)
 			if err != nil {
 				return nil, err
 			}
@@ -188,6 +255,68 @@ func mod(
 
 			return &modResult{
 				Versions: versions,
+				Source:   redactedURL(proxyURL),
+			}, nil
+		case "gomod":
+			modFileURL := appendURL(
+				proxyURL,
+				escapedModulePath,
+				"@v",
+				fmt.Sprint(escapedModuleVersion, ".mod"),
+			)
+
+			modFileRes, err := modHTTPGet(
+				ctx,
+				metrics,
+				proxyURL,
+				modFileURL,
+			)
+			if err != nil {
+				return nil, err
+			}
+			defer modFileRes.Body.Close()
+
+			if modFileRes.StatusCode != http.StatusOK {
+				b, err := ioutil.ReadAll(modFileRes.Body)
+				if err != nil {
+					return nil, err
+				}
+
+				switch modFileRes.StatusCode {
+				case http.StatusBadRequest:
+					return nil, fmt.Errorf("%s", b)
+				case http.StatusNotFound, http.StatusGone:
+					lastNotFound = string(b)
+					continue
+				}
+
+				return nil, fmt.Errorf(
+					"GET %s: %s: %s",
+					redactedURL(modFileURL),
+					modFileRes.Status,
+					b,
+				)
+			}
+
+			modFile, err := ioutil.TempFile(goproxyRoot, "mod")
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err := io.Copy(
+				modFile,
+				modFileRes.Body,
+			); err != nil {
+				return nil, err
+			}
+
+			if err := modFile.Close(); err != nil {
+				return nil, err
+			}
+
+			return &modResult{
+				GoMod:  modFile.Name(),
+				Source: redactedURL(proxyURL),
 			}, nil
 		case "download":
 			infoFileURL := appendURL(
@@ -197,7 +326,12 @@ func mod(
 				fmt.Sprint(escapedModuleVersion, ".info"),
 			)
 
-			infoFileRes, err := http.Get(infoFileURL.String())
+			infoFileRes, err := modHTTPGet(
+				ctx,
+				metrics,
+				proxyURL,
+				infoFileURL,
+			)
 			if err != nil {
 				return nil, err
 			}
@@ -241,6 +375,11 @@ func mod(
 				return nil, err
 			}
 
+			infoFileMR, err := readModResult(infoFile.Name())
+			if err != nil {
+				return nil, err
+			}
+
 			modFileURL := appendURL(
 				proxyURL,
 				escapedModulePath,
@@ -248,7 +387,12 @@ func mod(
 				fmt.Sprint(escapedModuleVersion, ".mod"),
 			)
 
-			modFileRes, err := http.Get(modFileURL.String())
+			modFileRes, err := modHTTPGet(
+				ctx,
+				metrics,
+				proxyURL,
+				modFileURL,
+			)
 			if err != nil {
 				return nil, err
 			}
@@ -299,7 +443,12 @@ func mod(
 				fmt.Sprint(escapedModuleVersion, ".zip"),
 			)
 
-			zipFileRes, err := http.Get(zipFileURL.String())
+			zipFileRes, err := modHTTPGet(
+				ctx,
+				metrics,
+				proxyURL,
+				zipFileURL,
+			)
 			if err != nil {
 				return nil, err
 			}
@@ -344,9 +493,11 @@ func mod(
 			}
 
 			return &modResult{
-				Info:  infoFile.Name(),
-				GoMod: modFile.Name(),
-				Zip:   zipFile.Name(),
+				Info:   infoFile.Name(),
+				GoMod:  modFile.Name(),
+				Zip:    zipFile.Name(),
+				Origin: infoFileMR.Origin,
+				Source: redactedURL(proxyURL),
 			}, nil
 		}
 	}
@@ -362,7 +513,9 @@ func mod(
 	// Try direct.
 
 	if goBinWorkerChan != nil {
+		metrics.addGoBinQueueDepth(1)
 		goBinWorkerChan <- struct{}{}
+		metrics.addGoBinQueueDepth(-1)
 		defer func() {
 			<-goBinWorkerChan
 		}()
@@ -370,7 +523,7 @@ func mod(
 
 	var args []string
 	switch operation {
-	case "lookup", "latest":
+	case "lookup", "latest", "gomod":
 		args = []string{
 			"list",
 			"-json",
@@ -413,8 +566,17 @@ func mod(
 		fmt.Sprint("GOTMPDIR=", goproxyRoot),
 	)
 
+	_, cmdSpan := startSpan(
+		ctx,
+		fmt.Sprint(goBinName, " ", strings.Join(args, " ")),
+		spanKindInternal,
+	)
+
 	cmd.Dir = goproxyRoot
+	cmdStartTime := time.Now()
 	stdout, err := cmd.Output()
+	metrics.observeGoBin(operation, time.Since(cmdStartTime))
+	cmdSpan.end(err)
 	if err != nil {
 		output := stdout
 		if len(output) > 0 {
@@ -444,6 +606,86 @@ func mod(
 		return nil, err
 	}
 
+	if operation == "gomod" && mr.GoMod == "" {
+		return nil, fmt.Errorf(
+			"mod %s %s@%s: go.mod not found",
+			operation,
+			modulePath,
+			moduleVersion,
+		)
+	}
+
+	mr.Source = "direct"
+
+	return &mr, nil
+}
+
+// modHTTPGet is like the `http.Get`, but it records the request to the u of the
+// proxyURL in the metrics and traces it as a span of the current span in the
+// ctx.
+//
+// Note that the ctx is only used for tracing, the request will not be canceled
+// when the ctx is done.
+func modHTTPGet(
+	ctx context.Context,
+	metrics *Metrics,
+	proxyURL *url.URL,
+	u *url.URL,
+) (*http.Response, error) {
+	ctx, getSpan := startSpan(ctx, "HTTP GET", spanKindClient)
+	getSpan.setAttribute("http.method", http.MethodGet)
+	getSpan.setAttribute("http.url", redactedURL(u))
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		getSpan.end(err)
+		return nil, err
+	}
+
+	injectTraceparent(ctx, req.Header)
+
+	startTime := time.Now()
+	res, err := http.DefaultClient.Do(req)
+	if err == nil {
+		getSpan.setAttribute("http.status_code", res.StatusCode)
+	}
+
+	getSpan.end(err)
+
+	failed := err != nil
+	if !failed {
+		switch res.StatusCode {
+		case http.StatusOK,
+			http.StatusBadRequest,
+			http.StatusNotFound,
+			http.StatusGone:
+		default:
+			failed = true
+		}
+	}
+
+	metrics.observeUpstream(
+		redactedURL(proxyURL),
+		time.Since(startTime),
+		failed,
+	)
+
+	return res, err
+}
+
+// readModResult reads the `modResult` from the JSON file targeted by the
+// filename.
+func readModResult(filename string) (*modResult, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	mr := modResult{}
+	if err := json.Unmarshal(b, &mr); err != nil {
+		return nil, err
+	}
+
 	return &mr, nil
 }
 
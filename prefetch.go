@@ -0,0 +1,413 @@
+package goproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Prefetcher prefetches module versions into the `Goproxy.Cacher` of a
+// `Goproxy`, such as to warm the cache up before a release freeze. Each module
+// version is fetched and verified through the same path as the
+// `Goproxy.ServeHTTP`.
+type Prefetcher struct {
+	// Goproxy is the `Goproxy` to prefetch for. Its `Cacher` must not be
+	// nil.
+	Goproxy *Goproxy
+
+	// Transitive indicates whether to also prefetch the requirements found
+	// in the go.mod of each prefetched module version, recursively.
+	//
+	// Default value: false
+	Transitive bool
+
+	// MaxConcurrency is the maximum number of module versions prefetched at
+	// the same time.
+	//
+	// If the `MaxConcurrency` is zero, 8 is used.
+	//
+	// Default value: 0
+	MaxConcurrency int
+
+	// Progress is called after each module version is done. It may be
+	// called concurrently.
+	//
+	// Default value: nil
+	Progress func(pp *PrefetchProgress)
+}
+
+// PrefetchProgress is the progress of a `Prefetcher` after a module version is
+// done.
+type PrefetchProgress struct {
+	// Path is the module path of the module version.
+	Path string
+
+	// Version is the module version.
+	Version string
+
+	// Cached reports whether the module version was already cached.
+	Cached bool `json:",omitempty"`
+
+	// Error is the error that failed the module version. It is empty on
+	// success.
+	Error string `json:",omitempty"`
+
+	// Done is the number of the module versions done so far.
+	Done int
+
+	// Total is the number of the module versions known so far. It grows as
+	// the transitive requirements are found.
+	Total int
+}
+
+// PrefetchReport is the report of a `Prefetcher` after all module versions are
+// done.
+type PrefetchReport struct {
+	// Total is the number of the module versions.
+	Total int
+
+	// Fetched is the number of the module versions fetched.
+	Fetched int
+
+	// Cached is the number of the module versions already cached.
+	Cached int
+
+	// Failures is the failed module versions.
+	Failures []*PrefetchProgress
+}
+
+// Prefetch prefetches the moduleVersions. It returns an error only if the
+// prefetching cannot start, per-module failures are reported in the
+// `PrefetchReport`.
+func (p *Prefetcher) Prefetch(
+	ctx context.Context,
+	moduleVersions []module.Version,
+) (*PrefetchReport, error) {
+	g, release := p.Goproxy.acquireSnapshot()
+	defer release()
+
+	if g.Cacher == nil {
+		return nil, errors.New("no cacher")
+	}
+
+	maxConcurrency := p.MaxConcurrency
+	if maxConcurrency == 0 {
+		maxConcurrency = 8
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mutex     sync.Mutex
+		seen      = map[module.Version]bool{}
+		report    = &PrefetchReport{}
+		semaphore = make(chan struct{}, maxConcurrency)
+	)
+
+	var enqueue func(mv module.Version)
+	enqueue = func(mv module.Version) {
+		mutex.Lock()
+		if seen[mv] {
+			mutex.Unlock()
+			return
+		}
+
+		seen[mv] = true
+		report.Total++
+		mutex.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var (
+				cached bool
+				goMod  []byte
+				err    error
+			)
+			select {
+			case semaphore <- struct{}{}:
+				cached, goMod, err = p.prefetch(ctx, g, mv)
+				<-semaphore
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			pp := &PrefetchProgress{
+				Path:    mv.Path,
+				Version: mv.Version,
+				Cached:  cached,
+			}
+			if err != nil {
+				pp.Error = err.Error()
+				g.logger.Log(
+					LogLevelWarn,
+					"prefetch failed",
+					"operation",
+					"prefetch",
+					"module",
+					mv.Path,
+					"version",
+					mv.Version,
+					"error",
+					err,
+				)
+			}
+
+			mutex.Lock()
+			switch {
+			case err != nil:
+				report.Failures = append(report.Failures, pp)
+			case cached:
+				report.Cached++
+			default:
+				report.Fetched++
+			}
+
+			pp.Done = report.Cached +
+				report.Fetched +
+				len(report.Failures)
+			pp.Total = report.Total
+			mutex.Unlock()
+
+			if p.Progress != nil {
+				p.Progress(pp)
+			}
+
+			if err != nil || !p.Transitive {
+				return
+			}
+
+			requirements, err := goModRequirements(goMod)
+			if err != nil {
+				return
+			}
+
+			for _, r := range requirements {
+				enqueue(r)
+			}
+		}()
+	}
+
+	for _, mv := range moduleVersions {
+		enqueue(mv)
+	}
+
+	wg.Wait()
+
+	return report, nil
+}
+
+// prefetch prefetches the mv with the snapshot g of the `Goproxy`. It reports
+// whether the mv was already cached and returns its go.mod. The mv is only
+// considered cached if all of its ".info", ".mod", and ".zip" are, so a ".zip"
+// skipped for exceeding the `Goproxy.MaxZIPCacheBytes` is fetched again.
+func (p *Prefetcher) prefetch(
+	ctx context.Context,
+	g *Goproxy,
+	mv module.Version,
+) (bool, []byte, error) {
+	if !semver.IsValid(mv.Version) {
+		return false, nil, errors.New("invalid version")
+	}
+
+	escapedModulePath, err := module.EscapePath(mv.Path)
+	if err != nil {
+		return false, nil, err
+	}
+
+	escapedModuleVersion, err := module.EscapeVersion(mv.Version)
+	if err != nil {
+		return false, nil, err
+	}
+
+	namePrefix := fmt.Sprint(
+		escapedModulePath,
+		"/@v/",
+		escapedModuleVersion,
+	)
+
+	goMod, err := cacheBytes(ctx, g.Cacher, fmt.Sprint(namePrefix, ".mod"))
+	if err == nil {
+		cached := true
+		for _, nameExt := range []string{".info", ".zip"} {
+			cache, err := g.Cacher.Cache(
+				ctx,
+				fmt.Sprint(namePrefix, nameExt),
+			)
+			if err == ErrCacheNotFound {
+				cached = false
+				break
+			} else if err != nil {
+				return false, nil, err
+			}
+
+			cache.Close()
+		}
+
+		if cached {
+			return true, goMod, nil
+		}
+	} else if err != ErrCacheNotFound {
+		return false, nil, err
+	}
+
+	goproxyRoot, err := ioutil.TempDir("", "goproxy")
+	if err != nil {
+		return false, nil, err
+	}
+	defer func() {
+		modClean(g.GoBinName, g.goBinEnv, goproxyRoot)
+		os.RemoveAll(goproxyRoot)
+	}()
+
+	mr, err := g.download(ctx, goproxyRoot, mv.Path, mv.Version)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if err := g.writeCaches(ctx, g.Cacher, namePrefix, mr); err != nil {
+		return false, nil, err
+	}
+
+	goMod, err = ioutil.ReadFile(mr.GoMod)
+	if err != nil {
+		return false, nil, err
+	}
+
+	return false, goMod, nil
+}
+
+// ParsePrefetchList parses the module versions to be prefetched out of the b
+// in the format. The format is one of the "go.mod", the "go.sum", and the
+// "list". The "list" is a plain list of "path@version", one per line, where
+// blank lines and lines starting with "#" are ignored.
+func ParsePrefetchList(format string, b []byte) ([]module.Version, error) {
+	var mvs []module.Version
+	switch format {
+	case "go.mod":
+		f, err := modfile.Parse("go.mod", b, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range f.Require {
+			mvs = append(mvs, r.Mod)
+		}
+
+		for _, r := range f.Replace {
+			if r.New.Version != "" {
+				mvs = append(mvs, r.New)
+			}
+		}
+	case "go.sum":
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) == 0 {
+				continue
+			}
+
+			if len(fields) != 3 {
+				return nil, fmt.Errorf(
+					"malformed go.sum line: %q",
+					s.Text(),
+				)
+			}
+
+			mvs = append(mvs, module.Version{
+				Path: fields[0],
+				Version: strings.TrimSuffix(
+					fields[1],
+					"/go.mod",
+				),
+			})
+		}
+
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	case "list":
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			i := strings.LastIndex(line, "@")
+			if i <= 0 {
+				return nil, fmt.Errorf(
+					"malformed module version: %q",
+					line,
+				)
+			}
+
+			mvs = append(mvs, module.Version{
+				Path:    line[:i],
+				Version: line[i+1:],
+			})
+		}
+
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format: %q", format)
+	}
+
+	return uniqueModuleVersions(mvs), nil
+}
+
+// goModRequirements returns the requirements of the goMod.
+func goModRequirements(goMod []byte) ([]module.Version, error) {
+	f, err := modfile.ParseLax("go.mod", goMod, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	mvs := make([]module.Version, 0, len(f.Require))
+	for _, r := range f.Require {
+		mvs = append(mvs, r.Mod)
+	}
+
+	return mvs, nil
+}
+
+// uniqueModuleVersions returns the mvs without duplicates, keeping the order.
+func uniqueModuleVersions(mvs []module.Version) []module.Version {
+	seen := make(map[module.Version]bool, len(mvs))
+	unique := mvs[:0]
+	for _, mv := range mvs {
+		if !seen[mv] {
+			seen[mv] = true
+			unique = append(unique, mv)
+		}
+	}
+
+	return unique
+}
+
+// cacheBytes returns the content of the cache of the name from the cacher.
+func cacheBytes(
+	ctx context.Context,
+	cacher Cacher,
+	name string,
+) ([]byte, error) {
+	cache, err := cacher.Cache(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer cache.Close()
+
+	return ioutil.ReadAll(cache)
+}
@@ -0,0 +1,156 @@
+package goproxy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/mod/module"
+)
+
+func TestParsePrefetchList(t *testing.T) {
+	mvs, err := ParsePrefetchList("go.mod", []byte(`module example.com/foo
+
+require (
+	example.com/bar v1.0.0
+	example.com/baz v1.1.0 // indirect
+)
+
+replace example.com/baz => example.com/qux v1.2.0
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []module.Version{
+		{Path: "example.com/bar", Version: "v1.0.0"},
+		{Path: "example.com/baz", Version: "v1.1.0"},
+		{Path: "example.com/qux", Version: "v1.2.0"},
+	}, mvs)
+
+	mvs, err = ParsePrefetchList("go.sum", []byte(`
+example.com/bar v1.0.0 h1:AAAA=
+example.com/bar v1.0.0/go.mod h1:BBBB=
+example.com/baz v1.1.0/go.mod h1:CCCC=
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []module.Version{
+		{Path: "example.com/bar", Version: "v1.0.0"},
+		{Path: "example.com/baz", Version: "v1.1.0"},
+	}, mvs)
+
+	_, err = ParsePrefetchList("go.sum", []byte("example.com/bar\n"))
+	assert.Error(t, err)
+
+	mvs, err = ParsePrefetchList("list", []byte(`
+# Comment
+example.com/bar@v1.0.0
+example.com/bar@v1.0.0
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []module.Version{
+		{Path: "example.com/bar", Version: "v1.0.0"},
+	}, mvs)
+
+	_, err = ParsePrefetchList("list", []byte("example.com/bar\n"))
+	assert.Error(t, err)
+
+	_, err = ParsePrefetchList("foobar", nil)
+	assert.Error(t, err)
+}
+
+func TestPrefetcher(t *testing.T) {
+	g := New()
+	g.Cacher = &mapCacher{caches: map[string][]byte{
+		"example.com/foo/@v/v1.0.0.info": []byte("{}"),
+		"example.com/foo/@v/v1.0.0.zip":  []byte("zip"),
+		"example.com/foo/@v/v1.0.0.mod": []byte(
+			"module example.com/foo\n" +
+				"require example.com/bar v1.0.0\n",
+		),
+		"example.com/bar/@v/v1.0.0.info": []byte("{}"),
+		"example.com/bar/@v/v1.0.0.zip":  []byte("zip"),
+		"example.com/bar/@v/v1.0.0.mod": []byte(
+			"module example.com/bar\n" +
+				"require example.com/foo v1.0.0\n",
+		),
+	}}
+
+	var (
+		mutex      sync.Mutex
+		progresses []*PrefetchProgress
+	)
+	p := &Prefetcher{
+		Goproxy:    g,
+		Transitive: true,
+		Progress: func(pp *PrefetchProgress) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			progresses = append(progresses, pp)
+		},
+	}
+
+	report, err := p.Prefetch(context.Background(), []module.Version{
+		{Path: "example.com/foo", Version: "v1.0.0"},
+		{Path: "example.com/foo", Version: "latest"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Total)
+	assert.Equal(t, 2, report.Cached)
+	assert.Equal(t, 0, report.Fetched)
+	assert.Len(t, report.Failures, 1)
+	assert.Equal(t, "latest", report.Failures[0].Version)
+	assert.Equal(t, "invalid version", report.Failures[0].Error)
+	assert.Len(t, progresses, 3)
+
+	p = &Prefetcher{Goproxy: New()}
+	_, err = p.Prefetch(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestPrefetcherPartiallyCached(t *testing.T) {
+	upstreamURL, closeUpstream := newTestModuleProxy(
+		t,
+		"example.com/foo",
+		map[string]time.Time{"v1.0.0": time.Now()},
+	)
+	defer closeUpstream()
+
+	// A module version missing its ".zip" is fetched again.
+	mc := &mapCacher{caches: map[string][]byte{
+		"example.com/foo/@v/v1.0.0.info": []byte("{}"),
+		"example.com/foo/@v/v1.0.0.mod": []byte(
+			"module example.com/foo\n",
+		),
+	}}
+
+	g := New()
+	g.Cacher = mc
+	g.GoBinEnv = append(
+		g.GoBinEnv,
+		"GOPROXY="+upstreamURL,
+		"GOSUMDB=off",
+	)
+
+	p := &Prefetcher{Goproxy: g}
+	report, err := p.Prefetch(context.Background(), []module.Version{
+		{Path: "example.com/foo", Version: "v1.0.0"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Total)
+	assert.Equal(t, 0, report.Cached)
+	assert.Equal(t, 1, report.Fetched)
+	assert.Empty(t, report.Failures)
+
+	_, err = cacheBytes(
+		context.Background(),
+		mc,
+		"example.com/foo/@v/v1.0.0.zip",
+	)
+	assert.NoError(t, err)
+
+	report, err = p.Prefetch(context.Background(), []module.Version{
+		{Path: "example.com/foo", Version: "v1.0.0"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Cached)
+}
@@ -0,0 +1,28 @@
+package goproxy
+
+import (
+	"net/http"
+	"path"
+)
+
+// presignedRedirectURL returns the presigned URL of the cache that the r should
+// be redirected to. It returns an empty string if the r should be served by
+// the g as usual.
+func (g *Goproxy) presignedRedirectURL(
+	r *http.Request,
+	cache Cache,
+) (string, error) {
+	if !g.PresignedRedirect ||
+		r.Method != http.MethodGet ||
+		path.Ext(cache.Name()) != ".zip" ||
+		cache.Size() < g.PresignMinBytes {
+		return "", nil
+	}
+
+	cp, ok := g.Cacher.(CachePresigner)
+	if !ok {
+		return "", nil
+	}
+
+	return cp.PresignCache(r.Context(), cache.Name(), g.PresignExpiry)
+}
@@ -0,0 +1,105 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type presigningCacher struct {
+	mapCacher
+	err error
+}
+
+func (pc *presigningCacher) PresignCache(
+	ctx context.Context,
+	name string,
+	expiry time.Duration,
+) (string, error) {
+	if pc.err != nil {
+		return "", pc.err
+	}
+
+	return "https://bucket.example.com/" + name + "?expiry=" +
+		expiry.String(), nil
+}
+
+type memoryAuditor struct {
+	records []*AuditRecord
+}
+
+func (ma *memoryAuditor) Audit(ctx context.Context, ar *AuditRecord) error {
+	ma.records = append(ma.records, ar)
+	return nil
+}
+
+func TestGoproxyPresignedRedirect(t *testing.T) {
+	zipName := "example.com/foo/@v/v1.0.0.zip"
+	modName := "example.com/foo/@v/v1.0.0.mod"
+	pc := &presigningCacher{mapCacher: mapCacher{caches: map[string][]byte{
+		zipName: []byte("zip content"),
+		modName: []byte("module example.com/foo\n"),
+	}}}
+
+	ma := &memoryAuditor{}
+
+	g := New()
+	g.Cacher = pc
+	g.Auditor = ma
+	g.PresignedRedirect = true
+
+	do := func(method, name string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(method, "/"+name, nil))
+		return rec
+	}
+
+	rec := do(http.MethodGet, zipName)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(
+		t,
+		"https://bucket.example.com/"+zipName+"?expiry=15m0s",
+		rec.Header().Get("Location"),
+	)
+	assert.Equal(
+		t,
+		"must-revalidate, no-cache, no-store",
+		rec.Header().Get("Cache-Control"),
+	)
+	if assert.Len(t, ma.records, 1) {
+		assert.True(t, ma.records[0].CacheHit)
+		assert.True(t, ma.records[0].Redirected)
+	}
+
+	rec = do(http.MethodHead, zipName)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = do(http.MethodGet, modName)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "module example.com/foo\n", rec.Body.String())
+
+	g.PresignMinBytes = 1 << 20
+	rec = do(http.MethodGet, zipName)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "zip content", rec.Body.String())
+
+	g.PresignMinBytes = 0
+	pc.err = errors.New("presign failed")
+	rec = do(http.MethodGet, zipName)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "zip content", rec.Body.String())
+
+	g.PresignedRedirect = false
+	pc.err = nil
+	rec = do(http.MethodGet, zipName)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "zip content", rec.Body.String())
+	if assert.Len(t, ma.records, 6) {
+		assert.False(t, ma.records[5].Redirected)
+	}
+}
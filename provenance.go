@@ -0,0 +1,175 @@
+package goproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// modOrigin is the origin of a module version. It is reported by the Go binary
+// (see `go help mod download`) and by the ".info" of some proxies.
+type modOrigin struct {
+	VCS  string `json:",omitempty"`
+	URL  string `json:",omitempty"`
+	Hash string `json:",omitempty"`
+	Ref  string `json:",omitempty"`
+}
+
+// provenance is the record of where a module version came from when it was
+// fetched by the `Goproxy`. It is cached alongside the module files as the
+// ".provenance" and served as is.
+type provenance struct {
+	// Path is the module path.
+	Path string
+
+	// Version is the module version.
+	Version string
+
+	// Source is the redacted GOPROXY entry or "direct" the module version
+	// was fetched from.
+	Source string
+
+	// VCS is the version control system of the module version, such as
+	// "git". It is empty if unknown.
+	VCS string `json:",omitempty"`
+
+	// URL is the URL of the repository of the module version. It is empty
+	// if unknown.
+	URL string `json:",omitempty"`
+
+	// Revision is the VCS revision of the module version. It is empty if
+	// unknown.
+	Revision string `json:",omitempty"`
+
+	// Ref is the VCS ref of the module version, such as "refs/tags/v1.0.0".
+	// It is empty if unknown.
+	Ref string `json:",omitempty"`
+
+	// FetchTime is the time when the module version was fetched.
+	FetchTime time.Time
+
+	// SUMDB is the name of the checksum database that verified the module
+	// version. It is empty if the module version was not verified.
+	SUMDB string `json:",omitempty"`
+
+	// SUMDBTreeSize is the size of the latest signed tree of the `SUMDB`
+	// at the time of the verification.
+	SUMDBTreeSize int64 `json:",omitempty"`
+}
+
+// newProvenance returns a new instance of the `provenance` of the moduleVersion
+// of the modulePath fetched as the mr.
+func newProvenance(
+	modulePath string,
+	moduleVersion string,
+	mr *modResult,
+) *provenance {
+	pv := &provenance{
+		Path:      modulePath,
+		Version:   moduleVersion,
+		Source:    mr.Source,
+		FetchTime: time.Now().UTC(),
+	}
+
+	if mr.Origin != nil {
+		pv.VCS = mr.Origin.VCS
+		pv.URL = mr.Origin.URL
+		pv.Revision = mr.Origin.Hash
+		pv.Ref = mr.Origin.Ref
+	}
+
+	return pv
+}
+
+// writeProvenance writes the pv as JSON to a new temporary file in the dir and
+// returns its name.
+func writeProvenance(dir string, pv *provenance) (string, error) {
+	b, err := json.Marshal(pv)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := ioutil.TempFile(dir, "provenance")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// addInfoOrigin adds the origin as the "Origin" to the JSON file targeted by
+// the infoFilename if it does not have one yet. The "Origin" is spliced in as
+// the last field, so the existing fields are kept byte for byte and in order.
+// The result is written to a new temporary file in the dir, and its name is
+// returned. The infoFilename is returned as is if nothing needs to be added.
+func addInfoOrigin(
+	dir string,
+	infoFilename string,
+	origin *modOrigin,
+) (string, error) {
+	if origin == nil || origin.VCS == "" {
+		return infoFilename, nil
+	}
+
+	b, err := ioutil.ReadFile(infoFilename)
+	if err != nil {
+		return "", err
+	}
+
+	info := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &info); err != nil {
+		return "", err
+	}
+
+	if _, ok := info["Origin"]; ok {
+		return infoFilename, nil
+	}
+
+	object := bytes.TrimRight(b, " \t\r\n")
+	if !bytes.HasSuffix(object, []byte("}")) {
+		return "", errors.New("info is not a JSON object")
+	}
+
+	originJSON, err := json.Marshal(origin)
+	if err != nil {
+		return "", err
+	}
+
+	spliced := make([]byte, 0, len(b)+len(originJSON)+len(`,"Origin":`))
+	spliced = append(spliced, object[:len(object)-1]...)
+	if len(info) > 0 {
+		spliced = append(spliced, ',')
+	}
+
+	spliced = append(spliced, `"Origin":`...)
+	spliced = append(spliced, originJSON...)
+	spliced = append(spliced, '}')
+	b = append(spliced, b[len(object):]...)
+
+	file, err := ioutil.TempFile(dir, "info")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	return file.Name(), nil
+}
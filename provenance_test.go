@@ -0,0 +1,151 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProvenance(t *testing.T) {
+	pv := newProvenance("example.com/foo", "v1.0.0", &modResult{
+		Source: "https://proxy.example.com",
+		Origin: &modOrigin{
+			VCS:  "git",
+			URL:  "https://example.com/foo",
+			Hash: "0123456789abcdef",
+			Ref:  "refs/tags/v1.0.0",
+		},
+	})
+	assert.Equal(t, "example.com/foo", pv.Path)
+	assert.Equal(t, "v1.0.0", pv.Version)
+	assert.Equal(t, "https://proxy.example.com", pv.Source)
+	assert.Equal(t, "git", pv.VCS)
+	assert.Equal(t, "https://example.com/foo", pv.URL)
+	assert.Equal(t, "0123456789abcdef", pv.Revision)
+	assert.Equal(t, "refs/tags/v1.0.0", pv.Ref)
+	assert.WithinDuration(t, time.Now(), pv.FetchTime, time.Minute)
+	assert.Equal(t, time.UTC, pv.FetchTime.Location())
+
+	pv = newProvenance("example.com/foo", "v1.0.0", &modResult{
+		Source: "direct",
+	})
+	assert.Equal(t, "direct", pv.Source)
+	assert.Empty(t, pv.VCS)
+	assert.Empty(t, pv.Revision)
+}
+
+func TestWriteProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pv := &provenance{
+		Path:          "example.com/foo",
+		Version:       "v1.0.0",
+		Source:        "direct",
+		FetchTime:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		SUMDB:         "sum.golang.org",
+		SUMDBTreeSize: 42,
+	}
+
+	filename, err := writeProvenance(dir, pv)
+	assert.NoError(t, err)
+	assert.Equal(t, dir, filepath.Dir(filename))
+
+	b, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+	"Path": "example.com/foo",
+	"Version": "v1.0.0",
+	"Source": "direct",
+	"FetchTime": "2020-01-01T00:00:00Z",
+	"SUMDB": "sum.golang.org",
+	"SUMDBTreeSize": 42
+}`, string(b))
+
+	var got provenance
+	assert.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, *pv, got)
+
+	_, err = writeProvenance(filepath.Join(dir, "missing"), pv)
+	assert.Error(t, err)
+}
+
+func TestAddInfoOrigin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	origin := &modOrigin{VCS: "git", URL: "https://example.com/foo"}
+
+	for _, tc := range []struct {
+		info   string
+		origin *modOrigin
+		want   string
+	}{
+		{
+			`{"Version":"v1.0.0","Time":"2020-01-01T00:00:00Z"}`,
+			origin,
+			`{"Version":"v1.0.0","Time":"2020-01-01T00:00:00Z",` +
+				`"Origin":{"VCS":"git",` +
+				`"URL":"https://example.com/foo"}}`,
+		},
+		{
+			"{\n\t\"Version\": \"v1.0.0\",\n\t\"Time\": 1\n}\n",
+			origin,
+			"{\n\t\"Version\": \"v1.0.0\",\n\t\"Time\": 1\n" +
+				`,"Origin":{"VCS":"git",` +
+				`"URL":"https://example.com/foo"}}` +
+				"\n",
+		},
+		{
+			`{}`,
+			origin,
+			`{"Origin":{"VCS":"git",` +
+				`"URL":"https://example.com/foo"}}`,
+		},
+		{
+			`{"Version":"v1.0.0","Origin":{"VCS":"hg"}}`,
+			origin,
+			`{"Version":"v1.0.0","Origin":{"VCS":"hg"}}`,
+		},
+		{`{"Version":"v1.0.0"}`, nil, `{"Version":"v1.0.0"}`},
+		{`{"Version":"v1.0.0"}`, &modOrigin{}, `{"Version":"v1.0.0"}`},
+	} {
+		infoFile, err := ioutil.TempFile(dir, "info")
+		assert.NoError(t, err)
+		_, err = infoFile.WriteString(tc.info)
+		assert.NoError(t, err)
+		assert.NoError(t, infoFile.Close())
+
+		filename, err := addInfoOrigin(dir, infoFile.Name(), tc.origin)
+		assert.NoError(t, err)
+		if tc.info == tc.want {
+			assert.Equal(t, infoFile.Name(), filename)
+		}
+
+		b, err := ioutil.ReadFile(filename)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.want, string(b))
+		assert.True(t, json.Valid(b))
+	}
+
+	for _, info := range []string{`not json`, `null`} {
+		infoFile, err := ioutil.TempFile(dir, "info")
+		assert.NoError(t, err)
+		_, err = infoFile.WriteString(info)
+		assert.NoError(t, err)
+		assert.NoError(t, infoFile.Close())
+
+		_, err = addInfoOrigin(dir, infoFile.Name(), origin)
+		assert.Error(t, err, info)
+	}
+
+	_, err = addInfoOrigin(dir, filepath.Join(dir, "missing"), origin)
+	assert.Error(t, err)
+}
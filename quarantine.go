@@ -0,0 +1,329 @@
+package goproxy
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// quarantineApplies reports whether the quarantine applies to the modulePath.
+func (g *Goproxy) quarantineApplies(modulePath string) bool {
+	return g.QuarantinePeriod > 0 &&
+		!globsMatchPath(g.quarantineExemptions, modulePath)
+}
+
+// quarantined reports whether a module version published at the t is still in
+// quarantine.
+func (g *Goproxy) quarantined(t time.Time) bool {
+	return time.Now().Before(t.Add(g.QuarantinePeriod))
+}
+
+// quarantineMessage returns the message that explains why the moduleVersion of
+// the modulePath published at the t is not served.
+func (g *Goproxy) quarantineMessage(
+	modulePath string,
+	moduleVersion string,
+	t time.Time,
+) string {
+	return fmt.Sprintf(
+		"%s@%s is quarantined until %s",
+		modulePath,
+		moduleVersion,
+		t.Add(g.QuarantinePeriod).UTC().Format(time.RFC3339),
+	)
+}
+
+// maxPublishTimes is the maximum number of the publish times kept by a
+// `publishTimeCache`.
+const maxPublishTimes = 1 << 16
+
+// maxPublishTimeLookups is the maximum number of the publish times looked up
+// from the upstream by a single `unquarantinedVersions`.
+const maxPublishTimeLookups = 8
+
+// publishTimeCache is the cache of the publish times of the module versions.
+// The publish times never change, so they are kept until the cache is full, at
+// which point the least recently used one is evicted.
+type publishTimeCache struct {
+	mutex    sync.Mutex
+	lru      *list.List
+	elements map[string]*list.Element
+}
+
+// publishTimeEntry is an entry of a `publishTimeCache`.
+type publishTimeEntry struct {
+	key string
+	t   time.Time
+}
+
+// get returns the publish time of the moduleVersion of the modulePath.
+func (ptc *publishTimeCache) get(
+	modulePath string,
+	moduleVersion string,
+) (time.Time, bool) {
+	ptc.mutex.Lock()
+	defer ptc.mutex.Unlock()
+
+	e, ok := ptc.elements[modulePath+"@"+moduleVersion]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	ptc.lru.MoveToFront(e)
+
+	return e.Value.(*publishTimeEntry).t, true
+}
+
+// set sets the t as the publish time of the moduleVersion of the modulePath.
+func (ptc *publishTimeCache) set(
+	modulePath string,
+	moduleVersion string,
+	t time.Time,
+) {
+	ptc.mutex.Lock()
+	defer ptc.mutex.Unlock()
+
+	if ptc.lru == nil {
+		ptc.lru = list.New()
+		ptc.elements = map[string]*list.Element{}
+	}
+
+	key := modulePath + "@" + moduleVersion
+	if e, ok := ptc.elements[key]; ok {
+		e.Value.(*publishTimeEntry).t = t
+		ptc.lru.MoveToFront(e)
+		return
+	}
+
+	for ptc.lru.Len() >= maxPublishTimes {
+		pte := ptc.lru.Remove(ptc.lru.Back()).(*publishTimeEntry)
+		delete(ptc.elements, pte.key)
+	}
+
+	ptc.elements[key] = ptc.lru.PushFront(&publishTimeEntry{key: key, t: t})
+}
+
+// cachedModuleVersionTime returns the publish time of the moduleVersion of the
+// modulePath from the publish times already known to the g or the ".info"
+// cache in the cacher. It returns the `ErrCacheNotFound` if neither has it.
+func (g *Goproxy) cachedModuleVersionTime(
+	ctx context.Context,
+	cacher Cacher,
+	modulePath string,
+	moduleVersion string,
+) (time.Time, error) {
+	if t, ok := g.publishTimes.get(modulePath, moduleVersion); ok {
+		return t, nil
+	}
+
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	cache, err := cacher.Cache(ctx, fmt.Sprint(
+		escapedModulePath,
+		"/@v/",
+		escapedModuleVersion,
+		".info",
+	))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer cache.Close()
+
+	b, err := ioutil.ReadAll(cache)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	mr := modResult{}
+	if err := json.Unmarshal(b, &mr); err != nil {
+		return time.Time{}, err
+	}
+
+	g.publishTimes.set(modulePath, moduleVersion, mr.Time)
+
+	return mr.Time, nil
+}
+
+// moduleVersionTime returns the publish time of the moduleVersion of the
+// modulePath. It prefers the `cachedModuleVersionTime`, and falls back to a
+// "lookup" `mod`.
+func (g *Goproxy) moduleVersionTime(
+	ctx context.Context,
+	cacher Cacher,
+	goproxyRoot string,
+	modulePath string,
+	moduleVersion string,
+) (time.Time, error) {
+	t, err := g.cachedModuleVersionTime(
+		ctx,
+		cacher,
+		modulePath,
+		moduleVersion,
+	)
+	if err != ErrCacheNotFound {
+		return t, err
+	}
+
+	return g.lookupModuleVersionTime(
+		ctx,
+		goproxyRoot,
+		modulePath,
+		moduleVersion,
+	)
+}
+
+// lookupModuleVersionTime looks up the publish time of the moduleVersion of the
+// modulePath from the upstream.
+func (g *Goproxy) lookupModuleVersionTime(
+	ctx context.Context,
+	goproxyRoot string,
+	modulePath string,
+	moduleVersion string,
+) (time.Time, error) {
+	mr, err := mod(
+		ctx,
+		"lookup",
+		g.GoBinName,
+		g.goBinEnv,
+		g.goBinWorkerChan,
+		g.Metrics,
+		goproxyRoot,
+		modulePath,
+		moduleVersion,
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	g.publishTimes.set(modulePath, moduleVersion, mr.Time)
+
+	return mr.Time, nil
+}
+
+// unquarantinedVersions returns the versions of the modulePath that are no
+// longer in quarantine. The versions whose publish times cannot be determined
+// are treated as quarantined.
+//
+// The publish times that are neither known to the g nor cached in the cacher
+// are looked up from the upstream, at most `maxPublishTimeLookups` of them,
+// newest first. The rest are treated as quarantined until they are looked up
+// by later calls, so that a module with many versions does not stall a single
+// request.
+func (g *Goproxy) unquarantinedVersions(
+	ctx context.Context,
+	cacher Cacher,
+	goproxyRoot string,
+	modulePath string,
+	versions []string,
+) []string {
+	logError := func(version string, err error) {
+		if regModuleVersionNotFound.MatchString(err.Error()) {
+			return
+		}
+
+		g.logger.Log(
+			LogLevelWarn,
+			"failed to determine publish time",
+			"operation",
+			"quarantine",
+			"module",
+			modulePath,
+			"version",
+			version,
+			"error",
+			err,
+		)
+	}
+
+	times := make(map[string]time.Time, len(versions))
+	var unknown []string
+	for _, version := range versions {
+		t, err := g.cachedModuleVersionTime(
+			ctx,
+			cacher,
+			modulePath,
+			version,
+		)
+		if err == nil {
+			times[version] = t
+		} else if err == ErrCacheNotFound {
+			unknown = append(unknown, version)
+		} else {
+			logError(version, err)
+		}
+	}
+
+	sort.Slice(unknown, func(i, j int) bool {
+		return semver.Compare(unknown[i], unknown[j]) > 0
+	})
+
+	if len(unknown) > maxPublishTimeLookups {
+		unknown = unknown[:maxPublishTimeLookups]
+	}
+
+	for _, version := range unknown {
+		t, err := g.lookupModuleVersionTime(
+			ctx,
+			goproxyRoot,
+			modulePath,
+			version,
+		)
+		if err != nil {
+			logError(version, err)
+			continue
+		}
+
+		times[version] = t
+	}
+
+	unquarantined := make([]string, 0, len(versions))
+	for _, version := range versions {
+		if t, ok := times[version]; ok && !g.quarantined(t) {
+			unquarantined = append(unquarantined, version)
+		}
+	}
+
+	return unquarantined
+}
+
+// latestVersion returns the latest version of the versions. Release versions
+// are preferred over pre-release versions. It returns "" if the versions is
+// empty.
+func latestVersion(versions []string) string {
+	var latest, latestPrerelease string
+	for _, version := range versions {
+		if !semver.IsValid(version) {
+			continue
+		}
+
+		if semver.Prerelease(version) == "" {
+			if semver.Compare(version, latest) > 0 {
+				latest = version
+			}
+		} else if semver.Compare(version, latestPrerelease) > 0 {
+			latestPrerelease = version
+		}
+	}
+
+	if latest != "" {
+		return latest
+	}
+
+	return latestPrerelease
+}
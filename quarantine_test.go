@@ -0,0 +1,265 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type infoErrorCacher struct {
+	mapCacher
+}
+
+func (iec *infoErrorCacher) Cache(
+	ctx context.Context,
+	name string,
+) (Cache, error) {
+	return nil, errors.New("broken")
+}
+
+func TestGoproxyQuarantined(t *testing.T) {
+	g := New()
+	g.QuarantinePeriod = time.Hour
+	g.QuarantineExemptions = []string{"example.com/exempt"}
+	g.snapshot()
+
+	assert.True(t, g.quarantineApplies("example.com/foo"))
+	assert.False(t, g.quarantineApplies("example.com/exempt"))
+	assert.True(t, g.quarantined(time.Now().Add(-time.Minute)))
+	assert.False(t, g.quarantined(time.Now().Add(-2*time.Hour)))
+
+	publishTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(
+		t,
+		"example.com/foo@v1.0.0 is quarantined until "+
+			"2020-01-01T01:00:00Z",
+		g.quarantineMessage("example.com/foo", "v1.0.0", publishTime),
+	)
+
+	g = New()
+	g.snapshot()
+	assert.False(t, g.quarantineApplies("example.com/foo"))
+}
+
+func TestLatestVersion(t *testing.T) {
+	for _, tc := range []struct {
+		versions []string
+		latest   string
+	}{
+		{nil, ""},
+		{[]string{"v1.0.0", "v1.2.0", "v1.1.0"}, "v1.2.0"},
+		{[]string{"v1.0.0", "v1.1.0-beta"}, "v1.0.0"},
+		{[]string{"v1.0.0-alpha", "v1.0.0-beta"}, "v1.0.0-beta"},
+		{[]string{"foobar", "v0.1.0"}, "v0.1.0"},
+		{[]string{"foobar"}, ""},
+	} {
+		assert.Equal(t, tc.latest, latestVersion(tc.versions))
+	}
+}
+
+func TestGoproxyUnquarantinedVersions(t *testing.T) {
+	upstreamURL, closeUpstream := newTestModuleProxy(
+		t,
+		"example.com/foo",
+		map[string]time.Time{
+			"v1.0.0": time.Now().Add(-48 * time.Hour),
+			"v1.1.0": time.Now().Add(-48 * time.Hour),
+			"v1.2.0": time.Now(),
+		},
+	)
+
+	goproxyRoot, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(goproxyRoot)
+
+	g := New()
+	g.QuarantinePeriod = 24 * time.Hour
+	g.GoBinEnv = append(
+		g.GoBinEnv,
+		"GOPROXY="+upstreamURL,
+		"GOSUMDB=off",
+	)
+	g.snapshot()
+
+	// The cached ".info" is preferred over the upstream.
+	mc := &mapCacher{caches: map[string][]byte{
+		"example.com/foo/@v/v1.1.0.info": []byte(
+			`{"Version":"v1.1.0","Time":"2000-01-01T00:00:00Z"}`,
+		),
+	}}
+
+	versions := []string{"v1.0.0", "v1.1.0", "v1.2.0", "v9.9.9"}
+	assert.Equal(t, []string{"v1.0.0", "v1.1.0"}, g.unquarantinedVersions(
+		context.Background(),
+		mc,
+		goproxyRoot,
+		"example.com/foo",
+		versions,
+	))
+
+	// The publish times are known from now on, so neither the upstream
+	// nor the cacher is needed for the known versions.
+	closeUpstream()
+
+	assert.Equal(t, []string{"v1.0.0", "v1.1.0"}, g.unquarantinedVersions(
+		context.Background(),
+		&infoErrorCacher{},
+		goproxyRoot,
+		"example.com/foo",
+		versions,
+	))
+
+	// The versions whose publish times cannot be determined are skipped
+	// without failing the others.
+	assert.Equal(t, []string{}, g.unquarantinedVersions(
+		context.Background(),
+		&infoErrorCacher{},
+		goproxyRoot,
+		"example.com/bar",
+		[]string{"v1.0.0"},
+	))
+}
+
+func TestGoproxyUnquarantinedVersionsLookups(t *testing.T) {
+	publishTimes := map[string]time.Time{}
+	versions := []string{}
+	for i := 0; i < maxPublishTimeLookups+2; i++ {
+		version := fmt.Sprintf("v1.%d.0", i)
+		publishTimes[version] = time.Now().Add(-48 * time.Hour)
+		versions = append(versions, version)
+	}
+
+	upstreamURL, closeUpstream := newTestModuleProxy(
+		t,
+		"example.com/foo",
+		publishTimes,
+	)
+	defer closeUpstream()
+
+	goproxyRoot, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(goproxyRoot)
+
+	g := New()
+	g.QuarantinePeriod = 24 * time.Hour
+	g.GoBinEnv = append(
+		g.GoBinEnv,
+		"GOPROXY="+upstreamURL,
+		"GOSUMDB=off",
+	)
+	g.snapshot()
+
+	// Only the newest unknown versions are looked up by a single call, and
+	// the rest are left to the later ones.
+	assert.Equal(t, versions[2:], g.unquarantinedVersions(
+		context.Background(),
+		&mapCacher{},
+		goproxyRoot,
+		"example.com/foo",
+		versions,
+	))
+
+	assert.Equal(t, versions, g.unquarantinedVersions(
+		context.Background(),
+		&mapCacher{},
+		goproxyRoot,
+		"example.com/foo",
+		versions,
+	))
+}
+
+func TestPublishTimeCache(t *testing.T) {
+	ptc := &publishTimeCache{}
+
+	_, ok := ptc.get("example.com/foo", "v1.0.0")
+	assert.False(t, ok)
+
+	t0 := time.Now()
+	for i := 0; i < maxPublishTimes; i++ {
+		ptc.set("example.com/foo", fmt.Sprintf("v1.%d.0", i), t0)
+	}
+
+	// The least recently used one is evicted once the cache is full.
+	_, ok = ptc.get("example.com/foo", "v1.0.0")
+	assert.True(t, ok)
+
+	ptc.set("example.com/bar", "v1.0.0", t0)
+	assert.Equal(t, maxPublishTimes, ptc.lru.Len())
+
+	_, ok = ptc.get("example.com/foo", "v1.1.0")
+	assert.False(t, ok)
+
+	pt, ok := ptc.get("example.com/foo", "v1.0.0")
+	assert.True(t, ok)
+	assert.Equal(t, t0, pt)
+
+	_, ok = ptc.get("example.com/bar", "v1.0.0")
+	assert.True(t, ok)
+
+	t1 := t0.Add(time.Hour)
+	ptc.set("example.com/bar", "v1.0.0", t1)
+	assert.Equal(t, maxPublishTimes, ptc.lru.Len())
+
+	pt, ok = ptc.get("example.com/bar", "v1.0.0")
+	assert.True(t, ok)
+	assert.Equal(t, t1, pt)
+}
+
+func TestGoproxyServeHTTPQuarantinedList(t *testing.T) {
+	upstreamURL, closeUpstream := newTestModuleProxy(
+		t,
+		"example.com/foo",
+		map[string]time.Time{
+			"v1.0.0": time.Now().Add(-48 * time.Hour),
+			"v1.1.0": time.Now(),
+		},
+	)
+	defer closeUpstream()
+
+	g := New()
+	g.QuarantinePeriod = 24 * time.Hour
+	g.GoBinEnv = append(
+		g.GoBinEnv,
+		"GOPROXY="+upstreamURL,
+		"GOSUMDB=off",
+	)
+
+	for _, tc := range []struct {
+		name string
+		code int
+		body string
+	}{
+		{"example.com/foo/@v/list", http.StatusOK, "v1.0.0"},
+		{"example.com/foo/@v/list", http.StatusOK, "v1.0.0"},
+		{
+			"example.com/foo/@latest",
+			http.StatusOK,
+			`{"Version":"v1.0.0",`,
+		},
+		{
+			"example.com/foo/@v/v1.1.0.info",
+			http.StatusForbidden,
+			"example.com/foo@v1.1.0 is quarantined until ",
+		},
+	} {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(
+			http.MethodGet,
+			"/"+tc.name,
+			nil,
+		))
+		assert.Equal(t, tc.code, rec.Code, tc.name)
+		assert.Contains(t, rec.Body.String(), tc.body, tc.name)
+	}
+
+	_, ok := g.publishTimes.get("example.com/foo", "v1.1.0")
+	assert.True(t, ok)
+}
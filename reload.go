@@ -0,0 +1,185 @@
+package goproxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reloadState is the state shared by a `Goproxy` and the snapshots of its
+// config.
+type reloadState struct {
+	mutex     sync.Mutex
+	swapMutex sync.RWMutex
+	snapshot  atomic.Value
+}
+
+// snapshotUsage tracks the requests and the background jobs using a snapshot
+// of the config of a `Goproxy`.
+//
+// The zero value of the `snapshotUsage` is ready to use.
+type snapshotUsage struct {
+	mutex   sync.Mutex
+	count   int
+	retired bool
+	drained chan struct{}
+}
+
+// acquire marks the su as used by one more request or background job.
+func (su *snapshotUsage) acquire() {
+	if su == nil {
+		return
+	}
+
+	su.mutex.Lock()
+	su.count++
+	su.mutex.Unlock()
+}
+
+// release marks the su as used by one less request or background job.
+func (su *snapshotUsage) release() {
+	if su == nil {
+		return
+	}
+
+	su.mutex.Lock()
+	defer su.mutex.Unlock()
+
+	if su.count--; su.count == 0 && su.retired {
+		close(su.drained)
+	}
+}
+
+// retire marks the su as no longer used by new requests. It returns a channel
+// that is closed once the su is no longer used at all.
+func (su *snapshotUsage) retire() <-chan struct{} {
+	if su == nil {
+		drained := make(chan struct{})
+		close(drained)
+		return drained
+	}
+
+	su.mutex.Lock()
+	defer su.mutex.Unlock()
+
+	if su.retired {
+		return su.drained
+	}
+
+	su.retired = true
+	su.drained = make(chan struct{})
+	if su.count == 0 {
+		close(su.drained)
+	}
+
+	return su.drained
+}
+
+// Reload atomically replaces the config of the g with the exported fields of
+// the config, such as the GOPRIVATE and the upstream credentials in the
+// `GoBinEnv`, the `Cacher`, and the `MaxGoBinWorkers`, without interrupting the
+// g. Requests in flight finish with the previous config, while new requests
+// are served with the config.
+//
+// It returns a channel that is closed once the requests and the background
+// jobs using the previous config, such as the retrying cache writes, have
+// finished. Only then can the resources that are no longer used by the
+// config, such as the previous `Cacher`, be released. The previous `Tracer`,
+// if replaced, is flushed at that time.
+//
+// The spooled cache writes are restored again if the `Cacher` or the
+// `CacheWriteSpoolDir` is changed, so that the ones left by the previous
+// config are retried with the config.
+//
+// The config must be created by the `New` and must not be used or modified
+// after being passed to the `Reload`. The exported fields of the g itself are
+// left untouched, they only serve as the initial config.
+func (g *Goproxy) Reload(config *Goproxy) <-chan struct{} {
+	g.snapshot()
+
+	rs := g.reload
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	s := *config
+	s.loadOnce = g.loadOnce
+	s.background = g.background
+	s.reload = rs
+	s.usage = &snapshotUsage{}
+	s.goBinEnv = map[string]string{}
+	s.supportedSUMDBNames = map[string]bool{}
+	s.load()
+
+	rs.swapMutex.Lock()
+	ps := rs.snapshot.Load().(*Goproxy)
+	rs.snapshot.Store(&s)
+	drained := ps.usage.retire()
+	rs.swapMutex.Unlock()
+
+	if s.Cacher != ps.Cacher ||
+		s.CacheWriteSpoolDir != ps.CacheWriteSpoolDir {
+		s.restoreCacheWrites()
+	}
+
+	if ps.Tracer != nil && ps.Tracer != s.Tracer {
+		flush := func(ctx context.Context) {
+			select {
+			case <-drained:
+			case <-ctx.Done():
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+
+			if err := ps.Tracer.Flush(ctx); err != nil {
+				s.logger.Log(
+					LogLevelError,
+					err.Error(),
+					"operation",
+					"reload",
+				)
+			}
+		}
+
+		// The flush is a background job so that the `Shutdown`
+		// waits for it, unless the g is already shutting down.
+		if !s.goBackground(
+			context.Background(),
+			"flush the replaced tracer",
+			flush,
+		) {
+			go flush(context.Background())
+		}
+	}
+
+	return drained
+}
+
+// snapshot returns the current snapshot of the config of the g. The snapshot
+// is loaded and must not be modified, so a request can be served with it from
+// start to finish even if the g is reloaded in the meantime.
+func (g *Goproxy) snapshot() *Goproxy {
+	g.loadOnce.Do(func() {
+		g.load()
+		g.reload.snapshot.Store(g)
+		g.restoreCacheWrites()
+	})
+
+	return g.reload.snapshot.Load().(*Goproxy)
+}
+
+// acquireSnapshot returns the current snapshot of the config of the g like the
+// `snapshot`, and marks it as used until the returned release is called, so
+// that the `Reload` does not report it as drained in the meantime.
+func (g *Goproxy) acquireSnapshot() (s *Goproxy, release func()) {
+	g.snapshot()
+
+	rs := g.reload
+	rs.swapMutex.RLock()
+	s = rs.snapshot.Load().(*Goproxy)
+	s.usage.acquire()
+	rs.swapMutex.RUnlock()
+
+	return s, s.usage.release
+}
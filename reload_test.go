@@ -0,0 +1,130 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoproxyReload(t *testing.T) {
+	g := New()
+	g.Cacher = &brokenCacher{}
+	g.GoBinEnv = []string{"GOPRIVATE=example.com", "GOSUMDB=off"}
+	g.MaxGoBinWorkers = 1
+
+	s := g.snapshot()
+	assert.True(t, s == g)
+	assert.Equal(t, "example.com", s.goBinEnv["GONOPROXY"])
+
+	config := New()
+	config.Cacher = &mapCacher{}
+	config.GoBinEnv = []string{"GOPRIVATE=example.org", "GOSUMDB=off"}
+	config.MaxGoBinWorkers = 2
+	g.Reload(config)
+
+	rs := g.snapshot()
+	assert.False(t, rs == s)
+	assert.Equal(t, config.Cacher, rs.Cacher)
+	assert.Equal(t, "example.org", rs.goBinEnv["GONOPROXY"])
+	assert.Equal(t, 2, cap(rs.goBinWorkerChan))
+	assert.True(t, rs.background == g.background)
+
+	// In-flight requests keep the snapshot they started with.
+	assert.IsType(t, &brokenCacher{}, s.Cacher)
+	assert.Equal(t, "example.com", s.goBinEnv["GONOPROXY"])
+	assert.Equal(t, 1, cap(s.goBinWorkerChan))
+
+	// The exported fields of the g are left untouched.
+	assert.IsType(t, &brokenCacher{}, g.Cacher)
+
+	h := &Health{Goproxy: g, ResultTTL: time.Nanosecond}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	config = New()
+	config.Cacher = &brokenCacher{}
+	g.Reload(config)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	assert.NoError(t, g.Shutdown(context.Background()))
+}
+
+func TestGoproxyReloadDrain(t *testing.T) {
+	spoolDir, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(spoolDir)
+
+	goproxyRoot, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(goproxyRoot)
+
+	g := New()
+	g.Cacher = &brokenCacher{}
+	g.CacheWriteBackoff = 50 * time.Millisecond
+	g.CacheWriteSpoolDir = spoolDir
+	g.Tracer = &Tracer{}
+
+	// A request in flight enqueues a cache write that keeps retrying
+	// after the request has finished.
+	s, release := g.acquireSnapshot()
+	assert.True(t, s.enqueueCacheWrite(
+		context.Background(),
+		s.Cacher,
+		"example.com/foo/@v/v1.0.0",
+		goproxyRoot,
+		newCacheWriteModResult(t, goproxyRoot),
+	))
+
+	config := New()
+	config.Cacher = &mapCacher{}
+	config.CacheWriteSpoolDir = spoolDir
+	drained := g.Reload(config)
+
+	release()
+	select {
+	case <-drained:
+		t.Fatal("previous config is drained while in use")
+	default:
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(10 * time.Second):
+		t.Fatal("previous config is not drained")
+	}
+
+	// The restoring of the reload left the spooled job alone while it
+	// was run by the previous config, so it is still spooled.
+	fileInfos, err := ioutil.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 1)
+
+	mc := &mapCacher{}
+	config = New()
+	config.Cacher = mc
+	config.CacheWriteSpoolDir = spoolDir
+	g.Reload(config)
+	assert.NoError(t, g.Shutdown(context.Background()))
+
+	_, err = cacheBytes(
+		context.Background(),
+		mc,
+		"example.com/foo/@v/v1.0.0.zip",
+	)
+	assert.NoError(t, err)
+
+	fileInfos, err = ioutil.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 0)
+}
@@ -1,8 +1,11 @@
 package goproxy
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -27,6 +30,74 @@ func responseString(rw http.ResponseWriter, statusCode int, s string) {
 	rw.Write([]byte(s))
 }
 
+// responseCompressedString responses the s like the `responseString`, but
+// compressed with gzip if the r accepts it.
+func responseCompressedString(
+	rw http.ResponseWriter,
+	r *http.Request,
+	statusCode int,
+	s string,
+) {
+	rw.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsEncoding(r, "gzip") {
+		responseString(rw, statusCode, s)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.Header().Set("Content-Encoding", "gzip")
+	rw.WriteHeader(statusCode)
+
+	gw := gzip.NewWriter(rw)
+	gw.Write([]byte(s))
+	gw.Close()
+}
+
+// acceptsEncoding reports whether the r accepts the content coding based on
+// its Accept-Encoding header.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	accepted := false
+	for _, v := range r.Header["Accept-Encoding"] {
+		for _, ae := range strings.Split(v, ",") {
+			parts := strings.Split(ae, ";")
+			c := strings.ToLower(strings.TrimSpace(parts[0]))
+			if c != coding && c != "*" {
+				continue
+			}
+
+			q := 1.0
+			for _, param := range parts[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, _ = strconv.ParseFloat(param[2:], 64)
+				}
+			}
+
+			// An explicit coding takes precedence over the "*".
+			if c == coding {
+				return q > 0
+			}
+
+			accepted = q > 0
+		}
+	}
+
+	return accepted
+}
+
+// responseJSON responses the v as JSON with the statusCode to the client.
+func responseJSON(rw http.ResponseWriter, statusCode int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		responseInternalServerError(rw)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(statusCode)
+	rw.Write(b)
+}
+
 // responseNotFound responses "Not Found" to the client with the optional msgs.
 func responseNotFound(rw http.ResponseWriter, msgs ...interface{}) {
 	var msg string
@@ -46,6 +117,23 @@ func responseNotFound(rw http.ResponseWriter, msgs ...interface{}) {
 	responseString(rw, http.StatusNotFound, msg)
 }
 
+// responseForbidden responses "Forbidden" to the client with the optional msgs.
+func responseForbidden(rw http.ResponseWriter, msgs ...interface{}) {
+	msg := "Forbidden"
+	if len(msgs) > 0 {
+		if s := fmt.Sprint(msgs...); s != "" {
+			msg = fmt.Sprint(msg, ": ", s)
+		}
+	}
+
+	responseString(rw, http.StatusForbidden, msg)
+}
+
+// responseUnauthorized responses "Unauthorized" to the client.
+func responseUnauthorized(rw http.ResponseWriter) {
+	responseString(rw, http.StatusUnauthorized, "Unauthorized")
+}
+
 // responseMethodNotAllowed responses "Method Not Allowed" to the client.
 func responseMethodNotAllowed(rw http.ResponseWriter) {
 	responseString(rw, http.StatusMethodNotAllowed, "Method Not Allowed")
@@ -64,3 +152,68 @@ func responseInternalServerError(rw http.ResponseWriter) {
 func responseBadGateway(rw http.ResponseWriter) {
 	responseString(rw, http.StatusBadGateway, "Bad Gateway")
 }
+
+// responseNotImplemented responses "Not Implemented" to the client.
+func responseNotImplemented(rw http.ResponseWriter, msgs ...interface{}) {
+	msg := "Not Implemented"
+	if len(msgs) > 0 {
+		msg = fmt.Sprint(msg, ": ", fmt.Sprint(msgs...))
+	}
+
+	responseString(rw, http.StatusNotImplemented, msg)
+}
+
+// recordingResponseWriter implements the `http.ResponseWriter`. It records the
+// status code and the number of bytes written.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+
+	status  int
+	written int64
+}
+
+// WriteHeader implements the `http.ResponseWriter`.
+func (rrw *recordingResponseWriter) WriteHeader(statusCode int) {
+	if rrw.status == 0 {
+		rrw.status = statusCode
+	}
+
+	rrw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements the `http.ResponseWriter`.
+func (rrw *recordingResponseWriter) Write(b []byte) (int, error) {
+	if rrw.status == 0 {
+		rrw.status = http.StatusOK
+	}
+
+	n, err := rrw.ResponseWriter.Write(b)
+	rrw.written += int64(n)
+
+	return n, err
+}
+
+// Flush implements the `http.Flusher`. It does nothing if the underlying
+// `http.ResponseWriter` is not an `http.Flusher`.
+func (rrw *recordingResponseWriter) Flush() {
+	f, ok := rrw.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+
+	if rrw.status == 0 {
+		rrw.status = http.StatusOK
+	}
+
+	f.Flush()
+}
+
+// statusCode returns the status code written to the rrw. It returns the
+// `http.StatusOK` if nothing has been written yet.
+func (rrw *recordingResponseWriter) statusCode() int {
+	if rrw.status == 0 {
+		return http.StatusOK
+	}
+
+	return rrw.status
+}
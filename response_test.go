@@ -1,6 +1,8 @@
 package goproxy
 
 import (
+	"compress/gzip"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -37,6 +39,66 @@ func TestResponseString(t *testing.T) {
 	assert.Equal(t, "Foobar", rec.Body.String())
 }
 
+func TestResponseCompressedString(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	responseCompressedString(rec, req, http.StatusOK, "Foobar")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.HeaderMap.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rec.HeaderMap.Get("Vary"))
+	assert.Equal(t, "Foobar", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	responseCompressedString(rec, req, http.StatusOK, "Foobar")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(
+		t,
+		"text/plain; charset=utf-8",
+		rec.HeaderMap.Get("Content-Type"),
+	)
+	assert.Equal(t, "gzip", rec.HeaderMap.Get("Content-Encoding"))
+
+	gr, err := gzip.NewReader(rec.Body)
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, "Foobar", string(b))
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, GZIP", true},
+		{"br;q=1.0, gzip;q=0.8", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"*, gzip;q=0", false},
+		{"gzip;q=0.5, *;q=0", true},
+		{"deflate", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+
+		assert.Equal(
+			t,
+			tt.want,
+			acceptsEncoding(req, "gzip"),
+			tt.acceptEncoding,
+		)
+	}
+}
+
 func TestResponseNotFound(t *testing.T) {
 	rec := httptest.NewRecorder()
 
@@ -94,6 +156,30 @@ func TestResponseNotFound(t *testing.T) {
 	assert.Equal(t, "Not Found: foobar", rec.Body.String())
 }
 
+func TestResponseForbidden(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	responseForbidden(rec)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(
+		t,
+		"text/plain; charset=utf-8",
+		rec.HeaderMap.Get("Content-Type"),
+	)
+	assert.Equal(t, "Forbidden", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+
+	responseForbidden(rec, "foobar")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(
+		t,
+		"text/plain; charset=utf-8",
+		rec.HeaderMap.Get("Content-Type"),
+	)
+	assert.Equal(t, "Forbidden: foobar", rec.Body.String())
+}
+
 func TestResponseMethodNotAllowed(t *testing.T) {
 	rec := httptest.NewRecorder()
 
@@ -132,3 +218,70 @@ func TestResponseBadGateway(t *testing.T) {
 	)
 	assert.Equal(t, "Bad Gateway", rec.Body.String())
 }
+
+func TestResponseJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	responseJSON(rec, http.StatusOK, map[string]string{"foo": "bar"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(
+		t,
+		"application/json; charset=utf-8",
+		rec.HeaderMap.Get("Content-Type"),
+	)
+	assert.Equal(t, `{"foo":"bar"}`, rec.Body.String())
+}
+
+func TestResponseUnauthorized(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	responseUnauthorized(rec)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Unauthorized", rec.Body.String())
+}
+
+func TestResponseNotImplemented(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	responseNotImplemented(rec)
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	assert.Equal(t, "Not Implemented", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+
+	responseNotImplemented(rec, "foobar")
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	assert.Equal(t, "Not Implemented: foobar", rec.Body.String())
+}
+
+func TestRecordingResponseWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rrw := &recordingResponseWriter{ResponseWriter: rec}
+	assert.Equal(t, http.StatusOK, rrw.statusCode())
+
+	rrw.WriteHeader(http.StatusNotFound)
+	rrw.WriteHeader(http.StatusOK)
+	n, err := rrw.Write([]byte("Not Found"))
+	assert.NoError(t, err)
+	assert.Equal(t, 9, n)
+	assert.Equal(t, http.StatusNotFound, rrw.statusCode())
+	assert.Equal(t, int64(9), rrw.written)
+
+	// The flushes are passed through.
+	rec = httptest.NewRecorder()
+	rrw = &recordingResponseWriter{ResponseWriter: rec}
+
+	var rw http.ResponseWriter = rrw
+	f, ok := rw.(http.Flusher)
+	assert.True(t, ok)
+
+	f.Flush()
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, http.StatusOK, rrw.statusCode())
+
+	rrw = &recordingResponseWriter{
+		ResponseWriter: struct{ http.ResponseWriter }{rec},
+	}
+	rrw.Flush()
+	assert.Equal(t, 0, rrw.status)
+}
@@ -0,0 +1,189 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// retractedVersions returns the rationales of the versions of the modulePath
+// that are retracted by the go.mod of the latest one of the versions, keyed by
+// the version.
+func (g *Goproxy) retractedVersions(
+	ctx context.Context,
+	cacher Cacher,
+	goproxyRoot string,
+	modulePath string,
+	versions []string,
+) (map[string]string, error) {
+	latest := latestVersion(versions)
+	if latest == "" {
+		return nil, nil
+	}
+
+	goMod, err := g.goModFile(ctx, cacher, goproxyRoot, modulePath, latest)
+	if err != nil {
+		return nil, err
+	}
+
+	return retractedVersionsFromGoMod(goMod, versions)
+}
+
+// goModFile returns the content of the go.mod of the moduleVersion of the
+// modulePath. It prefers the ".mod" cache in the cacher and falls back to a
+// "gomod" `mod`, which only fetches the go.mod. The fetched go.mod is verified
+// against the checksum database and set to the cacher.
+func (g *Goproxy) goModFile(
+	ctx context.Context,
+	cacher Cacher,
+	goproxyRoot string,
+	modulePath string,
+	moduleVersion string,
+) ([]byte, error) {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return nil, err
+	}
+
+	escapedModuleVersion, err := module.EscapeVersion(moduleVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprint(
+		escapedModulePath,
+		"/@v/",
+		escapedModuleVersion,
+		".mod",
+	)
+
+	cache, err := cacher.Cache(ctx, name)
+	if err == nil {
+		defer cache.Close()
+		return ioutil.ReadAll(cache)
+	} else if err != ErrCacheNotFound {
+		return nil, err
+	}
+
+	mr, err := mod(
+		ctx,
+		"gomod",
+		g.GoBinName,
+		g.goBinEnv,
+		g.goBinWorkerChan,
+		g.Metrics,
+		goproxyRoot,
+		modulePath,
+		moduleVersion,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.sumdbVerifies(modulePath) {
+		if err := g.verifyGoMod(
+			ctx,
+			modulePath,
+			moduleVersion,
+			mr.GoMod,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := g.setCache(ctx, cacher, mr.GoMod, name); err != nil {
+		g.logger.Log(
+			LogLevelWarn,
+			err.Error(),
+			"operation",
+			"cache_write",
+			"cache",
+			name,
+		)
+	}
+
+	return ioutil.ReadFile(mr.GoMod)
+}
+
+// retractedVersionsFromGoMod returns the rationales of the versions that are
+// retracted by the goMod, keyed by the version. A retraction without rationale
+// results in "retracted by module author".
+func retractedVersionsFromGoMod(
+	goMod []byte,
+	versions []string,
+) (map[string]string, error) {
+	f, err := modfile.ParseLax("go.mod", goMod, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	retracted := map[string]string{}
+	for _, version := range versions {
+		for _, r := range f.Retract {
+			if semver.Compare(version, r.Low) < 0 ||
+				semver.Compare(version, r.High) > 0 {
+				continue
+			}
+
+			rationale := strings.Join(
+				strings.Fields(r.Rationale),
+				" ",
+			)
+			if rationale == "" {
+				rationale = "retracted by module author"
+			}
+
+			retracted[version] = rationale
+
+			break
+		}
+	}
+
+	return retracted, nil
+}
+
+// unretractedVersions returns the versions that are not in the retracted.
+func unretractedVersions(
+	versions []string,
+	retracted map[string]string,
+) []string {
+	unretracted := make([]string, 0, len(versions))
+	for _, version := range versions {
+		if _, ok := retracted[version]; !ok {
+			unretracted = append(unretracted, version)
+		}
+	}
+
+	return unretracted
+}
+
+// setResponseRetractedHeader adds a "Goproxy-Retracted" header for each of the
+// retracted versions in the form of "<version>; <rationale>".
+func setResponseRetractedHeader(
+	rw http.ResponseWriter,
+	retracted map[string]string,
+) {
+	versions := make([]string, 0, len(retracted))
+	for version := range retracted {
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return semver.Compare(versions[i], versions[j]) < 0
+	})
+
+	for _, version := range versions {
+		rw.Header().Add("Goproxy-Retracted", fmt.Sprintf(
+			"%s; %s",
+			version,
+			retracted[version],
+		))
+	}
+}
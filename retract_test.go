@@ -0,0 +1,191 @@
+package goproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetractedVersionsFromGoMod(t *testing.T) {
+	goMod := []byte(`module example.com/foo
+
+go 1.16
+
+retract (
+	// Published too early.
+	v1.0.1
+
+	[v1.1.0, v1.1.2]
+)
+`)
+
+	retracted, err := retractedVersionsFromGoMod(goMod, []string{
+		"v1.0.0",
+		"v1.0.1",
+		"v1.1.0",
+		"v1.1.1",
+		"v1.1.2",
+		"v1.2.0",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"v1.0.1": "Published too early.",
+		"v1.1.0": "retracted by module author",
+		"v1.1.1": "retracted by module author",
+		"v1.1.2": "retracted by module author",
+	}, retracted)
+
+	retracted, err = retractedVersionsFromGoMod(
+		[]byte("module example.com/foo\n"),
+		[]string{"v1.0.0"},
+	)
+	assert.NoError(t, err)
+	assert.Empty(t, retracted)
+
+	_, err = retractedVersionsFromGoMod([]byte("module"), nil)
+	assert.Error(t, err)
+}
+
+func TestUnretractedVersions(t *testing.T) {
+	assert.Equal(
+		t,
+		[]string{"v1.0.0", "v1.2.0"},
+		unretractedVersions(
+			[]string{"v1.0.0", "v1.1.0", "v1.2.0"},
+			map[string]string{"v1.1.0": "foobar"},
+		),
+	)
+}
+
+func TestSetResponseRetractedHeader(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	setResponseRetractedHeader(rec, map[string]string{
+		"v1.10.0": "bar",
+		"v1.2.0":  "foo",
+	})
+	assert.Equal(
+		t,
+		[]string{"v1.2.0; foo", "v1.10.0; bar"},
+		rec.Header()["Goproxy-Retracted"],
+	)
+}
+
+func TestGoproxyGoModFile(t *testing.T) {
+	upstreamURL, closeUpstream := newTestModuleProxy(
+		t,
+		"example.com/foo",
+		map[string]time.Time{
+			"v1.0.0": time.Now(),
+			"v1.1.0": time.Now(),
+		},
+	)
+	defer closeUpstream()
+
+	envGOSUMDB, closeSUMDB := newTestSUMDB(t, upstreamURL)
+	defer closeSUMDB()
+
+	// The recording proxy serves a tampered go.mod of the "v1.1.0".
+	upstream, err := url.Parse(upstreamURL)
+	assert.NoError(t, err)
+
+	var (
+		mutex    sync.Mutex
+		requests []string
+	)
+	reverseProxy := httputil.NewSingleHostReverseProxy(upstream)
+	proxy := httptest.NewServer(http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			requests = append(requests, r.URL.Path)
+			mutex.Unlock()
+
+			if r.URL.Path == "/example.com/foo/@v/v1.1.0.mod" {
+				rw.Write([]byte("module example.com/bar\n"))
+				return
+			}
+
+			reverseProxy.ServeHTTP(rw, r)
+		},
+	))
+	defer proxy.Close()
+
+	goproxyRoot, err := ioutil.TempDir("", "goproxy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(goproxyRoot)
+
+	g := New()
+	g.GoBinEnv = append(
+		g.GoBinEnv,
+		"GOPROXY="+proxy.URL,
+		"GOSUMDB="+envGOSUMDB,
+		"GONOSUMDB=",
+		"GOPRIVATE=",
+	)
+	g.snapshot()
+
+	mc := &mapCacher{caches: map[string][]byte{}}
+
+	goMod, err := g.goModFile(
+		context.Background(),
+		mc,
+		goproxyRoot,
+		"example.com/foo",
+		"v1.0.0",
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "module example.com/foo\n", string(goMod))
+	assert.Equal(
+		t,
+		"module example.com/foo\n",
+		string(mc.caches["example.com/foo/@v/v1.0.0.mod"]),
+	)
+
+	mutex.Lock()
+	for _, request := range requests {
+		assert.False(t, strings.HasSuffix(request, ".zip"), request)
+	}
+	requests = nil
+	mutex.Unlock()
+
+	// The cached go.mod is served without touching the upstream.
+	mc.caches["example.com/foo/@v/v1.0.0.mod"] = []byte(
+		"module example.com/foo\n\nretract v1.0.0\n",
+	)
+	goMod, err = g.goModFile(
+		context.Background(),
+		mc,
+		goproxyRoot,
+		"example.com/foo",
+		"v1.0.0",
+	)
+	assert.NoError(t, err)
+	assert.Contains(t, string(goMod), "retract v1.0.0")
+
+	mutex.Lock()
+	assert.Empty(t, requests)
+	mutex.Unlock()
+
+	// The go.mod that does not match the checksum database is neither
+	// returned nor cached.
+	_, err = g.goModFile(
+		context.Background(),
+		mc,
+		goproxyRoot,
+		"example.com/foo",
+		"v1.1.0",
+	)
+	assert.IsType(t, &untrustedRevisionError{}, err)
+	_, ok := mc.caches["example.com/foo/@v/v1.1.0.mod"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,189 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ShutdownError is the error resulting if the `Goproxy.Shutdown` abandoned
+// background jobs that did not finish before its deadline.
+type ShutdownError struct {
+	// Abandoned is the names of the abandoned background jobs, such as
+	// "set caches of example.com/foo/@v/v1.0.0".
+	Abandoned []string
+}
+
+// Error implements the `error`.
+func (se *ShutdownError) Error() string {
+	return fmt.Sprintf(
+		"abandoned %d background jobs: %s",
+		len(se.Abandoned),
+		strings.Join(se.Abandoned, ", "),
+	)
+}
+
+// backgroundTracker tracks the background jobs of a `Goproxy`.
+type backgroundTracker struct {
+	mutex        sync.Mutex
+	shuttingDown bool
+	jobs         map[*backgroundJob]bool
+	waitGroup    sync.WaitGroup
+
+	// spoolDirs is the spool directories of the cache write jobs that are
+	// being spooled or run, shared by all the snapshots of the config.
+	spoolDirs map[string]bool
+}
+
+// backgroundJob is a background job tracked by a `backgroundTracker`.
+type backgroundJob struct {
+	name   string
+	cancel context.CancelFunc
+}
+
+// Shutdown gracefully shuts down the g. It stops accepting new background
+// jobs, such as setting the caches of freshly downloaded module versions, and
+// waits for the pending ones, including their temporary directory cleanups, to
+// finish until the ctx is done. The remaining ones are canceled and reported by
+// a `ShutdownError`. The `Tracer` is flushed at last if it is not nil.
+//
+// After the `Shutdown` is called, the g keeps serving requests, but freshly
+// downloaded module versions are no longer cached.
+func (g *Goproxy) Shutdown(ctx context.Context) error {
+	bt := g.background
+
+	bt.mutex.Lock()
+	bt.shuttingDown = true
+	bt.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		bt.waitGroup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		bt.mutex.Lock()
+		abandoned := make([]string, 0, len(bt.jobs))
+		for job := range bt.jobs {
+			job.cancel()
+			abandoned = append(abandoned, job.name)
+		}
+		bt.mutex.Unlock()
+
+		if len(abandoned) > 0 {
+			sort.Strings(abandoned)
+			return &ShutdownError{Abandoned: abandoned}
+		}
+	}
+
+	s, _ := g.reload.snapshot.Load().(*Goproxy)
+	if s == nil {
+		s = g
+	}
+
+	if s.Tracer != nil {
+		return s.Tracer.Flush(ctx)
+	}
+
+	return nil
+}
+
+// goBackground runs the f in a new goroutine as the background job of the
+// name unless the g is shutting down. It reports whether the f is run. The
+// context.Context passed to the f is derived from the ctx and is canceled if
+// the job is abandoned by the `Shutdown`.
+func (g *Goproxy) goBackground(
+	ctx context.Context,
+	name string,
+	f func(ctx context.Context),
+) bool {
+	bt := g.background
+
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	if bt.shuttingDown {
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	job := &backgroundJob{name: name, cancel: cancel}
+	if bt.jobs == nil {
+		bt.jobs = map[*backgroundJob]bool{}
+	}
+
+	bt.jobs[job] = true
+	bt.waitGroup.Add(1)
+
+	go func() {
+		defer func() {
+			cancel()
+
+			bt.mutex.Lock()
+			delete(bt.jobs, job)
+			bt.mutex.Unlock()
+
+			bt.waitGroup.Done()
+		}()
+
+		f(ctx)
+	}()
+
+	return true
+}
+
+// newSpoolDir creates a new spool directory in the root and claims it.
+func (bt *backgroundTracker) newSpoolDir(root string) (string, error) {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", err
+	}
+
+	dir, err := ioutil.TempDir(root, "job")
+	if err != nil {
+		return "", err
+	}
+
+	if bt.spoolDirs == nil {
+		bt.spoolDirs = map[string]bool{}
+	}
+
+	bt.spoolDirs[dir] = true
+
+	return dir, nil
+}
+
+// claimSpoolDir claims the spool directory dir. It reports whether the dir was
+// not claimed yet.
+func (bt *backgroundTracker) claimSpoolDir(dir string) bool {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	if bt.spoolDirs[dir] {
+		return false
+	}
+
+	if bt.spoolDirs == nil {
+		bt.spoolDirs = map[string]bool{}
+	}
+
+	bt.spoolDirs[dir] = true
+
+	return true
+}
+
+// releaseSpoolDir releases the claim of the spool directory dir.
+func (bt *backgroundTracker) releaseSpoolDir(dir string) {
+	bt.mutex.Lock()
+	delete(bt.spoolDirs, dir)
+	bt.mutex.Unlock()
+}
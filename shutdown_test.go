@@ -0,0 +1,58 @@
+package goproxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoproxyShutdown(t *testing.T) {
+	g := New()
+
+	finished := make(chan struct{})
+	assert.True(t, g.goBackground(
+		context.Background(),
+		"quick",
+		func(ctx context.Context) {
+			close(finished)
+		},
+	))
+
+	<-finished
+	assert.NoError(t, g.Shutdown(context.Background()))
+	assert.False(t, g.goBackground(
+		context.Background(),
+		"late",
+		func(ctx context.Context) {},
+	))
+
+	g = New()
+
+	canceled := make(chan struct{})
+	assert.True(t, g.goBackground(
+		context.Background(),
+		"stuck",
+		func(ctx context.Context) {
+			<-ctx.Done()
+			close(canceled)
+		},
+	))
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		10*time.Millisecond,
+	)
+	defer cancel()
+
+	err := g.Shutdown(ctx)
+	assert.Equal(t, &ShutdownError{Abandoned: []string{"stuck"}}, err)
+	assert.Equal(t, "abandoned 1 background jobs: stuck", err.Error())
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("abandoned job was not canceled")
+	}
+}
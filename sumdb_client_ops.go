@@ -1,13 +1,16 @@
 package goproxy
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+
+	"golang.org/x/mod/sumdb/tlog"
 )
 
 // sumdbClientOps implements the `sumdb.ClientOps`.
@@ -15,18 +18,21 @@ type sumdbClientOps struct {
 	endpointURL *url.URL
 	envGOPROXY  string
 	envGOSUMDB  string
-	errorLogger *log.Logger
+	logger      Logger
+	tracer      *Tracer
 
 	loadOnce  sync.Once
 	loadError error
+
+	// treeSize is the size of the latest signed tree of the checksum
+	// database that has been verified during the lifetime of the sco.
+	treeSizeMutex sync.Mutex
+	treeSize      int64
 }
 
 // load loads the stuff of the sco up.
 func (sco *sumdbClientOps) load() {
-	sumdbName := sco.envGOSUMDB
-	if i := strings.Index(sumdbName, "+"); i >= 0 {
-		sumdbName = sumdbName[:i]
-	}
+	sumdbName := sumdbName(sco.envGOSUMDB)
 
 	for _, proxy := range strings.Split(sco.envGOPROXY, ",") {
 		if proxy == "direct" || proxy == "off" {
@@ -101,12 +107,38 @@ func (sco *sumdbClientOps) ReadRemote(path string) ([]byte, error) {
 
 	operationURL := appendURL(sco.endpointURL, path)
 
-	res, err := http.Get(operationURL.String())
+	// The `sumdb.ClientOps` does not carry a `context.Context`, so each
+	// remote read starts a new trace.
+	ctx, readSpan := sco.tracer.startRootSpan(
+		context.Background(),
+		"sumdb.ReadRemote",
+		spanKindClient,
+	)
+	readSpan.setAttribute("http.method", http.MethodGet)
+	readSpan.setAttribute("http.url", redactedURL(operationURL))
+
+	req, err := http.NewRequest(
+		http.MethodGet,
+		operationURL.String(),
+		nil,
+	)
 	if err != nil {
+		readSpan.end(err)
+		return nil, err
+	}
+
+	injectTraceparent(ctx, req.Header)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		readSpan.end(err)
 		return nil, err
 	}
 	defer res.Body.Close()
 
+	readSpan.setAttribute("http.status_code", res.StatusCode)
+	readSpan.end(nil)
+
 	b, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
@@ -135,7 +167,14 @@ func (sco *sumdbClientOps) ReadConfig(file string) ([]byte, error) {
 	}
 
 	if file == "key" {
-		return []byte(sco.envGOSUMDB), nil
+		// The GOSUMDB may be followed by the URL of the checksum
+		// database, which is not part of the key.
+		key := sco.envGOSUMDB
+		if i := strings.Index(key, " "); i > 0 {
+			key = key[:i]
+		}
+
+		return []byte(key), nil
 	}
 
 	if strings.HasSuffix(file, "/latest") {
@@ -146,10 +185,42 @@ func (sco *sumdbClientOps) ReadConfig(file string) ([]byte, error) {
 	return nil, fmt.Errorf("unknown config %s", file)
 }
 
-// WriteConfig implements the `sumdb.ClientOps`.
+// WriteConfig implements the `sumdb.ClientOps`. The latest tree is not
+// stored, but its size is recorded for the `latestTreeSize`.
 func (sco *sumdbClientOps) WriteConfig(file string, old, new []byte) error {
-	sco.loadOnce.Do(sco.load)
-	return sco.loadError
+	if sco.loadOnce.Do(sco.load); sco.loadError != nil {
+		return sco.loadError
+	}
+
+	if strings.HasSuffix(file, "/latest") {
+		// The latest is a signed note, which has already been
+		// verified by the `sumdb.Client`. Its text ends at the first
+		// blank line.
+		text := new
+		if i := bytes.Index(text, []byte("\n\n")); i >= 0 {
+			text = text[:i+1]
+		}
+
+		if tree, err := tlog.ParseTree(text); err == nil {
+			sco.treeSizeMutex.Lock()
+			if tree.N > sco.treeSize {
+				sco.treeSize = tree.N
+			}
+
+			sco.treeSizeMutex.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// latestTreeSize returns the size of the latest signed tree of the checksum
+// database that the sco has seen. It returns zero if there is none.
+func (sco *sumdbClientOps) latestTreeSize() int64 {
+	sco.treeSizeMutex.Lock()
+	defer sco.treeSizeMutex.Unlock()
+
+	return sco.treeSize
 }
 
 // ReadCache implements the `sumdb.ClientOps`.
@@ -174,9 +245,14 @@ func (sco *sumdbClientOps) Log(msg string) {
 // SecurityError implements the `sumdb.ClientOps`.
 func (sco *sumdbClientOps) SecurityError(msg string) {
 	sco.loadOnce.Do(sco.load)
-	if sco.errorLogger != nil {
-		sco.errorLogger.Print(msg)
-	} else {
-		log.Print(msg)
+	sco.logger.Log(LogLevelError, msg, "operation", "sumdb")
+}
+
+// sumdbName returns the name of the checksum database of the envGOSUMDB.
+func sumdbName(envGOSUMDB string) string {
+	if i := strings.Index(envGOSUMDB, "+"); i >= 0 {
+		return envGOSUMDB[:i]
 	}
+
+	return envGOSUMDB
 }
@@ -0,0 +1,157 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestSUMDBClientOpsReadConfig(t *testing.T) {
+	const key = "sum.golang.org+033de0ae+" +
+		"Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8"
+	for _, envGOSUMDB := range []string{
+		key,
+		key + " https://sum.golang.org",
+	} {
+		sco := &sumdbClientOps{
+			envGOPROXY: "off",
+			envGOSUMDB: envGOSUMDB,
+		}
+
+		b, err := sco.ReadConfig("key")
+		assert.NoError(t, err)
+		assert.Equal(t, key, string(b))
+
+		b, err = sco.ReadConfig("sum.golang.org/latest")
+		assert.NoError(t, err)
+		assert.Empty(t, b)
+
+		_, err = sco.ReadConfig("foo")
+		assert.EqualError(t, err, "unknown config foo")
+	}
+}
+
+func TestSUMDBClientOpsLatest(t *testing.T) {
+	signer, verifier, err := note.GenerateKey(rand.Reader, "sumdb.test")
+	assert.NoError(t, err)
+
+	hash := "h1:" + strings.Repeat("A", 43) + "="
+	gosum := func(path, vers string) ([]byte, error) {
+		return []byte(fmt.Sprintf(
+			"%s %s %s\n%s %s/go.mod %s\n",
+			path,
+			vers,
+			hash,
+			path,
+			vers,
+			hash,
+		)), nil
+	}
+
+	sumdbServer := httptest.NewServer(sumdb.NewServer(
+		sumdb.NewTestServer(signer, gosum),
+	))
+	defer sumdbServer.Close()
+
+	logBuffer := &bytes.Buffer{}
+
+	g := New()
+	g.GoBinEnv = append(
+		g.GoBinEnv,
+		"GOPROXY=off",
+		"GOSUMDB="+verifier+" "+sumdbServer.URL,
+	)
+	g.Logger = &JSONLogger{Writer: logBuffer}
+	g.snapshot()
+
+	sco := g.sumdbClientOps
+	assert.Equal(t, int64(0), sco.latestTreeSize())
+
+	// The recorded size grows with the lookups, each newer tree being
+	// checked for consistency with the previous one by the client.
+	for i, modulePath := range []string{
+		"example.com/foo",
+		"example.com/bar",
+	} {
+		lines, err := g.sumdbLookup(
+			context.Background(),
+			modulePath,
+			"v1.0.0/go.mod",
+		)
+		assert.NoError(t, err)
+		assert.Len(t, lines, 1)
+		assert.Equal(t, int64(i+1), sco.latestTreeSize())
+	}
+
+	// Concurrent lookups never shrink the recorded size.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			_, err := g.sumdbLookup(
+				context.Background(),
+				fmt.Sprintf("example.com/concurrent%d", i),
+				"v1.0.0",
+			)
+			assert.NoError(t, err)
+		}(i)
+	}
+
+	wg.Wait()
+	assert.Equal(t, int64(10), sco.latestTreeSize())
+
+	// The latest tree is not stored, so the verification is the same as
+	// without recording its size.
+	latest, err := sco.ReadConfig("sumdb.test/latest")
+	assert.NoError(t, err)
+	assert.Empty(t, latest)
+
+	assert.NoError(t, sco.WriteConfig(
+		"sumdb.test/latest",
+		nil,
+		[]byte("malformed"),
+	))
+	assert.Equal(t, int64(10), sco.latestTreeSize())
+
+	// A checksum database with a different history, even if signed by the
+	// same key, is rejected.
+	forkedServer := httptest.NewServer(sumdb.NewServer(
+		sumdb.NewTestServer(signer, gosum),
+	))
+	defer forkedServer.Close()
+
+	for i := 0; i < 16; i++ {
+		res, err := http.Get(fmt.Sprintf(
+			"%s/lookup/example.com/forked%d@v1.0.0",
+			forkedServer.URL,
+			i,
+		))
+		assert.NoError(t, err)
+		res.Body.Close()
+	}
+
+	sco.endpointURL, err = url.Parse(forkedServer.URL)
+	assert.NoError(t, err)
+
+	_, err = g.sumdbLookup(
+		context.Background(),
+		"example.com/baz",
+		"v1.0.0",
+	)
+	assert.Error(t, err)
+	assert.Contains(t, logBuffer.String(), "SECURITY ERROR")
+	assert.Equal(t, int64(10), sco.latestTreeSize())
+}
@@ -0,0 +1,496 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The span kinds as defined by the OpenTelemetry.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+// Tracer is the tracer of the `Goproxy`. It traces the request handling, the
+// fetching, the verifying, and the caching of module versions in a way that is
+// compatible with the OpenTelemetry.
+//
+// The trace context of the incoming requests is extracted from the
+// "traceparent" header (see https://www.w3.org/TR/trace-context/), and it is
+// injected into the outbound requests to the upstreams. The spans are exported
+// to the `OTLPEndpoint` via the OTLP/HTTP with JSON encoding.
+//
+// The zero value of the `Tracer` is ready to use, but it exports nothing.
+type Tracer struct {
+	// ServiceName is the "service.name" resource attribute of the exported
+	// spans.
+	//
+	// If the `ServiceName` is empty, the "goproxy" is used.
+	ServiceName string `mapstructure:"service_name"`
+
+	// OTLPEndpoint is the URL of the OTLP/HTTP traces endpoint, such as
+	// "http://localhost:4318/v1/traces".
+	//
+	// If the `OTLPEndpoint` is empty, the spans will not be exported, but
+	// the trace context will still be propagated.
+	OTLPEndpoint string `mapstructure:"otlp_endpoint"`
+
+	// OTLPHeaders is the extra headers of the requests to the
+	// `OTLPEndpoint`, such as the authentication headers.
+	OTLPHeaders map[string]string `mapstructure:"otlp_headers"`
+
+	loadOnce    sync.Once
+	serviceName string
+	spanChan    chan *span
+	flushChan   chan chan struct{}
+}
+
+// load loads the stuff of the t up.
+func (t *Tracer) load() {
+	t.serviceName = t.ServiceName
+	if t.serviceName == "" {
+		t.serviceName = "goproxy"
+	}
+
+	if t.OTLPEndpoint != "" {
+		t.spanChan = make(chan *span, 2048)
+		t.flushChan = make(chan chan struct{})
+		go t.export()
+	}
+}
+
+// Flush exports all the ended spans that have not been exported yet. It
+// returns when they are exported or the ctx is done.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t.loadOnce.Do(t.load); t.spanChan == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+	select {
+	case t.flushChan <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startServerSpan starts a new server span for the r. The trace context of the
+// r is used as the parent if present. It returns the ctx as is and a nil span
+// if the t is nil.
+func (t *Tracer) startServerSpan(r *http.Request) (context.Context, *span) {
+	if t == nil {
+		return r.Context(), nil
+	}
+
+	t.loadOnce.Do(t.load)
+
+	parent, ok := parseTraceparent(r.Header.Get("traceparent"))
+	if !ok {
+		parent = spanContext{sampled: true}
+		rand.Read(parent.traceID[:])
+	}
+
+	ctx := context.WithValue(r.Context(), spanContextKey{}, &span{
+		tracer: t,
+		sc:     parent,
+	})
+
+	return startSpan(ctx, "goproxy.ServeHTTP", spanKindServer)
+}
+
+// startRootSpan starts a new span that starts a new trace. It returns the ctx
+// as is and a nil span if the t is nil.
+func (t *Tracer) startRootSpan(
+	ctx context.Context,
+	name string,
+	kind int,
+) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	t.loadOnce.Do(t.load)
+
+	parent := spanContext{sampled: true}
+	rand.Read(parent.traceID[:])
+
+	ctx = context.WithValue(ctx, spanContextKey{}, &span{
+		tracer: t,
+		sc:     parent,
+	})
+
+	return startSpan(ctx, name, kind)
+}
+
+// export exports the spans sent to the spanChan of the t in batches.
+func (t *Tracer) export() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-t.spanChan:
+			if batch = append(batch, s); len(batch) < 512 {
+				continue
+			}
+		case <-ticker.C:
+		case done := <-t.flushChan:
+			for n := len(t.spanChan); n > 0; n-- {
+				batch = append(batch, <-t.spanChan)
+			}
+
+			t.post(batch)
+			batch = nil
+			close(done)
+
+			continue
+		}
+
+		t.post(batch)
+		batch = nil
+	}
+}
+
+// post posts the batch to the `OTLPEndpoint` of the t. The failures are
+// ignored since the traces are best-effort.
+func (t *Tracer) post(batch []*span) {
+	if len(batch) == 0 {
+		return
+	}
+
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, s.otlp())
+	}
+
+	resource := map[string]interface{}{
+		"attributes": otlpAttributes(map[string]interface{}{
+			"service.name": t.serviceName,
+		}),
+	}
+
+	scopeSpans := map[string]interface{}{
+		"scope": map[string]interface{}{
+			"name": "github.com/goproxy/goproxy",
+		},
+		"spans": spans,
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource":   resource,
+				"scopeSpans": []interface{}{scopeSpans},
+			},
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		t.OTLPEndpoint,
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.OTLPHeaders {
+		req.Header.Set(k, v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return
+	}
+
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+// spanContextKey is the key of the current `span` in a `context.Context`.
+type spanContextKey struct{}
+
+// spanContext is the part of a `span` that is propagated across process
+// boundaries.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// parseTraceparent parses the s as a "traceparent" header.
+func parseTraceparent(s string) (spanContext, bool) {
+	sc := spanContext{}
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 ||
+		len(parts[3]) != 2 {
+		return sc, false
+	}
+
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil ||
+		sc.traceID == [16]byte{} {
+		return sc, false
+	}
+
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil ||
+		sc.spanID == [8]byte{} {
+		return sc, false
+	}
+
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return sc, false
+	}
+
+	sc.sampled = flags&1 == 1
+
+	return sc, true
+}
+
+// traceparent returns the "traceparent" header of the sc.
+func (sc spanContext) traceparent() string {
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf(
+		"00-%s-%s-%s",
+		hex.EncodeToString(sc.traceID[:]),
+		hex.EncodeToString(sc.spanID[:]),
+		flags,
+	)
+}
+
+// span is a traced operation. All the methods of the span do nothing if the
+// span is nil.
+type span struct {
+	tracer       *Tracer
+	sc           spanContext
+	parentSpanID [8]byte
+	name         string
+	kind         int
+	startTime    time.Time
+
+	mutex      sync.Mutex
+	endTime    time.Time
+	attributes map[string]interface{}
+	err        error
+}
+
+// startSpan starts a new span of the name and the kind as a child of the
+// current span in the ctx. It returns the ctx as is and a nil span if there is
+// no current span in the ctx.
+func startSpan(
+	ctx context.Context,
+	name string,
+	kind int,
+) (context.Context, *span) {
+	parent, ok := ctx.Value(spanContextKey{}).(*span)
+	if !ok {
+		return ctx, nil
+	}
+
+	s := &span{
+		tracer:       parent.tracer,
+		sc:           parent.sc,
+		parentSpanID: parent.sc.spanID,
+		name:         name,
+		kind:         kind,
+		startTime:    time.Now(),
+		attributes:   map[string]interface{}{},
+	}
+	rand.Read(s.sc.spanID[:])
+
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// contextWithSpan returns a copy of the parent with the current span of the
+// from.
+func contextWithSpan(parent, from context.Context) context.Context {
+	if s, ok := from.Value(spanContextKey{}).(*span); ok {
+		return context.WithValue(parent, spanContextKey{}, s)
+	}
+
+	return parent
+}
+
+// injectTraceparent sets the "traceparent" header of the current span in the
+// ctx to the h.
+func injectTraceparent(ctx context.Context, h http.Header) {
+	if s, ok := ctx.Value(spanContextKey{}).(*span); ok {
+		h.Set("traceparent", s.sc.traceparent())
+	}
+}
+
+// setAttribute sets the attribute of the key to the value of the s. The value
+// must be a string, an int, an int64, or a bool.
+func (s *span) setAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.attributes[key] = value
+	s.mutex.Unlock()
+}
+
+// end ends the s with the optional err.
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if !s.endTime.IsZero() {
+		s.mutex.Unlock()
+		return
+	}
+
+	s.endTime = time.Now()
+	s.err = err
+	s.mutex.Unlock()
+
+	if s.sc.sampled && s.tracer.spanChan != nil {
+		select {
+		case s.tracer.spanChan <- s:
+		default: // Drop it rather than block.
+		}
+	}
+}
+
+// otlp returns the OTLP JSON representation of the s.
+func (s *span) otlp() map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := map[string]interface{}{"code": 1}
+	if s.err != nil {
+		status = map[string]interface{}{
+			"code":    2,
+			"message": s.err.Error(),
+		}
+	}
+
+	m := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.sc.traceID[:]),
+		"spanId":            hex.EncodeToString(s.sc.spanID[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": fmt.Sprint(s.startTime.UnixNano()),
+		"endTimeUnixNano":   fmt.Sprint(s.endTime.UnixNano()),
+		"attributes":        otlpAttributes(s.attributes),
+		"status":            status,
+	}
+	if s.parentSpanID != [8]byte{} {
+		m["parentSpanId"] = hex.EncodeToString(s.parentSpanID[:])
+	}
+
+	return m
+}
+
+// otlpAttributes returns the OTLP JSON representation of the attributes.
+func otlpAttributes(attributes map[string]interface{}) []interface{} {
+	kvs := make([]interface{}, 0, len(attributes))
+	for k, v := range attributes {
+		var value map[string]interface{}
+		switch v := v.(type) {
+		case int:
+			value = map[string]interface{}{
+				"intValue": strconv.Itoa(v),
+			}
+		case int64:
+			value = map[string]interface{}{
+				"intValue": strconv.FormatInt(v, 10),
+			}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		default:
+			value = map[string]interface{}{
+				"stringValue": fmt.Sprint(v),
+			}
+		}
+
+		kvs = append(kvs, map[string]interface{}{
+			"key":   k,
+			"value": value,
+		})
+	}
+
+	return kvs
+}
+
+// tracingCacher implements the `Cacher` by tracing the underlying cacher.
+type tracingCacher struct {
+	cacher Cacher
+}
+
+// NewHash implements the `Cacher`.
+func (tc *tracingCacher) NewHash() hash.Hash {
+	return tc.cacher.NewHash()
+}
+
+// Cache implements the `Cacher`.
+func (tc *tracingCacher) Cache(
+	ctx context.Context,
+	name string,
+) (Cache, error) {
+	ctx, s := startSpan(ctx, "Cacher.Cache", spanKindInternal)
+	s.setAttribute("goproxy.cacher", cacherName(tc.cacher))
+	s.setAttribute("goproxy.cache.name", name)
+
+	c, err := tc.cacher.Cache(ctx, name)
+	if err == ErrCacheNotFound {
+		s.setAttribute("goproxy.cache.hit", false)
+		s.end(nil)
+	} else {
+		s.setAttribute("goproxy.cache.hit", err == nil)
+		s.end(err)
+	}
+
+	return c, err
+}
+
+// SetCache implements the `Cacher`.
+func (tc *tracingCacher) SetCache(ctx context.Context, c Cache) error {
+	ctx, s := startSpan(ctx, "Cacher.SetCache", spanKindInternal)
+	s.setAttribute("goproxy.cacher", cacherName(tc.cacher))
+	s.setAttribute("goproxy.cache.name", c.Name())
+	s.setAttribute("goproxy.cache.size", c.Size())
+
+	err := tc.cacher.SetCache(ctx, c)
+	s.end(err)
+
+	return err
+}
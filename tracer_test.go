@@ -0,0 +1,78 @@
+package goproxy
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := parseTraceparent(
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	)
+	assert.True(t, ok)
+	assert.True(t, sc.sampled)
+	assert.Equal(
+		t,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		sc.traceparent(),
+	)
+
+	sc, ok = parseTraceparent(
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	)
+	assert.True(t, ok)
+	assert.False(t, sc.sampled)
+
+	for _, s := range []string{
+		"",
+		"foobar",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		_, ok := parseTraceparent(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func TestTracerPropagation(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(
+		"traceparent",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	)
+
+	ctx, serverSpan := (&Tracer{}).startServerSpan(r)
+	assert.NotNil(t, serverSpan)
+	assert.Equal(
+		t,
+		"00f067aa0ba902b7",
+		hex.EncodeToString(serverSpan.parentSpanID[:]),
+	)
+
+	ctx, childSpan := startSpan(ctx, "child", spanKindClient)
+	assert.Equal(t, serverSpan.sc.traceID, childSpan.sc.traceID)
+	assert.Equal(t, serverSpan.sc.spanID, childSpan.parentSpanID)
+
+	h := http.Header{}
+	injectTraceparent(ctx, h)
+	assert.Equal(t, childSpan.sc.traceparent(), h.Get("traceparent"))
+
+	_, nilSpan := startSpan(context.Background(), "foobar", 0)
+	assert.Nil(t, nilSpan)
+	assert.NotPanics(t, func() {
+		nilSpan.setAttribute("foo", "bar")
+		nilSpan.end(nil)
+	})
+
+	var nilTracer *Tracer
+	_, nilSpan = nilTracer.startServerSpan(r)
+	assert.Nil(t, nilSpan)
+}